@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/caitlin615/logmonitor/analyze"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// runAnalyze is the analyze command, which reports on whole files rather than following them:
+//
+//	logmonitor analyze [flags] [files...]
+func runAnalyze(args []string) error {
+	opts := analyze.DefaultOptions
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: logmonitor analyze [flags] [files...]")
+		fmt.Fprintln(fs.Output(), "Reports on every line in the files, which can be globs or compressed, or stdin if there aren't any.")
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "Only analyze lines at or after this time, such as \"2018-05-09 14:00\" or \"14:00\" on any day (UTC)")
+	to := fs.String("to", "", "Only analyze lines before this time, in the same format as -from")
+	format := fs.String("format", "common", "Format of the log lines: common, combined or timed")
+	report := fs.String("output", "text", "Format of the report: text, json or csv")
+	fs.DurationVar(&opts.Resolution, "resolution", opts.Resolution, "Length of each period of the traffic over time")
+	routes := fs.String("routes", "", "Route patterns such as /api/v1/orders/:id that the top routes are counted by, separated by commas")
+	fs.IntVar(&opts.SectionDepth, "section-depth", opts.SectionDepth, "Number of segments of the path in a section, such as 2 for /api/user")
+	fs.IntVar(&opts.TopN, "top", opts.TopN, "Number of entries in each of the top lists")
+	fs.Int64Var(&opts.Threshold, "threshold", opts.Threshold, "Average requests per second that triggers the high traffic alert")
+	fs.DurationVar(&opts.Window, "window", opts.Window, "Period of time the requests are averaged over for the high traffic alert")
+	fs.DurationVar(&opts.Interval, "interval", opts.Interval, "How often the high traffic alert checks the traffic")
+	fs.Parse(args)
+
+	switch *report {
+	case "text", "json", "csv":
+	default:
+		return fmt.Errorf("unknown report format %q, expected text, json or csv", *report)
+	}
+	if opts.SectionDepth < 1 {
+		return fmt.Errorf("-section-depth should be at least 1, got %d", opts.SectionDepth)
+	}
+	if opts.Resolution < time.Second {
+		return fmt.Errorf("-resolution should be at least 1s, got %s", opts.Resolution)
+	}
+	var err error
+	if opts.Range, err = analyze.ParseRange(*from, *to); err != nil {
+		return err
+	}
+	if *routes != "" {
+		if opts.Router, err = log.NewRouter(strings.Split(*routes, ","), true); err != nil {
+			return err
+		}
+	}
+	logFormat, err := log.FormatByName(*format)
+	if err != nil {
+		return err
+	}
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{log.Stdin}
+	}
+
+	r, err := analyze.Run(context.Background(), patterns, logFormat, opts)
+	if err != nil {
+		return err
+	}
+	return r.Write(os.Stdout, *report)
+}
@@ -0,0 +1,361 @@
+// Package analyze reads whole log files and reports on what happened in them, by the dates
+// in the lines rather than when they are read, for questions such as "what happened in this
+// log between 14:00 and 15:00".
+package analyze
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// Options are what the Analyzer reports on
+type Options struct {
+	// Range is the time the lines are analyzed in, lines outside of it are skipped
+	Range Range
+	// Resolution is the length of each bucket of the traffic over time
+	Resolution time.Duration
+	// TopN is the number of entries in each of the top lists
+	TopN int
+	// SectionDepth is the number of segments of the path in the top sections, see log.LineRequest.SectionAt
+	SectionDepth int
+	// Router is what the top routes are counted by, the log.DefaultRouter if it is nil
+	Router *log.Router
+
+	// Threshold, Window and Interval are the options of the high traffic alert that is
+	// evaluated over the lines, the same as for the "alert" listener
+	Threshold int64
+	Window    time.Duration
+	Interval  time.Duration
+}
+
+// DefaultOptions are the Options of an Analyzer that reports on every line at a 1 minute
+// resolution, with the defaults of the "alert" listener
+var DefaultOptions = Options{
+	Resolution:   time.Minute,
+	TopN:         10,
+	SectionDepth: 1,
+	Threshold:    10,
+	Window:       2 * time.Minute,
+	Interval:     10 * time.Second,
+}
+
+// Analyzer counts the lines it is given for a Report. It keeps counts rather than lines,
+// so it can analyze files that are larger than memory.
+type Analyzer struct {
+	opts Options
+
+	hits       int
+	undated    int
+	bytes      int64
+	first      time.Time
+	last       time.Time
+	perSecond  map[int64]int // hits by unix time
+	buckets    map[int64]*TrafficBucket
+	counts     map[string]map[string]int
+	errorStats *log.ErrorStats
+}
+
+// counted are the keys that lines are counted by, with the names of the lists in the Report.
+// The sections and routes are counted by the Options as well.
+var counted = []struct {
+	name string
+	key  log.KeyFunc
+}{
+	{"users", log.ByUser},
+	{"ip_addresses", log.ByIPAddress},
+	{"sources", log.BySource},
+	{"status_codes", log.ByStatusCode},
+	{"status_classes", log.ByStatusClass},
+	{"methods", log.ByMethod},
+}
+
+// New returns an Analyzer with the options
+func New(opts Options) *Analyzer {
+	a := &Analyzer{
+		opts:       opts,
+		perSecond:  make(map[int64]int),
+		buckets:    make(map[int64]*TrafficBucket),
+		counts:     make(map[string]map[string]int),
+		errorStats: log.NewErrorStats(),
+	}
+	if a.opts.SectionDepth < 1 {
+		a.opts.SectionDepth = 1
+	}
+	if a.opts.Router == nil {
+		a.opts.Router = log.DefaultRouter
+	}
+	for _, c := range counted {
+		a.counts[c.name] = make(map[string]int)
+	}
+	a.counts["sections"] = make(map[string]int)
+	a.counts["routes"] = make(map[string]int)
+	return a
+}
+
+// Run analyzes every line in the files that match the patterns, which are read like
+// log.Channel.ReplayFiles, including log.Stdin and compressed files
+func Run(ctx context.Context, patterns []string, format *log.Format, opts Options) (*Report, error) {
+	a := New(opts)
+	lines := make(log.Channel)
+	errs := make(log.ErrorChannel)
+	if err := lines.ReplayFiles(ctx, patterns, format, errs); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		for err := range errs {
+			a.AddError(err)
+		}
+		close(done)
+	}()
+	for line := range lines {
+		a.Add(line)
+	}
+	close(errs)
+	<-done
+	return a.Report(), nil
+}
+
+// Add counts the line if it's in the range
+func (a *Analyzer) Add(line log.Line) {
+	if !a.opts.Range.Contains(line.Date) {
+		return
+	}
+	a.hits++
+	a.bytes += int64(line.Size)
+	for _, c := range counted {
+		if key, ok := c.key(line); ok {
+			a.counts[c.name][key]++
+		}
+	}
+	if section, ok := log.BySectionDepth(a.opts.SectionDepth)(line); ok {
+		a.counts["sections"][section]++
+	}
+	if route, ok := a.opts.Router.Key(line); ok {
+		a.counts["routes"][route]++
+	}
+
+	// Lines without a date can't be placed in time
+	if line.Date.IsZero() {
+		a.undated++
+		return
+	}
+	date := line.Date.UTC()
+	if a.first.IsZero() || date.Before(a.first) {
+		a.first = date
+	}
+	if date.After(a.last) {
+		a.last = date
+	}
+	a.perSecond[date.Unix()]++
+	start := date.Truncate(a.opts.Resolution)
+	bucket, ok := a.buckets[start.Unix()]
+	if !ok {
+		bucket = &TrafficBucket{Start: start}
+		a.buckets[start.Unix()] = bucket
+	}
+	bucket.Hits++
+	bucket.Bytes += int64(line.Size)
+}
+
+// AddError counts an error reading or parsing the files
+func (a *Analyzer) AddError(err error) {
+	a.errorStats.Add(err)
+}
+
+// Report returns the report on the lines that have been added so far
+func (a *Analyzer) Report() *Report {
+	r := &Report{
+		Start:      a.first,
+		End:        a.last,
+		Hits:       a.hits,
+		Undated:    a.undated,
+		Bytes:      a.bytes,
+		Resolution: a.opts.Resolution,
+		Traffic:    []TrafficBucket{},
+		Alerts:     a.alerts(),
+		Invalid:    a.errorStats.Total(),
+		Errors:     a.errorStats.Counts(),
+	}
+	if a.hits > 0 {
+		r.AverageBytes = float64(a.bytes) / float64(a.hits)
+	}
+
+	// Every bucket from the first to the last is reported, including the ones without traffic
+	if !a.first.IsZero() {
+		for t := a.first.Truncate(a.opts.Resolution); !t.After(a.last); t = t.Add(a.opts.Resolution) {
+			bucket := TrafficBucket{Start: t}
+			if b, ok := a.buckets[t.Unix()]; ok {
+				bucket = *b
+			}
+			r.Traffic = append(r.Traffic, bucket)
+		}
+	}
+
+	r.TopSections = top(a.counts["sections"], a.opts.TopN)
+	r.TopRoutes = top(a.counts["routes"], a.opts.TopN)
+	r.TopUsers = top(a.counts["users"], a.opts.TopN)
+	r.TopIPAddresses = top(a.counts["ip_addresses"], a.opts.TopN)
+	r.TopSources = top(a.counts["sources"], a.opts.TopN)
+	r.StatusCodes = byKey(a.counts["status_codes"])
+	r.StatusClasses = byKey(a.counts["status_classes"])
+	r.Methods = top(a.counts["methods"], 0)
+	return r
+}
+
+// alerts returns the events the high traffic alert would have sent, if it checked the traffic
+// every interval from the first line until an interval after the last line. The traffic is
+// compared with the threshold the same way as by listeners.Alert.
+func (a *Analyzer) alerts() []Alert {
+	alerts := []Alert{}
+	if a.first.IsZero() || a.opts.Interval <= 0 || a.opts.Window < time.Second {
+		return alerts
+	}
+	seconds := make([]int64, 0, len(a.perSecond))
+	for s := range a.perSecond {
+		seconds = append(seconds, s)
+	}
+	sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+
+	// count is the number of hits in the window (now-window, now], the seconds between
+	// the indexes oldest and next are in the window
+	var count int64
+	oldest, next := 0, 0
+	highTraffic := false
+	end := a.last.Add(a.opts.Interval)
+	for now := a.first.Add(a.opts.Interval); !now.After(end); now = now.Add(a.opts.Interval) {
+		start := now.Add(-a.opts.Window)
+		for next < len(seconds) && seconds[next] <= now.Unix() {
+			count += int64(a.perSecond[seconds[next]])
+			next++
+		}
+		for oldest < next && seconds[oldest] <= start.Unix() {
+			count -= int64(a.perSecond[seconds[oldest]])
+			oldest++
+		}
+
+		reqPerSecond := count / int64(a.opts.Window.Seconds())
+		traffic := listeners.AlertTraffic{
+			Timestamp:    now,
+			Window:       listeners.Window{Start: start, End: now},
+			Hits:         count,
+			ReqPerSecond: reqPerSecond,
+			Threshold:    a.opts.Threshold,
+		}
+		switch {
+		case !highTraffic && reqPerSecond > a.opts.Threshold:
+			highTraffic = true
+			alerts = append(alerts, Alert{Event: listeners.AlertTriggered{AlertTraffic: traffic}})
+		case highTraffic && reqPerSecond <= a.opts.Threshold:
+			highTraffic = false
+			alerts = append(alerts, Alert{Event: listeners.AlertRecovered{AlertTraffic: traffic}})
+		}
+	}
+	return alerts
+}
+
+// top returns the n keys with the most hits, or all of them if n is 0
+func top(counts map[string]int, n int) []listeners.SummaryReportItem {
+	items := byKey(counts)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Value > items[j].Value })
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+// byKey returns the counts sorted by their key
+func byKey(counts map[string]int) []listeners.SummaryReportItem {
+	items := make([]listeners.SummaryReportItem, 0, len(counts))
+	for k, v := range counts {
+		items = append(items, listeners.SummaryReportItem{Key: k, Value: v})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items
+}
+
+// Range is a range of time, either between two times or between two times of day on any day.
+// The zero Range contains every time.
+type Range struct {
+	From, To time.Time
+	// Daily is true when From and To are times of day, with their dates ignored
+	Daily bool
+}
+
+// rangeFormats are the formats that ParseRange accepts, the ones without a date are times of day
+var rangeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var timeOfDayFormats = []string{"15:04:05", "15:04"}
+
+// ParseRange returns the Range from and to, which are either empty, dates and times such as
+// "2018-05-09 14:00" or times of day such as "14:00". Times without a time zone are in UTC.
+// Both of them need to be times of day, or neither.
+func ParseRange(from, to string) (Range, error) {
+	var r Range
+	var fromDaily, toDaily bool
+	var err error
+	if r.From, fromDaily, err = parseRangeTime(from); err != nil {
+		return r, err
+	}
+	if r.To, toDaily, err = parseRangeTime(to); err != nil {
+		return r, err
+	}
+	if from != "" && to != "" && fromDaily != toDaily {
+		return r, fmt.Errorf("from and to should both be times of day, or both be dates")
+	}
+	r.Daily = fromDaily || toDaily
+	if from != "" && to != "" && !r.To.After(r.From) {
+		return r, fmt.Errorf("to should be after from")
+	}
+	return r, nil
+}
+
+func parseRangeTime(value string) (t time.Time, daily bool, err error) {
+	if value == "" {
+		return
+	}
+	for _, format := range timeOfDayFormats {
+		if t, err = time.Parse(format, value); err == nil {
+			return t, true, nil
+		}
+	}
+	for _, format := range rangeFormats {
+		if t, err = time.Parse(format, value); err == nil {
+			return t, false, nil
+		}
+	}
+	return t, false, fmt.Errorf("invalid time %q, expected a time such as \"2018-05-09 14:00\" or \"14:00\"", value)
+}
+
+// Contains returns true if the time is at or after From and before To. Times that are
+// zero, such as lines without a date, are only in the zero Range.
+func (r Range) Contains(t time.Time) bool {
+	if r.From.IsZero() && r.To.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+	t = t.UTC()
+	if r.Daily {
+		// Compare the times as durations since midnight
+		sinceMidnight := func(t time.Time) time.Duration {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+		}
+		day := sinceMidnight(t)
+		return (r.From.IsZero() || day >= sinceMidnight(r.From)) && (r.To.IsZero() || day < sinceMidnight(r.To))
+	}
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
@@ -0,0 +1,189 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+var start = time.Date(2018, 5, 9, 14, 0, 0, 0, time.UTC)
+
+func testLine(date time.Time, url string, status int) log.Line {
+	return log.Line{
+		IPAddress:  "127.0.0.1",
+		UserID:     "frank",
+		Date:       date,
+		Request:    log.LineRequest{Method: "GET", URL: url, Protocol: "HTTP/1.0"},
+		StatusCode: status,
+		Size:       100,
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("2018-05-09 14:00", "2018-05-09T15:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Daily || !r.From.Equal(start) || !r.To.Equal(start.Add(time.Hour)) {
+		t.Errorf("bad range: %+v", r)
+	}
+	if !r.Contains(start) || !r.Contains(start.Add(59*time.Minute)) || r.Contains(start.Add(time.Hour)) || r.Contains(time.Time{}) {
+		t.Errorf("range %+v contains the wrong times", r)
+	}
+
+	// Times of day are in the range on any day
+	r, err = ParseRange("14:00", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Daily || !r.Contains(start.AddDate(0, 1, 0)) || r.Contains(start.Add(-time.Second)) {
+		t.Errorf("range %+v contains the wrong times", r)
+	}
+
+	// The zero range contains every time, including lines without a date
+	if !(Range{}).Contains(time.Time{}) {
+		t.Error("expected the zero range to contain the zero time")
+	}
+
+	for _, c := range [][2]string{
+		{"yesterday", ""},
+		{"14:00", "2018-05-09"},
+		{"15:00", "14:00"},
+	} {
+		if _, err := ParseRange(c[0], c[1]); err == nil {
+			t.Errorf("expected an error parsing range %q", c)
+		}
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	opts := DefaultOptions
+	opts.Range = Range{From: start, To: start.Add(time.Hour)}
+	a := New(opts)
+
+	a.Add(testLine(start.Add(-time.Second), "/skipped", 200))
+	a.Add(testLine(start, "/report/daily", 200))
+	a.Add(testLine(start.Add(30*time.Second), "/report/weekly", 404))
+	a.Add(testLine(start.Add(3*time.Minute), "/api/user", 500))
+	a.AddError(&log.Error{Stage: log.StageParse, Reason: log.ReasonNoMatch, Raw: "garbage"})
+
+	r := a.Report()
+	if r.Hits != 3 || r.Bytes != 300 || r.AverageBytes != 100 || r.Invalid != 1 {
+		t.Errorf("bad totals: %+v", r)
+	}
+	if !r.Start.Equal(start) || !r.End.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("bad start and end: %s %s", r.Start, r.End)
+	}
+
+	// The minutes without traffic are reported too
+	hits := []int{}
+	for _, b := range r.Traffic {
+		hits = append(hits, b.Hits)
+	}
+	if len(hits) != 4 || hits[0] != 2 || hits[1] != 0 || hits[2] != 0 || hits[3] != 1 {
+		t.Errorf("bad traffic: %v", hits)
+	}
+
+	if len(r.TopSections) != 2 || r.TopSections[0] != (listeners.SummaryReportItem{Key: "/report", Value: 2}) {
+		t.Errorf("bad top sections: %v", r.TopSections)
+	}
+	if len(r.TopRoutes) != 3 || r.TopRoutes[0].Key != "/api/user" {
+		t.Errorf("bad top routes: %v", r.TopRoutes)
+	}
+	if len(r.StatusCodes) != 3 || r.StatusCodes[0].Key != "200" || r.StatusCodes[2].Key != "500" {
+		t.Errorf("bad status codes: %v", r.StatusCodes)
+	}
+	if len(r.Alerts) != 0 {
+		t.Errorf("expected no alerts, got: %v", r.Alerts)
+	}
+}
+
+func TestAnalyzerAlerts(t *testing.T) {
+	opts := DefaultOptions
+	opts.Threshold = 5
+	opts.Window = 10 * time.Second
+	opts.Interval = 10 * time.Second
+	a := New(opts)
+
+	// A minute of 1 request per second, then 20 requests per second for 30 seconds,
+	// then another minute of 1 request per second
+	for s := 0; s < 150; s++ {
+		n := 1
+		if s >= 60 && s < 90 {
+			n = 20
+		}
+		for i := 0; i < n; i++ {
+			a.Add(testLine(start.Add(time.Duration(s)*time.Second), "/report", 200))
+		}
+	}
+
+	alerts := a.Report().Alerts
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got: %v", alerts)
+	}
+	triggered, ok := alerts[0].Event.(listeners.AlertTriggered)
+	if !ok || !triggered.Timestamp.Equal(start.Add(70*time.Second)) || triggered.Hits != 200 {
+		t.Errorf("bad triggered alert: %+v", alerts[0].Event)
+	}
+	recovered, ok := alerts[1].Event.(listeners.AlertRecovered)
+	if !ok || !recovered.Timestamp.Equal(start.Add(100*time.Second)) {
+		t.Errorf("bad recovered alert: %+v", alerts[1].Event)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	a := New(DefaultOptions)
+	a.Add(testLine(start, "/report/daily", 200))
+	r := a.Report()
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["hits"] != float64(1) {
+		t.Errorf("bad json report: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := r.Write(&buf, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, row := range rows {
+		if len(row) != 3 {
+			t.Fatalf("bad csv row: %v", row)
+		}
+		if row[0] == "top_sections" && row[1] == "/report" && row[2] == "1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the top section in the csv report, got: %v", rows)
+	}
+
+	buf.Reset()
+	if err := r.Write(&buf, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "* /report: 1") {
+		t.Errorf("expected the top section in the text report, got: %s", buf.String())
+	}
+
+	if err := r.Write(&buf, "xml"); err == nil {
+		t.Error("expected an error writing an unknown format")
+	}
+}
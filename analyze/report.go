@@ -0,0 +1,198 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+	"github.com/caitlin615/logmonitor/output"
+)
+
+// Report is what happened in the lines given to an Analyzer
+type Report struct {
+	// Start and End are the dates of the first and last lines
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Hits         int       `json:"hits"`
+	Undated      int       `json:"undated"`
+	Bytes        int64     `json:"bytes"`
+	AverageBytes float64   `json:"average_bytes"`
+
+	Resolution time.Duration   `json:"-"`
+	Traffic    []TrafficBucket `json:"traffic"`
+
+	TopSections    []listeners.SummaryReportItem `json:"top_sections"`
+	TopRoutes      []listeners.SummaryReportItem `json:"top_routes"`
+	TopUsers       []listeners.SummaryReportItem `json:"top_users"`
+	TopIPAddresses []listeners.SummaryReportItem `json:"top_ip_addresses"`
+	TopSources     []listeners.SummaryReportItem `json:"top_sources"`
+	StatusCodes    []listeners.SummaryReportItem `json:"status_codes"`
+	StatusClasses  []listeners.SummaryReportItem `json:"status_classes"`
+	Methods        []listeners.SummaryReportItem `json:"methods"`
+
+	// Alerts are the events the high traffic alert would have sent
+	Alerts []Alert `json:"alerts"`
+
+	// Invalid is the number of lines that couldn't be read or parsed
+	Invalid int              `json:"invalid"`
+	Errors  []log.ErrorCount `json:"errors"`
+}
+
+// TrafficBucket is the traffic in a period of time as long as the resolution of the Report
+type TrafficBucket struct {
+	Start time.Time `json:"start"`
+	Hits  int       `json:"hits"`
+	Bytes int64     `json:"bytes"`
+}
+
+// Alert is an AlertTriggered or AlertRecovered event, which is encoded to JSON the same way
+// as by the JSON output
+type Alert struct {
+	listeners.Event
+}
+
+// MarshalJSON is part of the json.Marshaler interface
+func (a Alert) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (output.JSON{}).Render(&buf, a.Event); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// Write writes the Report in the format, either "text", "json" or "csv"
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return r.WriteText(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "csv":
+		return r.WriteCSV(w)
+	}
+	return fmt.Errorf("unknown report format %q, expected text, json or csv", format)
+}
+
+// WriteText writes the Report for people to read
+func (r *Report) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	if r.Hits == 0 {
+		ew.printf("No requests found\n")
+	} else {
+		ew.printf("%d requests from %s to %s, %d bytes (%.0f on average)\n", r.Hits, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Bytes, r.AverageBytes)
+	}
+	if r.Undated > 0 {
+		ew.printf("%d requests didn't have a date\n", r.Undated)
+	}
+	if r.Invalid > 0 {
+		ew.printf("%d lines could not be read or parsed\n", r.Invalid)
+	}
+
+	ew.printf("\nTraffic every %s:\n", r.Resolution)
+	for _, b := range r.Traffic {
+		ew.printf("* %s  %d requests, %d bytes\n", b.Start.Format(time.RFC3339), b.Hits, b.Bytes)
+	}
+	for _, list := range []struct {
+		title string
+		items []listeners.SummaryReportItem
+	}{
+		{"Top sections", r.TopSections},
+		{"Top routes", r.TopRoutes},
+		{"Top users", r.TopUsers},
+		{"Top IP addresses", r.TopIPAddresses},
+		{"Top sources", r.TopSources},
+		{"Status codes", r.StatusCodes},
+		{"Status classes", r.StatusClasses},
+		{"Methods", r.Methods},
+	} {
+		if len(list.items) == 0 {
+			continue
+		}
+		ew.printf("\n%s:\n", list.title)
+		for _, item := range list.items {
+			ew.printf("* %s: %d\n", item.Key, item.Value)
+		}
+	}
+
+	ew.printf("\nAlerts:\n")
+	if len(r.Alerts) == 0 {
+		ew.printf("* none\n")
+	}
+	for _, a := range r.Alerts {
+		ew.printf("* %s\n", a.Event)
+	}
+	return ew.err
+}
+
+// WriteCSV writes the Report as rows of table,key,value, such as traffic,2018-05-09T16:00:00Z,42
+// for the hits in each bucket. The tables are summary, traffic, traffic_bytes, the top lists and
+// breakdowns by their JSON names, and the alert event types with the hits in the window.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	row := func(table, key string, value interface{}) {
+		cw.Write([]string{table, key, fmt.Sprint(value)})
+	}
+	row("table", "key", "value")
+	row("summary", "start", r.Start.Format(time.RFC3339))
+	row("summary", "end", r.End.Format(time.RFC3339))
+	row("summary", "hits", r.Hits)
+	row("summary", "undated", r.Undated)
+	row("summary", "bytes", r.Bytes)
+	row("summary", "average_bytes", strconv.FormatFloat(r.AverageBytes, 'f', 2, 64))
+	row("summary", "invalid", r.Invalid)
+	for _, b := range r.Traffic {
+		row("traffic", b.Start.Format(time.RFC3339), b.Hits)
+	}
+	for _, b := range r.Traffic {
+		row("traffic_bytes", b.Start.Format(time.RFC3339), b.Bytes)
+	}
+	for _, list := range []struct {
+		table string
+		items []listeners.SummaryReportItem
+	}{
+		{"top_sections", r.TopSections},
+		{"top_routes", r.TopRoutes},
+		{"top_users", r.TopUsers},
+		{"top_ip_addresses", r.TopIPAddresses},
+		{"top_sources", r.TopSources},
+		{"status_codes", r.StatusCodes},
+		{"status_classes", r.StatusClasses},
+		{"methods", r.Methods},
+	} {
+		for _, item := range list.items {
+			row(list.table, item.Key, item.Value)
+		}
+	}
+	for _, a := range r.Alerts {
+		hits := int64(0)
+		switch e := a.Event.(type) {
+		case listeners.AlertTriggered:
+			hits = e.Hits
+		case listeners.AlertRecovered:
+			hits = e.Hits
+		}
+		row(a.Type(), a.Time().Format(time.RFC3339), hits)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// errWriter keeps the first error writing, so it only needs to be checked at the end
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
@@ -0,0 +1,246 @@
+// Package config reads the configuration file that declares which listeners run,
+// their options and which sinks each of them writes its reports to.
+//
+// The file is written in a subset of TOML:
+//
+//	[input]
+//	path = ["/var/log/access.log", "/var/log/nginx/*.access.log"]
+//	format = "combined"
+//	on_error = "warn"
+//
+//	[[sink]]
+//	name = "console"
+//	type = "stdout"
+//	format = "text"
+//
+//	[[listener]]
+//	type = "summary"
+//	interval = "10s"
+//	sinks = ["console"]
+//
+//	[[listener]]
+//	name = "high-traffic"
+//	type = "alert"
+//	threshold = 10
+//	sinks = ["console"]
+//
+// A listener with a source only receives the lines read from the inputs that match it.
+// Every key other than name, type, sinks and source is passed to the listener or sink as an option.
+// The input table is optional, the command line flags are used for anything it doesn't set.
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// Config is the parsed configuration file
+type Config struct {
+	Input     InputConfig
+	Listeners []ListenerConfig
+	Sinks     []SinkConfig
+}
+
+// InputConfig sets what is read and how it is parsed, fields that are empty aren't set in the file
+type InputConfig struct {
+	// Paths are the log files to read, either paths or globs such as /var/log/nginx/*.access.log
+	Paths []string
+	// Format is the name of the log format, see log.FormatByName
+	Format string
+	// OnError is what to do with lines that can't be read or parsed, see log.ParseErrorPolicy
+	OnError string
+	// SyslogUDP and SyslogTCP are the addresses to receive syslog messages on, see syslog.Listen
+	SyslogUDP string
+	SyslogTCP string
+}
+
+// ListenerConfig declares a listener from the registry, see listeners.Register
+type ListenerConfig struct {
+	// Name identifies the listener, it defaults to the type
+	Name string
+	// Type is the name the listener is registered with
+	Type string
+	// Sinks are the names of the sinks that the listener's reports are written to
+	Sinks []string
+	// Source is a pattern for the sources of the lines the listener receives, in the syntax
+	// of filepath.Match. It is empty for the listener to receive every line.
+	Source  string
+	Options listeners.Options
+}
+
+// SinkConfig declares a sink from the output registry, see output.RegisterSink
+type SinkConfig struct {
+	// Name identifies the sink for the listeners, it defaults to the type
+	Name string
+	// Type is the name the sink is registered with
+	Type    string
+	Options listeners.Options
+}
+
+// Load reads the configuration file at path
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse reads a configuration file from r
+func Parse(r io.Reader) (*Config, error) {
+	doc, err := parseTOML(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	for key, value := range doc {
+		switch key {
+		case "input":
+			if _, ok := value.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s should be declared with [%s]", key, key)
+			}
+		case "listener", "sink":
+			if _, ok := value.([]map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s should be declared with [[%s]]", key, key)
+			}
+		default:
+			return nil, fmt.Errorf("unknown section %q", key)
+		}
+	}
+
+	if t, ok := doc["input"].(map[string]interface{}); ok {
+		if cfg.Input, err = parseInput(listeners.Options(t)); err != nil {
+			return nil, fmt.Errorf("input: %v", err)
+		}
+	}
+
+	listenerTables, _ := doc["listener"].([]map[string]interface{})
+	for i, t := range listenerTables {
+		name, typ, opts, err := splitTable(t)
+		if err != nil {
+			return nil, fmt.Errorf("listener %d: %v", i+1, err)
+		}
+		sinks, err := opts.Strings("sinks")
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: %v", name, err)
+		}
+		source, err := opts.String("source", "")
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: %v", name, err)
+		}
+		delete(opts, "sinks")
+		delete(opts, "source")
+		cfg.Listeners = append(cfg.Listeners, ListenerConfig{Name: name, Type: typ, Sinks: sinks, Source: source, Options: opts})
+	}
+
+	sinkTables, _ := doc["sink"].([]map[string]interface{})
+	for i, t := range sinkTables {
+		name, typ, opts, err := splitTable(t)
+		if err != nil {
+			return nil, fmt.Errorf("sink %d: %v", i+1, err)
+		}
+		cfg.Sinks = append(cfg.Sinks, SinkConfig{Name: name, Type: typ, Options: opts})
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func parseInput(opts listeners.Options) (input InputConfig, err error) {
+	if err = opts.CheckKeys("path", "format", "on_error", "syslog_udp", "syslog_tcp"); err != nil {
+		return
+	}
+	if input.SyslogUDP, err = opts.String("syslog_udp", ""); err != nil {
+		return
+	}
+	if input.SyslogTCP, err = opts.String("syslog_tcp", ""); err != nil {
+		return
+	}
+	// A single path doesn't need to be in a list
+	if path, ok := opts["path"].(string); ok {
+		input.Paths = []string{path}
+	} else if input.Paths, err = opts.Strings("path"); err != nil {
+		return
+	}
+	if input.Format, err = opts.String("format", ""); err != nil {
+		return
+	}
+	input.OnError, err = opts.String("on_error", "")
+	return
+}
+
+// splitTable separates the name and type of a listener or sink from the rest of its options
+func splitTable(t map[string]interface{}) (name, typ string, opts listeners.Options, err error) {
+	opts = make(listeners.Options)
+	for k, v := range t {
+		opts[k] = v
+	}
+	if typ, err = opts.String("type", ""); err != nil {
+		return
+	}
+	if typ == "" {
+		err = fmt.Errorf("type is required")
+		return
+	}
+	if name, err = opts.String("name", typ); err != nil {
+		return
+	}
+	delete(opts, "type")
+	delete(opts, "name")
+	return
+}
+
+// Validate checks that the input is valid, the names are unique, the listeners' options are valid
+// and every listener writes to sinks that are declared
+func (c *Config) Validate() error {
+	if c.Input.Format != "" {
+		if _, err := log.FormatByName(c.Input.Format); err != nil {
+			return fmt.Errorf("input: %v", err)
+		}
+	}
+	if c.Input.OnError != "" {
+		if _, err := log.ParseErrorPolicy(c.Input.OnError); err != nil {
+			return fmt.Errorf("input: %v", err)
+		}
+	}
+
+	sinks := make(map[string]bool)
+	for _, s := range c.Sinks {
+		if sinks[s.Name] {
+			return fmt.Errorf("sink %q is declared more than once, give them different names", s.Name)
+		}
+		sinks[s.Name] = true
+	}
+
+	names := make(map[string]bool)
+	for _, l := range c.Listeners {
+		if names[l.Name] {
+			return fmt.Errorf("listener %q is declared more than once, give them different names", l.Name)
+		}
+		names[l.Name] = true
+		if _, err := filepath.Match(l.Source, ""); err != nil {
+			return fmt.Errorf("listener %q: source %q: %v", l.Name, l.Source, err)
+		}
+		// Creating the listener checks its type and options, it doesn't start it
+		if _, err := listeners.New(l.Type, l.Options); err != nil {
+			return fmt.Errorf("listener %q: %v", l.Name, err)
+		}
+		for _, s := range l.Sinks {
+			if !sinks[s] {
+				return fmt.Errorf("listener %q writes to sink %q, which isn't declared", l.Name, s)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+[input]
+path = "/var/log/nginx/*.access.log"
+format = "combined"
+syslog_udp = ":514"
+
+# Reports go to the console and to a file for the log shipper
+[[sink]]
+name = "console"
+type = "stdout"
+
+[[sink]]
+name = "shipper"
+type = "file"
+path = '/var/log/logmonitor.ndjson'
+format = "json"
+
+[[listener]]
+type = "summary"
+interval = "30s" # report less often
+sinks = ["console", "shipper"]
+
+[[listener]]
+name = "high-traffic"
+type = "alert"
+threshold = 1_000
+source = "/var/log/nginx/shop.*"
+sinks = ["console"]
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Input.Paths) != 1 || cfg.Input.Paths[0] != "/var/log/nginx/*.access.log" || cfg.Input.Format != "combined" || cfg.Input.OnError != "" || cfg.Input.SyslogUDP != ":514" {
+		t.Errorf("bad input: %+v", cfg.Input)
+	}
+	if len(cfg.Sinks) != 2 || cfg.Sinks[1].Name != "shipper" || cfg.Sinks[1].Options["path"] != "/var/log/logmonitor.ndjson" {
+		t.Errorf("bad sinks: %+v", cfg.Sinks)
+	}
+	if _, ok := cfg.Sinks[1].Options["name"]; ok {
+		t.Errorf("name shouldn't be passed as an option: %+v", cfg.Sinks[1])
+	}
+
+	if len(cfg.Listeners) != 2 {
+		t.Fatalf("bad listeners: %+v", cfg.Listeners)
+	}
+	summary := cfg.Listeners[0]
+	if summary.Name != "summary" || summary.Type != "summary" || len(summary.Sinks) != 2 {
+		t.Errorf("bad summary listener: %+v", summary)
+	}
+	if interval, err := summary.Options.Duration("interval", 0); err != nil || interval != 30*time.Second {
+		t.Errorf("bad summary interval: %v %v", interval, err)
+	}
+	if _, ok := summary.Options["sinks"]; ok {
+		t.Errorf("sinks shouldn't be passed as an option: %+v", summary)
+	}
+	alert := cfg.Listeners[1]
+	if threshold, err := alert.Options.Int("threshold", 0); err != nil || alert.Name != "high-traffic" || threshold != 1000 || alert.Source != "/var/log/nginx/shop.*" {
+		t.Errorf("bad alert listener: %+v", alert)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for config, expected := range map[string]string{
+		"[[listener]]\ninterval = \"10s\"":                             "listener 1: type is required",
+		"[[listener]]\ntype = \"summary\"\nsinks = [\"nowhere\"]":      `listener "summary" writes to sink "nowhere", which isn't declared`,
+		"[[sink]]\ntype = \"stdout\"\n[[sink]]\ntype = \"stdout\"":     `sink "stdout" is declared more than once`,
+		"[listener]\ntype = \"summary\"":                               "listener should be declared with [[listener]]",
+		"[inputs]":                                                     `unknown section "inputs"`,
+		"[[input]]":                                                    "input should be declared with [input]",
+		"[input]\nformat = \"apache\"":                                 `input: unknown log format "apache", expected one of combined, common, timed`,
+		"[input]\non_error = \"panic\"":                                `input: unknown error policy "panic"`,
+		"[input]\nfile = \"access.log\"":                               "input: unknown option(s) file",
+		"[[listener]]\ntype = \"summary\"\ninterval = \"soon\"":        `listener "summary": interval should be a duration such as "10s", got "soon"`,
+		"[[listener]]\ntype = \"alert\"\nthreshold = -1":               `listener "alert": threshold should not be negative`,
+		"[[listener]]\ntype = \"sumary\"":                              `listener "sumary": unknown listener type "sumary"`,
+		"[[listener]]\ntype = summary":                                 "line 2: type: invalid value \"summary\", strings need to be quoted",
+		"[[listener]]\ntype = \"summary\"\ntype = \"alert\"":           `line 3: "type" is defined more than once`,
+		"[[listener]]\ntype = \"summary\"\nsinks = [\"a\" \"b\"]":      "line 3: sinks: expected , or ] in array",
+		"[[listener]]\ntype = \"summary\"\nsinks = \"stdout\"":         `listener "summary": sinks should be a list of strings`,
+		"[[listener]]\ntype = \"unterminated":                          "line 2: type: unterminated string",
+		"[[listener]]\ntype = \"summary\"\ninterval = \"10s\" \"20s\"": `line 3: interval: unexpected`,
+	} {
+		_, err := Parse(strings.NewReader(config))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing %q for:\n%s\ngot: %v", expected, config, err)
+		}
+	}
+}
+
+func TestParseTOMLValues(t *testing.T) {
+	doc, err := parseTOML(strings.NewReader(`
+[values]
+basic = "a \"quoted\" # not a comment"
+literal = 'C:\logs'
+int = -42
+float = 0.5
+yes = true
+list = [1, "two", false]
+empty = []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := doc["values"].(map[string]interface{})
+	if values["basic"] != `a "quoted" # not a comment` {
+		t.Errorf("bad basic string: %q", values["basic"])
+	}
+	if values["literal"] != `C:\logs` {
+		t.Errorf("bad literal string: %q", values["literal"])
+	}
+	if values["int"] != int64(-42) || values["float"] != 0.5 || values["yes"] != true {
+		t.Errorf("bad scalars: %v", values)
+	}
+	if list := values["list"].([]interface{}); len(list) != 3 || list[1] != "two" || list[2] != false {
+		t.Errorf("bad list: %v", list)
+	}
+	if empty := values["empty"].([]interface{}); len(empty) != 0 {
+		t.Errorf("bad empty list: %v", empty)
+	}
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML (https://toml.io) that the configuration file uses:
+//
+//   - comments starting with #
+//   - tables, [name], and arrays of tables, [[name]], with names that aren't dotted
+//   - key = value pairs where the value is a string ("basic" or 'literal'), an integer,
+//     a float, a boolean or a single line array of those
+//
+// Tables are returned as a map[string]interface{} and arrays of tables as a []map[string]interface{}.
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "[["):
+			current, err = arrayTable(root, line)
+		case strings.HasPrefix(line, "["):
+			current, err = table(root, line)
+		default:
+			err = keyValue(current, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	return root, scanner.Err()
+}
+
+// stripComment removes a # comment that isn't inside of a string from the line
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // skip the escaped character
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tableName(line, open, close string) (string, error) {
+	if !strings.HasSuffix(line, close) {
+		return "", fmt.Errorf("expected %q at the end of %q", close, line)
+	}
+	name := strings.TrimSpace(line[len(open) : len(line)-len(close)])
+	if !validKey(name) {
+		return "", fmt.Errorf("invalid table name %q", name)
+	}
+	return name, nil
+}
+
+func table(root map[string]interface{}, line string) (map[string]interface{}, error) {
+	name, err := tableName(line, "[", "]")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := root[name]; ok {
+		return nil, fmt.Errorf("%q is defined more than once", name)
+	}
+	t := make(map[string]interface{})
+	root[name] = t
+	return t, nil
+}
+
+func arrayTable(root map[string]interface{}, line string) (map[string]interface{}, error) {
+	name, err := tableName(line, "[[", "]]")
+	if err != nil {
+		return nil, err
+	}
+	t := make(map[string]interface{})
+	switch existing := root[name].(type) {
+	case nil:
+		root[name] = []map[string]interface{}{t}
+	case []map[string]interface{}:
+		root[name] = append(existing, t)
+	default:
+		return nil, fmt.Errorf("%q is already defined and isn't an array of tables", name)
+	}
+	return t, nil
+}
+
+func keyValue(t map[string]interface{}, line string) error {
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return fmt.Errorf("expected key = value, got %q", line)
+	}
+	key := strings.TrimSpace(line[:eq])
+	if !validKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if _, ok := t[key]; ok {
+		return fmt.Errorf("%q is defined more than once", key)
+	}
+	value, rest, err := parseValue(strings.TrimSpace(line[eq+1:]))
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return fmt.Errorf("%s: unexpected %q after the value", key, rest)
+	}
+	t[key] = value
+	return nil
+}
+
+// validKey returns true for bare keys, which can only contain A-Za-z0-9_-
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses the value at the start of s and returns what's left after it
+func parseValue(s string) (interface{}, string, error) {
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		return parseBasicString(s)
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '[':
+		return parseArray(s)
+	}
+
+	end := strings.IndexAny(s, ",]")
+	if end < 0 {
+		end = len(s)
+	}
+	raw, rest := strings.TrimSpace(s[:end]), s[end:]
+	switch raw {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	clean := strings.Replace(raw, "_", "", -1)
+	if i, err := strconv.ParseInt(clean, 10, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q, strings need to be quoted", raw)
+}
+
+func parseBasicString(s string) (interface{}, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return nil, "", fmt.Errorf("unsupported escape sequence \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated string %s", s)
+}
+
+func parseArray(s string) (interface{}, string, error) {
+	var values []interface{}
+	rest := strings.TrimSpace(s[1:])
+	for {
+		if strings.HasPrefix(rest, "]") {
+			return values, rest[1:], nil
+		}
+		value, r, err := parseValue(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		values = append(values, value)
+		rest = strings.TrimSpace(r)
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = strings.TrimSpace(rest[1:])
+		case strings.HasPrefix(rest, "]"):
+		default:
+			return nil, "", fmt.Errorf("expected , or ] in array, got %q", rest)
+		}
+	}
+}
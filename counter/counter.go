@@ -17,7 +17,7 @@ var SortDesc = SortBy(func(d1, d2 *Dict) bool {
 // Counter is an interface that allows for counting the number of unique keys added
 // to the map so it can be sorted
 type Counter struct {
-	counterMap map[string]int
+	counterMap map[string]int // index of every key in Dict
 	Dict       []Dict
 	SortByFunc SortBy
 }
@@ -38,18 +38,13 @@ func New() *Counter {
 
 // Increment increments the counter for the supplied key
 func (m *Counter) Increment(key string) {
-	m.counterMap[key]++
-
-	newValue := m.counterMap[key]
-	for i := range m.Dict {
-		if m.Dict[i].Key == key {
-			// Found one, update the value
-			m.Dict[i].Value = newValue
-			return
-		}
+	if i, ok := m.counterMap[key]; ok {
+		m.Dict[i].Value++
+		return
 	}
 	// Key wasn't found in m.Dict, it needs to be initialized
-	m.Dict = append(m.Dict, Dict{Key: key, Value: newValue})
+	m.counterMap[key] = len(m.Dict)
+	m.Dict = append(m.Dict, Dict{Key: key, Value: 1})
 }
 
 // Len is part of sort.Interface.
@@ -60,6 +55,8 @@ func (m *Counter) Len() int {
 // Swap is part of sort.Interface.
 func (m *Counter) Swap(i, j int) {
 	m.Dict[i], m.Dict[j] = m.Dict[j], m.Dict[i]
+	m.counterMap[m.Dict[i].Key] = i
+	m.counterMap[m.Dict[j].Key] = j
 }
 
 // Less is part of sort.Interface. We use value as the value to sort by
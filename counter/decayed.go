@@ -0,0 +1,85 @@
+package counter
+
+import (
+	"math"
+	"time"
+)
+
+// Decayed counts keys with exponentially decaying weights, so that a count halves every
+// half-life after it was added. It tells how much a key has been seen recently, with older
+// occurrences counting for less and less rather than being dropped at once.
+type Decayed struct {
+	halfLife time.Duration
+	values   map[string]*decayedValue
+}
+
+type decayedValue struct {
+	value   float64
+	updated time.Time
+}
+
+// NewDecayed returns a Decayed with the half-life, which should be positive
+func NewDecayed(halfLife time.Duration) *Decayed {
+	return &Decayed{
+		halfLife: halfLife,
+		values:   make(map[string]*decayedValue),
+	}
+}
+
+// HalfLife returns the time it takes for a count to halve
+func (d *Decayed) HalfLife() time.Duration {
+	return d.halfLife
+}
+
+// Add counts n occurrences of the key at the time t
+func (d *Decayed) Add(key string, n float64, t time.Time) {
+	v, ok := d.values[key]
+	if !ok {
+		d.values[key] = &decayedValue{value: n, updated: t}
+		return
+	}
+	v.value = d.decay(v.value, t.Sub(v.updated)) + n
+	if t.After(v.updated) {
+		v.updated = t
+	}
+}
+
+// Value returns the decayed count of the key at the time t, or 0 if it hasn't been added
+func (d *Decayed) Value(key string, t time.Time) float64 {
+	v, ok := d.values[key]
+	if !ok {
+		return 0
+	}
+	return d.decay(v.value, t.Sub(v.updated))
+}
+
+// Rate returns the decayed count of the key at the time t as a number of occurrences per
+// second. A key that has occurred r times a second for a lot longer than the half-life
+// has a rate of r.
+func (d *Decayed) Rate(key string, t time.Time) float64 {
+	return d.Value(key, t) * math.Ln2 / d.halfLife.Seconds()
+}
+
+// Prune forgets the keys with a decayed count below min at the time t, so keys that aren't
+// seen anymore don't take up memory
+func (d *Decayed) Prune(t time.Time, min float64) {
+	for key, v := range d.values {
+		if d.decay(v.value, t.Sub(v.updated)) < min {
+			delete(d.values, key)
+		}
+	}
+}
+
+// Len returns the number of keys
+func (d *Decayed) Len() int {
+	return len(d.values)
+}
+
+// decay returns the value after the elapsed time. Times before the value was updated,
+// such as from lines that arrive late, don't increase it.
+func (d *Decayed) decay(value float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return value
+	}
+	return value * math.Exp2(-elapsed.Seconds()/d.halfLife.Seconds())
+}
@@ -0,0 +1,45 @@
+package counter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayed(t *testing.T) {
+	start := time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC)
+	d := NewDecayed(time.Minute)
+	d.Add("/api", 8, start)
+
+	for elapsed, expected := range map[time.Duration]float64{0: 8, time.Minute: 4, 3 * time.Minute: 1} {
+		if v := d.Value("/api", start.Add(elapsed)); math.Abs(v-expected) > 1e-9 {
+			t.Errorf("expected %v after %s, got: %v", expected, elapsed, v)
+		}
+	}
+
+	// Adding decays what was there first
+	d.Add("/api", 2, start.Add(time.Minute))
+	if v := d.Value("/api", start.Add(time.Minute)); math.Abs(v-6) > 1e-9 {
+		t.Errorf("expected 6, got: %v", v)
+	}
+	if d.Value("/report", start) != 0 {
+		t.Error("expected 0 for a key that wasn't added")
+	}
+
+	d.Prune(start.Add(10*time.Minute), 0.1)
+	if d.Len() != 0 {
+		t.Errorf("expected the decayed key to be pruned, got %d keys", d.Len())
+	}
+}
+
+func TestDecayedRate(t *testing.T) {
+	start := time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC)
+	d := NewDecayed(time.Minute)
+	// 10 a second for an hour
+	for s := 0; s < 3600; s++ {
+		d.Add("/api", 10, start.Add(time.Duration(s)*time.Second))
+	}
+	if r := d.Rate("/api", start.Add(time.Hour)); math.Abs(r-10) > 0.1 {
+		t.Errorf("expected a rate of about 10, got: %v", r)
+	}
+}
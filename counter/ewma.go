@@ -0,0 +1,62 @@
+package counter
+
+import (
+	"math"
+	"time"
+)
+
+// EWMA is an exponentially weighted moving average and variance of a series of values, so
+// that recent values count for more than older ones. It learns the usual level of a metric
+// and how much it varies, to tell how unusual a new value is.
+//
+// The fields are exported so that an EWMA can be saved and restored, such as with encoding/json.
+type EWMA struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// Samples is the number of values that have been added
+	Samples int `json:"samples"`
+}
+
+// Alpha returns the weight of a new value for values added every interval, so that the weight
+// of a value halves every half-life
+func Alpha(interval, halfLife time.Duration) float64 {
+	return 1 - math.Exp2(-interval.Seconds()/halfLife.Seconds())
+}
+
+// Add adds the value with the weight alpha, between 0 and 1. The first value is the mean.
+func (e *EWMA) Add(value, alpha float64) {
+	if e.Samples == 0 {
+		e.Mean = value
+		e.Variance = 0
+		e.Samples = 1
+		return
+	}
+	// Finch, "Incremental calculation of weighted mean and variance", 2009
+	diff := value - e.Mean
+	incr := alpha * diff
+	e.Mean += incr
+	e.Variance = (1 - alpha) * (e.Variance + diff*incr)
+	e.Samples++
+}
+
+// StdDev returns the standard deviation of the values
+func (e *EWMA) StdDev() float64 {
+	return math.Sqrt(e.Variance)
+}
+
+// ZScore returns how many standard deviations the value is from the mean, or 0 if there are
+// no values. The standard deviation is at least minStdDev, so that values that never vary
+// don't make every change look unusual.
+func (e *EWMA) ZScore(value, minStdDev float64) float64 {
+	if e.Samples == 0 {
+		return 0
+	}
+	stdDev := math.Max(e.StdDev(), minStdDev)
+	if stdDev == 0 {
+		if value == e.Mean {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), value-e.Mean)
+	}
+	return (value - e.Mean) / stdDev
+}
@@ -0,0 +1,35 @@
+package counter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	var e EWMA
+	if e.ZScore(10, 0) != 0 {
+		t.Error("expected a z-score of 0 without values")
+	}
+	alpha := Alpha(time.Minute, 10*time.Minute)
+	// Alternate between 90 and 110, which have a mean of 100 and a standard deviation of 10
+	for i := 0; i < 1000; i++ {
+		e.Add(float64(90+i%2*20), alpha)
+	}
+	if math.Abs(e.Mean-100) > 1 || math.Abs(e.StdDev()-10) > 0.5 {
+		t.Errorf("expected a mean of 100 and a standard deviation of 10, got: %v %v", e.Mean, e.StdDev())
+	}
+	if z := e.ZScore(150, 0); math.Abs(z-5) > 0.3 {
+		t.Errorf("expected a z-score of about 5, got: %v", z)
+	}
+	if z := e.ZScore(150, 25); math.Abs(z-2) > 0.1 {
+		t.Errorf("expected the minimum standard deviation to be used, got: %v", z)
+	}
+	if e.Samples != 1000 {
+		t.Errorf("expected 1000 samples, got: %d", e.Samples)
+	}
+
+	if a := Alpha(time.Minute, time.Minute); math.Abs(a-0.5) > 1e-9 {
+		t.Errorf("expected the weight to halve every half-life, got: %v", a)
+	}
+}
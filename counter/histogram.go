@@ -0,0 +1,136 @@
+package counter
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// histogramSubBuckets is the number of buckets every power of two is split into, so
+// quantiles are within about 3% of the values that were added
+const histogramSubBuckets = 32
+
+// Histogram counts non-negative values in log-linear buckets, to estimate their quantiles
+// in bounded memory. Values below histogramSubBuckets are counted exactly, and every
+// power of two above them is split into histogramSubBuckets buckets of the same width.
+// Only the buckets that have values take up memory.
+type Histogram struct {
+	buckets  map[int]int
+	count    int
+	sum      int64
+	min, max int64
+}
+
+// NewHistogram returns an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{buckets: make(map[int]int)}
+}
+
+// Add counts the value, negative values are counted as 0
+func (h *Histogram) Add(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.buckets[histogramBucket(v)]++
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of values added
+func (h *Histogram) Count() int {
+	return h.count
+}
+
+// Sum returns the total of the values added
+func (h *Histogram) Sum() int64 {
+	return h.sum
+}
+
+// Max returns the largest value added, or 0 if there are none
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the average of the values added, or 0 if there are none
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// Quantile returns an estimate of the value that the fraction q of the values are at most,
+// such as 0.99 for the 99th percentile, or 0 if there are no values
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	buckets := make([]int, 0, len(h.buckets))
+	for b := range h.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	var bucket, seen int
+	for _, bucket = range buckets {
+		if seen += h.buckets[bucket]; seen >= rank {
+			break
+		}
+	}
+	v := histogramValue(bucket)
+	if v < h.min {
+		v = h.min
+	}
+	if v > h.max {
+		v = h.max
+	}
+	return v
+}
+
+// Merge adds the values of other
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	for b, c := range other.buckets {
+		h.buckets[b] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// histogramBucket returns the bucket of the value, which isn't negative
+func histogramBucket(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// The power of two of v, counting from the first one above the exact values
+	exponent := bits.Len64(uint64(v)) - bits.Len64(histogramSubBuckets-1) - 1
+	sub := int(v>>uint(exponent)) - histogramSubBuckets
+	return histogramSubBuckets + exponent*histogramSubBuckets + sub
+}
+
+// histogramValue returns the middle of the values in the bucket
+func histogramValue(bucket int) int64 {
+	if bucket < histogramSubBuckets {
+		return int64(bucket)
+	}
+	exponent := uint((bucket - histogramSubBuckets) / histogramSubBuckets)
+	sub := int64((bucket-histogramSubBuckets)%histogramSubBuckets + histogramSubBuckets)
+	return sub<<exponent + (int64(1)<<exponent)/2
+}
@@ -0,0 +1,54 @@
+package counter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Error("expected 0 for an empty histogram")
+	}
+	// 1 to 10000, so every quantile is known
+	for v := int64(1); v <= 10000; v++ {
+		h.Add(v)
+	}
+	if h.Count() != 10000 || h.Sum() != 50005000 || h.Max() != 10000 {
+		t.Errorf("bad count, sum or max: %d %d %d", h.Count(), h.Sum(), h.Max())
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		expected := q * 10000
+		if v := h.Quantile(q); math.Abs(float64(v)-expected)/expected > 0.03 {
+			t.Errorf("expected the %v quantile to be about %v, got: %d", q, expected, v)
+		}
+	}
+	if h.Quantile(1) != 10000 || h.Quantile(0) != 1 {
+		t.Errorf("expected the quantiles to be clamped to the min and max, got: %d %d", h.Quantile(0), h.Quantile(1))
+	}
+
+	// Small values are exact
+	small := NewHistogram()
+	for _, v := range []int64{3, 1, 2, -5} {
+		small.Add(v)
+	}
+	if small.Quantile(0.5) != 1 || small.Quantile(0.75) != 2 {
+		t.Errorf("expected exact quantiles, got: %d %d", small.Quantile(0.5), small.Quantile(0.75))
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	for v := int64(0); v < 100; v++ {
+		a.Add(v)
+		b.Add(v + 1000)
+	}
+	a.Merge(b)
+	a.Merge(NewHistogram())
+	if a.Count() != 200 || a.Max() != 1099 || a.Quantile(0) != 0 {
+		t.Errorf("bad merge: %d %d %d", a.Count(), a.Max(), a.Quantile(0))
+	}
+	if v := a.Quantile(0.75); v < 1030 || v > 1070 {
+		t.Errorf("expected the 0.75 quantile to be about 1050, got: %d", v)
+	}
+}
@@ -0,0 +1,126 @@
+package counter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Bounds of the precision of a HyperLogLog
+const (
+	MinPrecision = 4
+	MaxPrecision = 18
+)
+
+// HyperLogLog estimates the number of distinct keys it has been given in fixed memory, with the
+// HyperLogLog algorithm (Flajolet et al., 2007). With a precision of p it uses 2^p bytes, and
+// the standard error of its estimates is 1.04 / sqrt(2^p), so about 1.6% with a precision of 12.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog returns an empty HyperLogLog with the precision, which is between MinPrecision
+// and MaxPrecision
+func NewHyperLogLog(precision int) (*HyperLogLog, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, fmt.Errorf("precision should be between %d and %d, got %d", MinPrecision, MaxPrecision, precision)
+	}
+	return &HyperLogLog{
+		precision: uint8(precision),
+		registers: make([]uint8, 1<<uint(precision)),
+	}, nil
+}
+
+// Precision returns the precision the HyperLogLog was created with
+func (h *HyperLogLog) Precision() int {
+	return int(h.precision)
+}
+
+// Add counts the key
+func (h *HyperLogLog) Add(key string) {
+	x := hash(key)
+	index := x >> (64 - h.precision)
+	// The rank is the position of the first 1 bit after the index bits, the guard bit
+	// limits it when they are all 0
+	w := x<<h.precision | 1<<(h.precision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Count returns the estimated number of distinct keys that have been added
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(h.registers)) * m * m / sum
+
+	// Small cardinalities are more accurately estimated by the registers that are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the keys of other, so the HyperLogLog estimates the distinct keys of both.
+// They need to have the same precision.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("can't merge a HyperLogLog with a precision of %d into one of %d", other.precision, h.precision)
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Clone returns a copy of the HyperLogLog
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	registers := make([]uint8, len(h.registers))
+	copy(registers, h.registers)
+	return &HyperLogLog{precision: h.precision, registers: registers}
+}
+
+// Reset forgets every key, keeping the precision
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// hash returns the 64 bit FNV-1a hash of the key, with the bits mixed by the finalizer of
+// MurmurHash3 since HyperLogLog needs every bit to be evenly distributed
+func hash(key string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	x := f.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,57 @@
+package counter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	h, err := NewHyperLogLog(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Count() != 0 {
+		t.Errorf("expected an empty count, got: %d", h.Count())
+	}
+
+	for _, n := range []int{10, 1000, 100000} {
+		h.Reset()
+		for i := 0; i < n; i++ {
+			// Adding every key twice doesn't change the count
+			h.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+			h.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		}
+		// Allow for 4 standard errors
+		if e := math.Abs(float64(h.Count())-float64(n)) / float64(n); e > 4*1.04/64 {
+			t.Errorf("expected about %d distinct keys, got: %d", n, h.Count())
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, _ := NewHyperLogLog(10)
+	b, _ := NewHyperLogLog(10)
+	for i := 0; i < 500; i++ {
+		a.Add(fmt.Sprint("a", i))
+		b.Add(fmt.Sprint("b", i))
+	}
+	merged := a.Clone()
+	if err := merged.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Count() < 900 || merged.Count() > 1100 {
+		t.Errorf("expected about 1000 distinct keys, got: %d", merged.Count())
+	}
+	if a.Count() > 600 {
+		t.Errorf("expected the clone to be merged rather than a, got: %d", a.Count())
+	}
+
+	c, _ := NewHyperLogLog(11)
+	if err := a.Merge(c); err == nil {
+		t.Error("expected an error merging different precisions")
+	}
+	if _, err := NewHyperLogLog(3); err == nil {
+		t.Error("expected an error for a precision that's too low")
+	}
+}
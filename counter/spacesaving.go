@@ -0,0 +1,232 @@
+package counter
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// SpaceSaving counts the keys that occur the most in a stream in bounded memory, with the
+// Space-Saving algorithm (Metwally, Agrawal and El Abbadi, 2005). It keeps track of at most
+// capacity keys: when a new key arrives and it is full, the key with the lowest count is
+// replaced and the new key takes over its count.
+//
+// Counts are overestimates, by at most the Error of their Estimate, which is never more than
+// Total() / capacity. Every key that occurs more than Total() / capacity times is kept, so
+// the top keys are exact as long as they stand out from the rest.
+type SpaceSaving struct {
+	capacity int
+	items    map[string]*ssItem
+	heap     ssHeap // items ordered by count, the lowest first
+	total    int
+	seq      int // order the items were first seen in, to break ties
+}
+
+// Estimate is the estimated count of a key in a SpaceSaving
+type Estimate struct {
+	Key   string
+	Count int
+	// Error is how much the Count might be over the actual count
+	Error int
+}
+
+type ssItem struct {
+	Estimate
+	seq   int
+	index int // in the heap
+}
+
+// NewSpaceSaving returns a SpaceSaving that keeps track of at most capacity keys.
+// It panics if the capacity isn't positive.
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		panic("counter: the capacity of a SpaceSaving should be positive")
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		items:    make(map[string]*ssItem),
+	}
+}
+
+// Increment counts one occurrence of the key
+func (s *SpaceSaving) Increment(key string) {
+	s.Add(key, 1)
+}
+
+// Add counts n occurrences of the key
+func (s *SpaceSaving) Add(key string, n int) {
+	s.total += n
+	if item, ok := s.items[key]; ok {
+		item.Count += n
+		heap.Fix(&s.heap, item.index)
+		return
+	}
+	if len(s.items) < s.capacity {
+		item := &ssItem{Estimate: Estimate{Key: key, Count: n}, seq: s.seq}
+		s.seq++
+		s.items[key] = item
+		heap.Push(&s.heap, item)
+		return
+	}
+
+	// Replace the key with the lowest count, which is now the most the new key could have occurred
+	min := s.heap[0]
+	delete(s.items, min.Key)
+	min.Key = key
+	min.Error = min.Count
+	min.Count += n
+	min.seq = s.seq
+	s.seq++
+	s.items[key] = min
+	heap.Fix(&s.heap, 0)
+}
+
+// Count returns the estimated count of the key. Keys that aren't kept track of return 0,
+// though if the SpaceSaving is full they could have occurred up to Min() times.
+func (s *SpaceSaving) Count(key string) Estimate {
+	if item, ok := s.items[key]; ok {
+		return item.Estimate
+	}
+	return Estimate{Key: key}
+}
+
+// Min returns the lowest count of the keys that are kept track of, or 0 if it isn't full
+func (s *SpaceSaving) Min() int {
+	if len(s.items) < s.capacity {
+		return 0
+	}
+	return s.heap[0].Count
+}
+
+// Total returns the number of occurrences that have been counted
+func (s *SpaceSaving) Total() int {
+	return s.total
+}
+
+// Len returns the number of keys that are kept track of
+func (s *SpaceSaving) Len() int {
+	return len(s.items)
+}
+
+// Estimates returns at most n of the keys with the highest counts, or all of them if n is 0,
+// sorted in descending order by count. Keys with the same count keep the order they were
+// first seen in.
+func (s *SpaceSaving) Estimates(n int) []Estimate {
+	items := make([]*ssItem, len(s.heap))
+	copy(items, s.heap)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].seq < items[j].seq
+	})
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+	estimates := make([]Estimate, len(items))
+	for i, item := range items {
+		estimates[i] = item.Estimate
+	}
+	return estimates
+}
+
+// TopN returns at most n of the keys with the highest counts, like Estimates, as Dicts
+func (s *SpaceSaving) TopN(n int) []Dict {
+	estimates := s.Estimates(n)
+	dd := make([]Dict, len(estimates))
+	for i, e := range estimates {
+		dd[i] = Dict{Key: e.Key, Value: e.Count}
+	}
+	return dd
+}
+
+// Merge adds the counts of other, such as from another shard of the stream, so that s counts
+// both streams with the same error bounds (Agarwal et al., "Mergeable Summaries", 2012).
+// The keys that are only kept track of by one of them are counted as having occurred the
+// Min() times of the other.
+func (s *SpaceSaving) Merge(other *SpaceSaving) {
+	sMin, otherMin := s.Min(), other.Min()
+	merged := make(map[string]*ssItem, len(s.items)+len(other.items))
+	for key, item := range s.items {
+		o, ok := other.items[key]
+		if !ok {
+			o = &ssItem{Estimate: Estimate{Count: otherMin, Error: otherMin}}
+		}
+		merged[key] = &ssItem{
+			Estimate: Estimate{Key: key, Count: item.Count + o.Count, Error: item.Error + o.Error},
+			seq:      item.seq,
+		}
+	}
+	for key, o := range other.items {
+		if _, ok := merged[key]; ok {
+			continue
+		}
+		merged[key] = &ssItem{
+			Estimate: Estimate{Key: key, Count: o.Count + sMin, Error: o.Error + sMin},
+			seq:      s.seq + o.seq,
+		}
+	}
+
+	// Keep the keys with the highest counts
+	items := make([]*ssItem, 0, len(merged))
+	for _, item := range merged {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].seq < items[j].seq
+	})
+	if len(items) > s.capacity {
+		items = items[:s.capacity]
+	}
+
+	s.items = make(map[string]*ssItem, len(items))
+	s.heap = s.heap[:0]
+	for _, item := range items {
+		s.items[item.Key] = item
+		heap.Push(&s.heap, item)
+	}
+	s.total += other.total
+	s.seq += other.seq
+}
+
+// Reset forgets every key, keeping the capacity
+func (s *SpaceSaving) Reset() {
+	s.items = make(map[string]*ssItem)
+	s.heap = nil
+	s.total = 0
+	s.seq = 0
+}
+
+// ssHeap is a container/heap of the items of a SpaceSaving, with the lowest count at the top
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int { return len(h) }
+
+func (h ssHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	// Replace the most recently seen key first, so the longer standing ones are kept
+	return h[i].seq > h[j].seq
+}
+
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	item := x.(*ssItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,93 @@
+package counter
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSpaceSavingExact(t *testing.T) {
+	s := NewSpaceSaving(10)
+	for _, key := range []string{"a", "b", "a", "c", "b", "a"} {
+		s.Increment(key)
+	}
+	expected := []Dict{{"a", 3}, {"b", 2}, {"c", 1}}
+	if top := s.TopN(0); !reflect.DeepEqual(top, expected) {
+		t.Errorf("expected %v, got: %v", expected, top)
+	}
+	if top := s.TopN(1); len(top) != 1 || top[0].Key != "a" {
+		t.Errorf("expected only a, got: %v", top)
+	}
+	if e := s.Count("b"); e.Count != 2 || e.Error != 0 {
+		t.Errorf("expected an exact count for b, got: %+v", e)
+	}
+	if s.Total() != 6 || s.Min() != 0 {
+		t.Errorf("bad total or min: %d %d", s.Total(), s.Min())
+	}
+}
+
+func TestSpaceSavingBounded(t *testing.T) {
+	s := NewSpaceSaving(10)
+	// Two heavy hitters among 1000 keys that are only seen once
+	for i := 0; i < 1000; i++ {
+		s.Increment(fmt.Sprintf("rare-%d", i))
+		if i%4 == 0 {
+			s.Increment("heavy")
+		}
+		if i%5 == 0 {
+			s.Increment("medium")
+		}
+	}
+	if s.Len() != 10 {
+		t.Errorf("expected 10 keys to be kept, got: %d", s.Len())
+	}
+	top := s.Estimates(2)
+	if top[0].Key != "heavy" || top[1].Key != "medium" {
+		t.Fatalf("expected the heavy hitters at the top, got: %v", top)
+	}
+	for _, e := range top {
+		actual := map[string]int{"heavy": 250, "medium": 200}[e.Key]
+		if e.Count < actual || e.Count-e.Error > actual || e.Error > s.Total()/10 {
+			t.Errorf("estimate %+v is out of bounds for the actual count %d", e, actual)
+		}
+	}
+}
+
+func TestSpaceSavingMerge(t *testing.T) {
+	a, b := NewSpaceSaving(3), NewSpaceSaving(3)
+	for i := 0; i < 5; i++ {
+		a.Increment("x")
+		b.Increment("x")
+		b.Increment("y")
+	}
+	a.Increment("z")
+	a.Merge(b)
+
+	expected := []Dict{{"x", 10}, {"y", 5}, {"z", 1}}
+	if top := a.TopN(0); !reflect.DeepEqual(top, expected) {
+		t.Errorf("expected %v, got: %v", expected, top)
+	}
+	if a.Total() != 16 {
+		t.Errorf("expected the totals to be added, got: %d", a.Total())
+	}
+
+	// Full sketches count the keys that only the other one has as their lowest count
+	c := NewSpaceSaving(2)
+	c.Add("p", 4)
+	c.Add("q", 2)
+	a.Merge(c)
+	if e := a.Count("p"); e.Count != 5 || e.Error != 1 {
+		t.Errorf("expected p to include the lowest count of a, got: %+v", e)
+	}
+	if e := a.Count("x"); e.Count != 12 || e.Error != 2 {
+		t.Errorf("expected x to include the lowest count of c, got: %+v", e)
+	}
+	if a.Len() != 3 || a.Count("z").Count != 0 {
+		t.Errorf("expected the lowest key to be dropped, got: %v", a.TopN(0))
+	}
+
+	a.Reset()
+	if a.Len() != 0 || a.Total() != 0 {
+		t.Error("expected the reset sketch to be empty")
+	}
+}
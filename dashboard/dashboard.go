@@ -0,0 +1,557 @@
+// Package dashboard is a full-screen terminal dashboard that displays the Events sent by
+// the listeners. It only uses ANSI escape codes and stty, so it works over plain SSH terminals.
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+)
+
+// View is one of the screens that the Dashboard can display
+type View int
+
+// The views of the Dashboard, in the order they are cycled through
+const (
+	Overview View = iota
+	Sections
+	Clients
+	Alerts
+	numViews
+)
+
+var viewNames = []string{"Overview", "Sections", "Clients", "Alerts"}
+
+func (v View) String() string {
+	return viewNames[v]
+}
+
+// maxTraffic is the number of seconds of traffic that is kept for the sparkline
+const maxTraffic = 600
+
+// maxAlerts is the number of alert events that are kept in the history
+const maxAlerts = 100
+
+// Intervals are the summary intervals that can be cycled through with the +/- keys
+var Intervals = []time.Duration{5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute}
+
+// Dashboard keeps the state from the Events it receives and draws it to a terminal
+type Dashboard struct {
+	out io.Writer
+	in  io.Reader
+
+	// OnInterval is called when the summary interval is changed from the keyboard
+	OnInterval func(time.Duration)
+
+	mu         sync.Mutex
+	summary    *listeners.SummaryReport
+	slo        *listeners.SLOReport
+	traffic    []int // hits per second, oldest first
+	trafficEnd time.Time
+	alerts     []listeners.Event // oldest first
+	active     map[string]string // names of the triggered alerts by listeners.AlertKey
+	paused     bool
+	view       View
+	interval   int // index into Intervals
+}
+
+// New returns a Dashboard that draws to out and reads key presses from in.
+// interval is the current summary interval.
+func New(out io.Writer, in io.Reader, interval time.Duration) *Dashboard {
+	d := &Dashboard{out: out, in: in, active: make(map[string]string)}
+	for i, iv := range Intervals {
+		if iv == interval {
+			d.interval = i
+		}
+	}
+	return d
+}
+
+// Run takes over the terminal and draws the dashboard until the "q" key is pressed,
+// the context is cancelled or the events channel is closed.
+func (d *Dashboard) Run(ctx context.Context, events <-chan listeners.Event) error {
+	term, err := newTerminal()
+	if err != nil {
+		return err
+	}
+	defer term.restore(d.out)
+	term.setup(d.out)
+
+	// The key reader stops once Run returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys := make(chan byte)
+	go d.readKeys(ctx, keys)
+
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+
+	clock := time.NewTicker(time.Second)
+	defer clock.Stop()
+	d.draw(term)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			d.Add(e)
+		case b, ok := <-keys:
+			if !ok || !d.handleKey(b) {
+				return nil
+			}
+			d.draw(term)
+		case <-resized:
+			term.resize()
+			d.draw(term)
+		case <-ctx.Done():
+			return nil
+		case <-clock.C:
+			d.mu.Lock()
+			paused := d.paused
+			d.mu.Unlock()
+			if !paused {
+				d.draw(term)
+			}
+		}
+	}
+}
+
+// readKeys sends the key presses read from the terminal into keys until the context is
+// cancelled, then it closes keys. The terminal returns no key after a tenth of a second
+// without one, which is read as io.EOF, so the context is checked at least that often.
+func (d *Dashboard) readKeys(ctx context.Context, keys chan<- byte) {
+	defer close(keys)
+	r := bufio.NewReader(d.in)
+	for ctx.Err() == nil {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case keys <- b:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Add updates the state of the dashboard from the Event
+func (d *Dashboard) Add(e listeners.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch ev := e.(type) {
+	case listeners.SummaryReport:
+		d.summary = &ev
+		d.addTraffic(ev)
+	case listeners.SLOReport:
+		d.slo = &ev
+	case listeners.AlertEvent:
+		if ev.Triggered() {
+			d.active[listeners.AlertKey(ev)] = ev.AlertName()
+		} else {
+			delete(d.active, listeners.AlertKey(ev))
+		}
+		d.addAlert(ev)
+	}
+}
+
+// addTraffic appends the hits per second of the report to the traffic history,
+// filling any gap since the previous report with zeros
+func (d *Dashboard) addTraffic(sr listeners.SummaryReport) {
+	start := sr.Window.Start.Truncate(time.Second)
+	if !d.trafficEnd.IsZero() && start.After(d.trafficEnd) {
+		gap := int(start.Sub(d.trafficEnd) / time.Second)
+		if gap > maxTraffic {
+			gap = maxTraffic
+		}
+		d.traffic = append(d.traffic, make([]int, gap)...)
+	}
+	d.traffic = append(d.traffic, sr.HitsPerSecond...)
+	d.trafficEnd = start.Add(time.Duration(len(sr.HitsPerSecond)) * time.Second)
+	if len(d.traffic) > maxTraffic {
+		d.traffic = d.traffic[len(d.traffic)-maxTraffic:]
+	}
+}
+
+func (d *Dashboard) addAlert(e listeners.Event) {
+	d.alerts = append(d.alerts, e)
+	if len(d.alerts) > maxAlerts {
+		d.alerts = d.alerts[len(d.alerts)-maxAlerts:]
+	}
+}
+
+// ctrlC is the byte read for ctrl-c while the terminal is in dashboard mode
+const ctrlC = 3
+
+// handleKey updates the state of the dashboard for a key press,
+// and returns false if the dashboard should exit
+func (d *Dashboard) handleKey(b byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch b {
+	case 'q', 'Q', ctrlC:
+		return false
+	case 'p', 'P', ' ':
+		d.paused = !d.paused
+	case '\t':
+		d.view = (d.view + 1) % numViews
+	case '1', '2', '3', '4':
+		d.view = View(b - '1')
+	case '+', '=':
+		d.setInterval(d.interval + 1)
+	case '-', '_':
+		d.setInterval(d.interval - 1)
+	}
+	return true
+}
+
+func (d *Dashboard) setInterval(i int) {
+	if i < 0 || i >= len(Intervals) || i == d.interval {
+		return
+	}
+	d.interval = i
+	if d.OnInterval != nil {
+		d.OnInterval(Intervals[i])
+	}
+}
+
+func (d *Dashboard) draw(term *terminal) {
+	width, height := term.size()
+	var buf strings.Builder
+	buf.WriteString(escHome)
+	lines := d.render(width, height)
+	for _, line := range lines {
+		buf.WriteString(truncate(line, width))
+		buf.WriteString(escClearLine + "\n")
+	}
+	buf.WriteString(escClearScreenDown)
+	io.WriteString(d.out, buf.String())
+}
+
+// render returns the lines of the current view, fitting in width and height
+func (d *Dashboard) render(width, height int) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var lines []string
+	status := "LIVE"
+	if d.paused {
+		status = "PAUSED"
+	}
+	alert := "OK"
+	if len(d.active) > 0 {
+		// Such as "HIGH TRAFFIC" for the high_traffic alert, once for all of the listeners with it
+		var names []string
+		seen := make(map[string]bool)
+		for _, name := range d.active {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, strings.ToUpper(strings.Replace(name, "_", " ", -1)))
+			}
+		}
+		sort.Strings(names)
+		alert = strings.Join(names, ", ")
+	}
+	lines = append(lines,
+		fmt.Sprintf("logmonitor  %s  [%s]  alert: %s  %s", d.view, status, alert, time.Now().UTC().Format("15:04:05")),
+		strings.Repeat("─", width),
+	)
+
+	var sr listeners.SummaryReport
+	if d.summary != nil {
+		sr = *d.summary
+	}
+
+	switch d.view {
+	case Overview:
+		lines = append(lines, d.renderTraffic(width)...)
+		lines = append(lines, bandwidth(sr))
+		if sr.Apdex != nil {
+			lines = append(lines, fmt.Sprintf("Apdex  score: %.2f  satisfied: %d  tolerating: %d  frustrated: %d",
+				sr.Apdex.Score, sr.Apdex.Satisfied, sr.Apdex.Tolerating, sr.Apdex.Frustrated))
+		}
+		if d.slo != nil {
+			lines = append(lines, d.slo.String())
+		}
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			table("Top sections", sr.TopSections),
+			table("Status codes", sr.StatusClasses),
+		)...)
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			table("Top users", sr.TopUsers),
+			table("Top IP addresses", sr.TopIPAddresses),
+		)...)
+		lines = append(lines, "")
+		lines = append(lines, d.renderAlerts(5)...)
+	case Sections:
+		lines = append(lines, columns(width,
+			tree("Section tree", sr.SectionTree),
+			table("Top sections", sr.TopSections),
+		)...)
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			bars("Top routes", sr.TopRoutes, width/2),
+			trending("Trending", sr.Trending),
+		)...)
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			table("Top sections with 4xx", sr.TopSectionsByStatusClass["4xx"]),
+			table("Top sections with 5xx", sr.TopSectionsByStatusClass["5xx"]),
+		)...)
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			table("Status codes", sr.StatusCodes),
+			table("Methods", sr.Methods),
+		)...)
+		if len(sr.ApdexBySection) > 0 {
+			lines = append(lines, "")
+			lines = append(lines, apdex("Apdex by section", sr.ApdexBySection)...)
+		}
+	case Clients:
+		lines = append(lines, unique(sr)...)
+		lines = append(lines, "")
+		lines = append(lines, bars("Top users", sr.TopUsers, width)...)
+		lines = append(lines, "")
+		lines = append(lines, bars("Top IP addresses", sr.TopIPAddresses, width)...)
+		lines = append(lines, "")
+		lines = append(lines, columns(width,
+			table("Top sections by bytes", sr.TopSectionsByBytes),
+			table("Top IP addresses by bytes", sr.TopIPAddressesByBytes),
+		)...)
+	case Alerts:
+		lines = append(lines, d.renderAlerts(height-4)...)
+	}
+
+	footer := fmt.Sprintf("[p] pause  [+/-] interval: %s  [tab/1-4] view  [q] quit", Intervals[d.interval])
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	return append(lines, footer)
+}
+
+func (d *Dashboard) renderTraffic(width int) []string {
+	traffic := d.traffic
+	if len(traffic) > width {
+		traffic = traffic[len(traffic)-width:]
+	}
+	current, max, total := 0, 0, 0
+	for i, hits := range traffic {
+		if i == len(traffic)-1 {
+			current = hits
+		}
+		if hits > max {
+			max = hits
+		}
+		total += hits
+	}
+	avg := 0.0
+	if len(traffic) > 0 {
+		avg = float64(total) / float64(len(traffic))
+	}
+	return []string{
+		fmt.Sprintf("Requests per second  last: %d  avg: %.1f  max: %d", current, avg, max),
+		Sparkline(traffic),
+	}
+}
+
+func (d *Dashboard) renderAlerts(n int) []string {
+	lines := []string{"Alerts"}
+	if len(d.alerts) == 0 {
+		return append(lines, "  no alerts")
+	}
+	for i := len(d.alerts) - 1; i >= 0 && len(lines) <= n; i-- {
+		lines = append(lines, "  "+fmt.Sprint(d.alerts[i]))
+	}
+	return lines
+}
+
+// sparks are the characters used to draw a Sparkline, from lowest to highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline returns the values drawn as a single line of block characters scaled to the max value
+func Sparkline(values []int) string {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		if max == 0 {
+			line[i] = sparks[0]
+			continue
+		}
+		line[i] = sparks[v*(len(sparks)-1)/max]
+	}
+	return string(line)
+}
+
+// table returns a title followed by a row for every item
+func table(title string, items []listeners.SummaryReportItem) []string {
+	lines := []string{title}
+	if len(items) == 0 {
+		return append(lines, "  -")
+	}
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("  %-30s %6d", truncate(item.Key, 30), item.Value))
+	}
+	return lines
+}
+
+// trending returns a title followed by a row for every trending section, with how many
+// times its rate is over its baseline
+func trending(title string, sections []listeners.TrendingSection) []string {
+	lines := []string{title}
+	if len(sections) == 0 {
+		return append(lines, "  -")
+	}
+	for _, s := range sections {
+		growth := fmt.Sprintf("x%.1f", s.Growth)
+		if s.New {
+			growth = "new"
+		}
+		lines = append(lines, fmt.Sprintf("  %-30s %6d %s", truncate(s.Section, 30), s.Hits, growth))
+	}
+	return lines
+}
+
+// apdex returns a title followed by a row for every section with its Apdex score and the
+// number of requests it was counted from
+func apdex(title string, sections []listeners.SectionApdex) []string {
+	lines := []string{title}
+	for _, s := range sections {
+		lines = append(lines, fmt.Sprintf("  %-30s %6.2f %6d", truncate(s.Section, 30), s.Score, s.Total()))
+	}
+	return lines
+}
+
+// bandwidth returns the bytes sent in the last report and the sizes of its responses
+func bandwidth(sr listeners.SummaryReport) string {
+	size := sr.ResponseSize
+	return fmt.Sprintf("Bandwidth  total: %s  per second: %s  response size avg: %s  p50: %s  p90: %s  p99: %s",
+		listeners.FormatBytes(float64(sr.Bytes)), listeners.FormatBytes(sr.BytesPerSecond), listeners.FormatBytes(size.Average),
+		listeners.FormatBytes(float64(size.P50)), listeners.FormatBytes(float64(size.P90)), listeners.FormatBytes(float64(size.P99)))
+}
+
+// unique returns the estimated distinct keys of the last report and of its rolling windows
+func unique(sr listeners.SummaryReport) []string {
+	lines := []string{"Distinct"}
+	row := func(name string, u listeners.UniqueCounts) string {
+		return fmt.Sprintf("  %-12s IP addresses: %-8d users: %-8d URLs: %d", name, u.IPAddresses, u.Users, u.URLs)
+	}
+	lines = append(lines, row("last report", sr.Unique))
+	for _, w := range sr.UniqueOver {
+		lines = append(lines, row("last "+listeners.ShortDuration(w.Duration), w.UniqueCounts))
+	}
+	return lines
+}
+
+// tree returns a title followed by a row for every node of the section tree, indented by its depth
+func tree(title string, nodes []listeners.SectionNode) []string {
+	lines := []string{title}
+	if len(nodes) == 0 {
+		return append(lines, "  -")
+	}
+	var add func(nodes []listeners.SectionNode, indent string)
+	add = func(nodes []listeners.SectionNode, indent string) {
+		for _, node := range nodes {
+			lines = append(lines, fmt.Sprintf("%s%-*s %6d", indent, 32-len(indent), truncate(node.Section, 32-len(indent)), node.Hits))
+			add(node.Children, indent+"  ")
+		}
+	}
+	add(nodes, "  ")
+	return lines
+}
+
+// bars returns a title followed by a row with a bar scaled to the largest value for every item
+func bars(title string, items []listeners.SummaryReportItem, width int) []string {
+	lines := []string{title}
+	if len(items) == 0 {
+		return append(lines, "  -")
+	}
+	max := 0
+	for _, item := range items {
+		if item.Value > max {
+			max = item.Value
+		}
+	}
+	barWidth := width - 42
+	if barWidth < 1 {
+		barWidth = 1
+	}
+	for _, item := range items {
+		n := 0
+		if max > 0 {
+			n = item.Value * barWidth / max
+		}
+		lines = append(lines, fmt.Sprintf("  %-30s %6d %s", truncate(item.Key, 30), item.Value, strings.Repeat("█", n)))
+	}
+	return lines
+}
+
+// columns lays out the blocks of lines next to each other, each taking an equal share of the width
+func columns(width int, blocks ...[]string) []string {
+	colWidth := width / len(blocks)
+	rows := 0
+	for _, block := range blocks {
+		if len(block) > rows {
+			rows = len(block)
+		}
+	}
+	lines := make([]string, rows)
+	for i := range lines {
+		for j, block := range blocks {
+			cell := ""
+			if i < len(block) {
+				cell = block[i]
+			}
+			if j < len(blocks)-1 {
+				cell = pad(truncate(cell, colWidth-1), colWidth)
+			}
+			lines[i] += cell
+		}
+	}
+	return lines
+}
+
+// truncate shortens s to at most width characters
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width < 0 {
+		width = 0
+	}
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
+
+// pad pads s with spaces to width characters
+func pad(s string, width int) string {
+	n := width - len([]rune(s))
+	if n <= 0 {
+		return s
+	}
+	return s + strings.Repeat(" ", n)
+}
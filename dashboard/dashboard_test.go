@@ -0,0 +1,169 @@
+package dashboard
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+func TestSparkline(t *testing.T) {
+	if s := Sparkline([]int{0, 1, 2, 4, 8}); s != "▁▁▂▄█" {
+		t.Errorf("bad sparkline: %s", s)
+	}
+	if s := Sparkline([]int{0, 0}); s != "▁▁" {
+		t.Errorf("bad sparkline for no traffic: %s", s)
+	}
+}
+
+func TestDashboardTraffic(t *testing.T) {
+	d := New(nil, nil, 10*time.Second)
+	start := time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC)
+	d.Add(listeners.SummaryReport{
+		Window:        listeners.Window{Start: start, End: start.Add(3 * time.Second)},
+		HitsPerSecond: []int{1, 2, 3},
+	})
+	// No report was sent for the two seconds in between, so they should be zeros
+	d.Add(listeners.SummaryReport{
+		Window:        listeners.Window{Start: start.Add(5 * time.Second), End: start.Add(7 * time.Second)},
+		HitsPerSecond: []int{4, 5},
+	})
+
+	expected := []int{1, 2, 3, 0, 0, 4, 5}
+	if len(d.traffic) != len(expected) {
+		t.Fatalf("bad traffic: %v", d.traffic)
+	}
+	for i := range expected {
+		if d.traffic[i] != expected[i] {
+			t.Fatalf("bad traffic: %v", d.traffic)
+		}
+	}
+}
+
+func TestDashboardKeys(t *testing.T) {
+	var changed time.Duration
+	d := New(nil, nil, 10*time.Second)
+	d.OnInterval = func(iv time.Duration) { changed = iv }
+
+	d.handleKey('+')
+	if changed != 30*time.Second {
+		t.Errorf("expected the interval to change to 30s, got: %s", changed)
+	}
+	d.handleKey('p')
+	if !d.paused {
+		t.Error("expected the dashboard to be paused")
+	}
+	d.handleKey('\t')
+	if d.view != Sections {
+		t.Errorf("expected the sections view, got: %s", d.view)
+	}
+	d.handleKey('4')
+	if d.view != Alerts {
+		t.Errorf("expected the alerts view, got: %s", d.view)
+	}
+	if d.handleKey('q') {
+		t.Error("expected q to quit")
+	}
+}
+
+func TestDashboardReadKeys(t *testing.T) {
+	// Like the terminal, the reader returns io.EOF when there is no key press
+	d := New(nil, strings.NewReader("pq"), 10*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	keys := make(chan byte)
+	go d.readKeys(ctx, keys)
+	if p, q := <-keys, <-keys; p != 'p' || q != 'q' {
+		t.Errorf("bad keys: %q %q", p, q)
+	}
+
+	// The reader stops with the context rather than waiting for another key
+	cancel()
+	select {
+	case _, ok := <-keys:
+		if ok {
+			t.Error("expected no more keys")
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the reader to stop once the context is cancelled")
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	d := New(nil, nil, 10*time.Second)
+	d.Add(listeners.SummaryReport{
+		TopSections:   []listeners.SummaryReportItem{{Key: "/api", Value: 12}},
+		StatusClasses: []listeners.SummaryReportItem{{Key: "2xx", Value: 10}, {Key: "5xx", Value: 2}},
+		Bytes:         1536,
+		Apdex:         &listeners.ApdexScore{Score: 0.92, Apdex: log.Apdex{Satisfied: 90, Tolerating: 4, Frustrated: 6}},
+	})
+	d.Add(listeners.AlertTriggered{AlertTraffic: listeners.AlertTraffic{Hits: 1500}})
+	d.Add(listeners.BandwidthTriggered{BandwidthTraffic: listeners.BandwidthTraffic{BytesPerSecond: 100}})
+	d.Add(listeners.SLOReport{Objective: 99.9, BudgetRemaining: 0.75})
+
+	lines := d.render(100, 30)
+	if len(lines) != 30 {
+		t.Errorf("expected the dashboard to fill the height, got %d lines", len(lines))
+	}
+	screen := strings.Join(lines, "\n")
+	for _, expected := range []string{"HIGH BANDWIDTH, HIGH TRAFFIC", "/api", "5xx", "hits = 1500", "total: 1.5KiB", "75.0% of the error budget remaining", "Apdex  score: 0.92"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("expected %q in the dashboard:\n%s", expected, screen)
+		}
+	}
+}
+
+func TestDashboardRenderSectionTree(t *testing.T) {
+	d := New(nil, nil, 10*time.Second)
+	d.view = Sections
+	d.Add(listeners.SummaryReport{
+		SectionTree: []listeners.SectionNode{
+			{Section: "/api", Hits: 12, Children: []listeners.SectionNode{{Section: "/api/user", Hits: 9}}},
+		},
+		Trending: []listeners.TrendingSection{{Section: "/report", Hits: 30, Growth: 4.2}},
+		TopSectionsByStatusClass: map[string][]listeners.SummaryReportItem{
+			"5xx": {{Key: "/checkout", Value: 7}},
+		},
+		ApdexBySection: []listeners.SectionApdex{
+			{Section: "/search", ApdexScore: listeners.ApdexScore{Score: 0.5, Apdex: log.Apdex{Satisfied: 1, Frustrated: 1}}},
+		},
+	})
+	screen := strings.Join(d.render(100, 40), "\n")
+	if !strings.Contains(screen, "  /api ") || !strings.Contains(screen, "    /api/user ") || !strings.Contains(screen, "x4.2") {
+		t.Errorf("expected the section tree in the sections view:\n%s", screen)
+	}
+	if !strings.Contains(screen, "Top sections with 5xx") || !strings.Contains(screen, "/checkout") {
+		t.Errorf("expected the sections with errors in the sections view:\n%s", screen)
+	}
+	if !strings.Contains(screen, "Apdex by section") || !strings.Contains(screen, "/search                          0.50      2") {
+		t.Errorf("expected the Apdex of the sections in the sections view:\n%s", screen)
+	}
+}
+
+func TestDashboardRenderUnique(t *testing.T) {
+	d := New(nil, nil, 10*time.Second)
+	d.view = Clients
+	d.Add(listeners.SummaryReport{
+		Unique:     listeners.UniqueCounts{IPAddresses: 42, Users: 7, URLs: 12},
+		UniqueOver: []listeners.UniqueWindow{{Duration: time.Hour, UniqueCounts: listeners.UniqueCounts{IPAddresses: 420}}},
+	})
+	screen := strings.Join(d.render(100, 30), "\n")
+	for _, expected := range []string{"IP addresses: 42 ", "last 1h", "IP addresses: 420 "} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("expected %q in the clients view:\n%s", expected, screen)
+		}
+	}
+}
+
+func TestDashboardAlertsByListener(t *testing.T) {
+	d := New(nil, nil, 10*time.Second)
+	d.Add(listeners.NamedAlert{AlertEvent: listeners.AlertTriggered{}, Listener: "api_alert"})
+	d.Add(listeners.NamedAlert{AlertEvent: listeners.AlertTriggered{}, Listener: "shop_alert"})
+	d.Add(listeners.NamedAlert{AlertEvent: listeners.AlertRecovered{}, Listener: "shop_alert"})
+	// The alert of the other listener is still active, and shown once
+	if header := d.render(100, 10)[0]; !strings.Contains(header, "alert: HIGH TRAFFIC ") {
+		t.Errorf("expected the high traffic alert to be active: %s", header)
+	}
+}
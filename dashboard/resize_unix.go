@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package dashboard
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize sends a signal into c when the terminal is resized
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
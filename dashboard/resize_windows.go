@@ -0,0 +1,6 @@
+package dashboard
+
+import "os"
+
+// notifyResize does nothing, since there is no signal when a Windows console is resized
+func notifyResize(c chan<- os.Signal) {}
@@ -0,0 +1,73 @@
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ANSI escape codes used to draw the dashboard
+const (
+	escAltScreen       = "\x1b[?1049h"
+	escMainScreen      = "\x1b[?1049l"
+	escHideCursor      = "\x1b[?25l"
+	escShowCursor      = "\x1b[?25h"
+	escHome            = "\x1b[H"
+	escClearLine       = "\x1b[K"
+	escClearScreenDown = "\x1b[J"
+)
+
+// terminal switches the controlling terminal into a mode where single key presses can
+// be read without echoing them, and restores the original mode afterwards.
+// It uses stty so it doesn't depend on any platform specific system calls.
+type terminal struct {
+	state string
+	// width and height are queried when the terminal is set up and when it is resized
+	width, height int
+}
+
+func newTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %v", err)
+	}
+	// -isig so ctrl-c is read as a key press and the terminal is restored before exiting.
+	// Reads return after a tenth of a second without a key press, so the reader can stop.
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "0", "time", "1"); err != nil {
+		return nil, err
+	}
+	t := &terminal{state: state}
+	t.resize()
+	return t, nil
+}
+
+func (t *terminal) setup(out io.Writer) {
+	io.WriteString(out, escAltScreen+escHideCursor)
+}
+
+func (t *terminal) restore(out io.Writer) {
+	io.WriteString(out, escShowCursor+escMainScreen)
+	stty(t.state)
+}
+
+// resize queries the width and height of the terminal, defaulting to 80x24
+func (t *terminal) resize() {
+	t.width, t.height = 80, 24
+	if out, err := stty("size"); err == nil {
+		fmt.Sscanf(out, "%d %d", &t.height, &t.width)
+	}
+}
+
+// size returns the width and height of the terminal when it was last resized
+func (t *terminal) size() (width, height int) {
+	return t.width, t.height
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,220 @@
+// Package ingest receives access log lines that are pushed to the monitor over HTTP, for services
+// that can't share a filesystem with it.
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// The defaults for a Handler
+const (
+	DefaultMaxBodySize = 1 << 20 // 1 MiB
+	DefaultQueueSize   = 10000
+)
+
+// Handler accepts batches of lines POSTed to it, either as raw text with one line per line of the
+// body, or as NDJSON when the Content-Type is application/x-ndjson, where every line of the body is
+// either a JSON string with the raw line or an object such as {"line": "...", "source": "checkout"}.
+//
+// The lines are parsed with the format and queued for Receive. A batch is either queued entirely
+// or not at all: if the queue doesn't have room for it, the response is 429 Too Many Requests and
+// the batch should be sent again later. Lines that can't be parsed are counted in the response and
+// sent into the ErrorChannel, the rest of the batch is still queued.
+//
+// The source of the lines is the source in the NDJSON object, the source query parameter or the
+// address of the client, in that order.
+type Handler struct {
+	// Token is the bearer token that requests need in their Authorization header,
+	// it can be empty to accept requests without one
+	Token string
+	// MaxBodySize is the largest request body that is accepted, in bytes
+	MaxBodySize int64
+
+	format *log.Format
+	errs   log.ErrorChannel
+
+	mu    sync.Mutex // serializes queueing so a batch can be checked against the room in the queue
+	queue chan log.Line
+}
+
+// New returns a Handler that parses lines with the format and queues up to queueSize of them.
+// Errors parsing lines are sent into errs, it can be nil to discard them.
+func New(format *log.Format, queueSize int, errs log.ErrorChannel) *Handler {
+	return &Handler{
+		MaxBodySize: DefaultMaxBodySize,
+		format:      format,
+		errs:        errs,
+		queue:       make(chan log.Line, queueSize),
+	}
+}
+
+// Receive sends the queued lines into the Channel until the context is cancelled,
+// then it closes the Channel.
+// This should be run within a goroutine
+func (h *Handler) Receive(ctx context.Context, lc log.Channel) {
+	defer close(lc)
+	for {
+		select {
+		case line := <-h.queue:
+			select {
+			case lc <- line:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// response is the body of the responses to POST requests
+type response struct {
+	Accepted int    `json:"accepted"`
+	Invalid  int    `json:"invalid"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ServeHTTP is part of the http.Handler interface
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "method not allowed"})
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, response{Error: "a valid bearer token is required"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxBodySize))
+	switch {
+	case err != nil && int64(len(body)) == h.MaxBodySize:
+		// MaxBytesReader returns the limit and then an error once the body goes over it
+		writeJSON(w, http.StatusRequestEntityTooLarge, response{Error: fmt.Sprintf("the body is larger than %d bytes", h.MaxBodySize)})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, response{Error: fmt.Sprintf("the body couldn't be read: %v", err)})
+		return
+	}
+
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(source); err == nil {
+			source = host
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	lines, invalid := h.parse(r.Context(), body, source, mediaType == "application/x-ndjson")
+
+	if len(lines) > cap(h.queue) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, response{Invalid: invalid, Error: fmt.Sprintf("the batch has more than %d lines", cap(h.queue))})
+		return
+	}
+	if !h.enqueue(lines) {
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, response{Invalid: invalid, Error: "the queue is full, send the batch again later"})
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Accepted: len(lines), Invalid: invalid})
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(h.Token)) == 1
+}
+
+// parse returns the lines in the body that could be parsed and the number that couldn't
+func (h *Handler) parse(ctx context.Context, body []byte, source string, ndjson bool) (lines []log.Line, invalid int) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1) // a line can be as long as the body
+	for scanner.Scan() {
+		raw, lineSource := strings.TrimRight(scanner.Text(), "\r"), source
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if ndjson {
+			var err error
+			if raw, lineSource, err = decodeNDJSON(raw, source); err != nil {
+				invalid++
+				h.errs.Send(ctx, &log.Error{Stage: log.StageParse, Reason: log.ReasonInvalidJSON, Raw: scanner.Text(), Err: err})
+				continue
+			}
+		}
+		line, err := h.format.Parse(raw)
+		if err != nil {
+			invalid++
+			h.errs.Send(ctx, err)
+			continue
+		}
+		line.Source = lineSource
+		lines = append(lines, line)
+	}
+	return lines, invalid
+}
+
+// ndjsonLine is a line of an NDJSON body that is an object
+type ndjsonLine struct {
+	Line   string `json:"line"`
+	Source string `json:"source"`
+}
+
+// decodeNDJSON returns the raw line and its source from a line of an NDJSON body
+func decodeNDJSON(data, source string) (string, string, error) {
+	if strings.HasPrefix(strings.TrimSpace(data), "\"") {
+		var raw string
+		err := json.Unmarshal([]byte(data), &raw)
+		return raw, source, err
+	}
+	var l ndjsonLine
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return "", "", err
+	}
+	if l.Line == "" {
+		return "", "", fmt.Errorf("the object doesn't have a line")
+	}
+	if l.Source != "" {
+		source = l.Source
+	}
+	return l.Line, source, nil
+}
+
+// enqueue queues all of the lines, or none of them if there isn't room for all of them
+func (h *Handler) enqueue(lines []log.Line) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Receive only takes lines out of the queue, so there's at least this much room
+	if cap(h.queue)-len(h.queue) < len(lines) {
+		return false
+	}
+	for _, line := range lines {
+		h.queue <- line
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
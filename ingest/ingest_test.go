@@ -0,0 +1,106 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+const accessLine = `127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`
+
+func post(h http.Handler, target, contentType, token, body string) (*httptest.ResponseRecorder, response) {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp response
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestHandler(t *testing.T) {
+	h := New(log.CommonFormat, 10, nil)
+
+	// Raw text, with a line that can't be parsed
+	rec, resp := post(h, "/api/ingest?source=checkout", "text/plain", "", accessLine+"\nnot a log line\n"+accessLine)
+	if rec.Code != http.StatusOK || resp.Accepted != 2 || resp.Invalid != 1 {
+		t.Errorf("bad response for raw text: %d %+v", rec.Code, resp)
+	}
+	// NDJSON, with strings and objects
+	ndjson := `"` + strings.Replace(accessLine, `"`, `\"`, -1) + `"` + "\n" +
+		`{"line": "` + strings.Replace(accessLine, `"`, `\"`, -1) + `", "source": "search"}` + "\n" +
+		`{"no": "line"}`
+	rec, resp = post(h, "/api/ingest", "application/x-ndjson; charset=utf-8", "", ndjson)
+	if rec.Code != http.StatusOK || resp.Accepted != 2 || resp.Invalid != 1 {
+		t.Errorf("bad response for NDJSON: %d %+v", rec.Code, resp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := make(log.Channel)
+	go h.Receive(ctx, lc)
+	var sources []string
+	for i := 0; i < 4; i++ {
+		line := <-lc
+		sources = append(sources, line.Source)
+	}
+	if strings.Join(sources, ",") != "checkout,checkout,192.0.2.1,search" {
+		t.Errorf("bad sources: %v", sources)
+	}
+	cancel()
+	for range lc {
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	h := New(log.CommonFormat, 2, nil)
+	h.Token = "secret"
+	h.MaxBodySize = 200
+
+	if rec, _ := post(h, "/", "text/plain", "", accessLine); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without a token to be unauthorized, got: %d", rec.Code)
+	}
+	if rec, _ := post(h, "/", "text/plain", "wrong", accessLine); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request with the wrong token to be unauthorized, got: %d", rec.Code)
+	}
+	if rec, _ := post(h, "/", "text/plain", "secret", strings.Repeat(accessLine+"\n", 3)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a body that is too large to be rejected, got: %d", rec.Code)
+	}
+
+	// The queue has room for two lines, so the second batch doesn't fit and none of it is queued
+	if rec, _ := post(h, "/", "text/plain", "secret", accessLine); rec.Code != http.StatusOK {
+		t.Errorf("expected the first batch to be accepted, got: %d", rec.Code)
+	}
+	rec, _ := post(h, "/", "text/plain", "secret", accessLine+"\n"+accessLine)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the batch to be rejected until there's room, got: %d", rec.Code)
+	}
+	if len(h.queue) != 1 {
+		t.Errorf("expected none of the rejected batch to be queued, got: %d", len(h.queue))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected only POST to be allowed, got: %d", rec.Code)
+	}
+
+	// A body that fails part of the way through isn't too large
+	req = httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader(accessLine), iotest.TimeoutReader(strings.NewReader(accessLine))))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a body that can't be read to be a bad request, got: %d", rec.Code)
+	}
+}
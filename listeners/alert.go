@@ -1,15 +1,24 @@
 package listeners
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/caitlin615/logmonitor/log"
 )
 
-// This ensures adherence to the Listener interface
-var _ = Listener(Alert{})
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&Alert{})
+	_ = Reconfigurable(&Alert{})
+)
+
+func init() {
+	Register("alert", newAlertFromOptions)
+}
 
 var (
 	// ErrInHighTrafficState is the error returned when the listener is has previously reported that there's high traffic
@@ -18,83 +27,260 @@ var (
 	ErrLowTrafficState = errors.New("Low traffic state")
 )
 
-// Alert is a Listener that will output summary reports
+// Alert is a Listener that will output alerts when the traffic crosses the threshold.
+// It is safe to call Add and Report from multiple goroutines, including while it is started.
 type Alert struct {
-	triggerInterval   time.Duration
-	thresholdInterval time.Duration
-	logs              log.Lines
-	rpsThreshold      int64
+	intervals intervalChan
 
+	mu                 sync.Mutex // guards everything below
+	triggerInterval    time.Duration
+	thresholdInterval  time.Duration
+	rpsThreshold       int64
+	router             *log.Router       // only lines that match one of its routes are counted, if set
+	filter             *log.StatusFilter // only lines with one of its statuses and methods are counted, if set
+	logs               log.Lines
 	isInHighAlertState bool
 }
 
 // NewAlertListener returns an Alert listener with the specified requests per second threshold.
-// TODO: Defaults to a 2 minute threshold interval, should this be configurable?
-func NewAlertListener(reqPerSecondThreshold int64) Alert {
-	return Alert{
+// It defaults to a 2 minute threshold interval, which can be changed with the "window" option
+// in the configuration file.
+func NewAlertListener(reqPerSecondThreshold int64) *Alert {
+	return &Alert{
 		triggerInterval:   10 * time.Second,
 		thresholdInterval: 2 * time.Minute,
 		rpsThreshold:      reqPerSecondThreshold,
+		intervals:         newIntervalChan(),
 	}
 }
 
-// Report returns the summary report during based on what's currently in the logs
-func (a *Alert) Report() (string, error) {
-	// TODO: Mutexes when reading a.logs
+// newAlertFromOptions is the Factory for the "alert" listener. The options are:
+//
+//	threshold: the average requests per second that triggers the alert, defaults to 10
+//	window:    the period of time the requests are averaged over, defaults to "2m"
+//	interval:  how often to check the traffic, defaults to "10s"
+//	routes:    patterns such as "/api/v1/orders/:id", only the requests that match one of them are counted
+//	status:    classes such as "5xx" or codes such as "404", only the requests with one of them are counted
+//	methods:   methods such as "POST", only the requests with one of them are counted
+func newAlertFromOptions(opts Options) (Listener, error) {
+	a := NewAlertListener(10)
+	if err := a.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The lines in the current window and whether the alert is triggered are kept, so a new
+// threshold is applied at the next check.
+func (a *Alert) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("threshold", "window", "interval", "routes", "status", "methods"); err != nil {
+		return err
+	}
+	threshold, err := opts.Int("threshold", 10)
+	if err != nil {
+		return err
+	}
+	if threshold < 0 {
+		return fmt.Errorf("threshold should not be negative, got %d", threshold)
+	}
+	window, err := opts.Duration("window", 2*time.Minute)
+	if err != nil {
+		return err
+	}
+	if window < time.Second {
+		return fmt.Errorf("window should be at least 1s, got %s", window)
+	}
+	interval, err := opts.Duration("interval", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	routes, err := opts.Strings("routes")
+	if err != nil {
+		return err
+	}
+	var router *log.Router
+	if len(routes) > 0 {
+		if router, err = log.NewRouter(routes, false); err != nil {
+			return err
+		}
+	}
+	statuses, err := opts.Strings("status")
+	if err != nil {
+		return err
+	}
+	methods, err := opts.Strings("methods")
+	if err != nil {
+		return err
+	}
+	var filter *log.StatusFilter
+	if len(statuses) > 0 || len(methods) > 0 {
+		if filter, err = log.NewStatusFilter(statuses, methods); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rpsThreshold = threshold
+	a.thresholdInterval = window
+	a.router = router
+	a.filter = filter
+	if interval != a.triggerInterval {
+		a.triggerInterval = interval
+		a.intervals.set(interval)
+	}
+	return nil
+}
+
+// Report returns an AlertTriggered or AlertRecovered event when the traffic over the
+// threshold interval crosses the requests per second threshold
+func (a *Alert) Report() (Event, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	now := time.Now().UTC()
 	start := now.Add(-a.thresholdInterval)
 	var count int64
 
-	// Count the number of logs between now and the threshold
+	// Count the number of logs between now and the threshold, dropping the older
+	// ones since they won't be counted again
+	kept := a.logs[:0]
 	for _, line := range a.logs {
 		if start.Before(line.Date.UTC()) {
 			count++
+			kept = append(kept, line)
 		}
 	}
+	a.logs = kept
 
 	averageReqPerSec := count / int64(a.thresholdInterval.Seconds())
 	highTraffic := averageReqPerSec > a.rpsThreshold
+	traffic := AlertTraffic{
+		Timestamp:    now,
+		Window:       Window{Start: start, End: now},
+		Hits:         count,
+		ReqPerSecond: averageReqPerSec,
+		Threshold:    a.rpsThreshold,
+	}
 
 	// Previous report determined that we are in a high traffic state
 	if a.isInHighAlertState {
 		// Now we aren't getting high traffic, so send an alert that it's over
 		if !highTraffic {
 			a.isInHighAlertState = false
-			return fmt.Sprintf("High traffic state ended: %s", now), nil
+			return AlertRecovered{traffic}, nil
 		}
-		return "", ErrInHighTrafficState
+		return nil, ErrInHighTrafficState
 	}
 
 	if highTraffic {
 		a.isInHighAlertState = true
-		return fmt.Sprintf("High traffic generated an alert - hits = %d, triggered at %s", count, now), nil
+		return AlertTriggered{traffic}, nil
 	}
-	return "", ErrLowTrafficState
+	return nil, ErrLowTrafficState
 }
 
-// Start starts the Alert listener
-func (a Alert) Start(listenChan log.Channel) OutputChannel {
-	recv := make(OutputChannel)
-	// start a goroutine that will listen for log entries
-	go func() {
-		for {
-			select {
-			case in := <-listenChan:
-				// TODO: Use mutexes since this will be accessed across multiple goroutines
-				a.logs = append(a.logs, in)
-			}
+// Add appends the line to the log storage, unless the Alert only counts some routes, statuses
+// or methods and the line doesn't match them
+func (a *Alert) Add(line log.Line) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.router != nil {
+		if _, ok := a.router.Match(&line.Request); !ok {
+			return
 		}
-	}()
+	}
+	if a.filter != nil && !a.filter.Match(line) {
+		return
+	}
+	a.logs = append(a.logs, line)
+}
 
-	// Start a goroutine to send a report into the output channel every X seconds based on the trigger time
+// Start starts the Alert listener. The OutputChannel is closed when the context is cancelled
+// or the log channel is closed.
+func (a *Alert) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	// start a goroutine that will listen for log entries and check for an alert every X seconds
+	// based on the trigger time
 	go func() {
-		clock := time.Tick(a.triggerInterval)
-		for range clock {
-			if report, err := a.Report(); err == nil {
-				recv <- report
-			}
-		}
+		defer close(recv)
+		a.mu.Lock()
+		interval := a.triggerInterval
+		a.mu.Unlock()
+		runTicker(ctx, interval, a.intervals, listenChan, a.Add, func() {
+			report, err := a.Report()
+			sendAlert(ctx, recv, errs, report, err)
+		})
 	}()
 
 	return recv
 }
+
+// This ensures adherence to the AlertEvent interface
+var (
+	_ = AlertEvent(AlertTriggered{})
+	_ = AlertEvent(AlertRecovered{})
+)
+
+// AlertTraffic holds the traffic values that an alert was evaluated against
+type AlertTraffic struct {
+	Timestamp    time.Time `json:"-"`
+	Window       Window    `json:"-"`
+	Hits         int64     `json:"hits"`
+	ReqPerSecond int64     `json:"req_per_second"`
+	Threshold    int64     `json:"threshold"`
+}
+
+// Time is part of the Event interface
+func (at AlertTraffic) Time() time.Time {
+	return at.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (at AlertTraffic) TimeWindow() Window {
+	return at.Window
+}
+
+// AlertName is part of the AlertEvent interface
+func (at AlertTraffic) AlertName() string {
+	return "high_traffic"
+}
+
+// AlertTriggered is the Event sent when the average requests per second exceeds the threshold
+type AlertTriggered struct {
+	AlertTraffic
+}
+
+// Triggered is part of the AlertEvent interface
+func (at AlertTriggered) Triggered() bool {
+	return true
+}
+
+// Type is part of the Event interface
+func (at AlertTriggered) Type() string {
+	return "alert_triggered"
+}
+
+func (at AlertTriggered) String() string {
+	return fmt.Sprintf("High traffic generated an alert - hits = %d, triggered at %s", at.Hits, at.Timestamp)
+}
+
+// AlertRecovered is the Event sent when the average requests per second drops back below the threshold
+type AlertRecovered struct {
+	AlertTraffic
+}
+
+// Type is part of the Event interface
+func (ar AlertRecovered) Type() string {
+	return "alert_recovered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (ar AlertRecovered) Triggered() bool {
+	return false
+}
+
+func (ar AlertRecovered) String() string {
+	return fmt.Sprintf("High traffic state ended: %s", ar.Timestamp)
+}
@@ -1,6 +1,7 @@
 package listeners
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -34,8 +35,11 @@ func TestAlertReport(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if !strings.HasPrefix(report, "High traffic generated an alert") {
-		t.Errorf("expected high alert report, got: %s", report)
+	if _, ok := report.(AlertTriggered); !ok {
+		t.Errorf("expected high alert report, got: %v", report)
+	}
+	if !strings.HasPrefix(fmt.Sprint(report), "High traffic generated an alert") {
+		t.Errorf("expected high alert message, got: %s", report)
 	}
 
 	alert.logs = append(alert.logs, addLines(500)...)
@@ -54,7 +58,75 @@ func TestAlertReport(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if !strings.HasPrefix(report, "High traffic state ended") {
-		t.Errorf("expected high traffic ended report, got: %s", report)
+	if _, ok := report.(AlertRecovered); !ok {
+		t.Errorf("expected high traffic ended report, got: %v", report)
+	}
+	if !strings.HasPrefix(fmt.Sprint(report), "High traffic state ended") {
+		t.Errorf("expected high traffic ended message, got: %s", report)
+	}
+}
+
+func TestAlertReconfigure(t *testing.T) {
+	alert := NewAlertListener(10)
+	for i := 0; i < 240; i++ {
+		alert.Add(log.Line{Date: time.Now()})
+	}
+	if _, err := alert.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected low traffic before reconfiguring, got: %v", err)
+	}
+
+	if err := alert.Reconfigure(Options{"threshold": int64(-1)}); err == nil {
+		t.Error("expected an error for a negative threshold")
+	}
+	if err := alert.Reconfigure(Options{"threshold": int64(1), "interval": "1s"}); err != nil {
+		t.Fatal(err)
+	}
+	// The lines that were already added are checked against the new threshold
+	report, err := alert.Report()
+	if _, ok := report.(AlertTriggered); !ok || err != nil {
+		t.Errorf("expected the new threshold to trigger an alert, got: %v %v", report, err)
+	}
+}
+
+func TestAlertRoutes(t *testing.T) {
+	alert := NewAlertListener(1)
+	if err := alert.Reconfigure(Options{"threshold": int64(1), "window": "1s", "routes": []interface{}{"/checkout/*"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		alert.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/static/site.css"}})
+	}
+	if _, err := alert.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected the other routes not to be counted, got: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		alert.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/checkout/pay"}})
+	}
+	if report, err := alert.Report(); err != nil || report.(AlertTriggered).Hits != 10 {
+		t.Errorf("expected an alert for the matching route, got: %v %v", report, err)
+	}
+}
+
+func TestAlertStatus(t *testing.T) {
+	alert := NewAlertListener(1)
+	if err := alert.Reconfigure(Options{"threshold": int64(1), "window": "1s", "status": []interface{}{"5xx"}, "methods": []interface{}{"POST"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		alert.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "POST", URL: "/checkout"}, StatusCode: 200})
+		alert.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: "/checkout"}, StatusCode: 503})
+	}
+	if _, err := alert.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected the other statuses and methods not to be counted, got: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		alert.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "POST", URL: "/checkout"}, StatusCode: 503})
+	}
+	if report, err := alert.Report(); err != nil || report.(AlertTriggered).Hits != 10 {
+		t.Errorf("expected an alert for the server errors, got: %v %v", report, err)
+	}
+
+	if err := alert.Reconfigure(Options{"status": []interface{}{"6xx"}}); err == nil {
+		t.Error("expected an error for an invalid status")
 	}
 }
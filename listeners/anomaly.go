@@ -0,0 +1,404 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/counter"
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&Anomaly{})
+	_ = Reconfigurable(&Anomaly{})
+)
+
+func init() {
+	Register("anomaly_alert", newAnomalyFromOptions)
+}
+
+// ErrWarmingUp is the error returned when the Anomaly listener hasn't learned its baseline for long enough to alert
+var ErrWarmingUp = errors.New("Learning the baseline")
+
+// anomalyMetrics are the values that each line adds to the metrics an Anomaly listener can watch
+var anomalyMetrics = map[string]func(log.Line) float64{
+	"requests": func(l log.Line) float64 { return 1 },
+	"bytes":    func(l log.Line) float64 { return float64(l.Size) },
+	"errors": func(l log.Line) float64 {
+		if l.IsServerError() {
+			return 1
+		}
+		return 0
+	},
+}
+
+// Anomaly is a Listener that will output alerts when a metric, such as the requests per second,
+// is unusual rather than over a fixed threshold. Every interval it compares the metric with its
+// baseline, an exponentially weighted mean and standard deviation of the metric in the previous
+// intervals, so the baseline follows traffic that changes over the day. The baseline can be
+// saved to a file, so it isn't learned again after a restart.
+// It is safe to call Add and Report from multiple goroutines, including while it is started.
+type Anomaly struct {
+	intervals intervalChan
+
+	mu                 sync.Mutex // guards everything below
+	triggerInterval    time.Duration
+	metric             string
+	halfLife           time.Duration
+	deviations         float64
+	warmUp             time.Duration
+	stateFile          string
+	value              float64   // of the metric since the start of the interval
+	since              time.Time // start of the current interval
+	baseline           counter.EWMA
+	learned            time.Duration // how long the baseline has been learned for
+	isInHighAlertState bool
+}
+
+// NewAnomalyListener returns an Anomaly listener for the requests per second, which checks
+// every minute, has a baseline with a half-life of an hour, and alerts when the requests are
+// 3 standard deviations from the baseline after an hour of learning it
+func NewAnomalyListener() *Anomaly {
+	return &Anomaly{
+		triggerInterval: time.Minute,
+		metric:          "requests",
+		halfLife:        time.Hour,
+		deviations:      3,
+		warmUp:          time.Hour,
+		since:           time.Now().UTC(),
+		intervals:       newIntervalChan(),
+	}
+}
+
+// newAnomalyFromOptions is the Factory for the "anomaly_alert" listener. The options are:
+//
+//	metric:     "requests", "bytes" or "errors" (5xx responses), watched per second, defaults to "requests"
+//	interval:   how often the metric is checked and added to the baseline, defaults to "1m"
+//	half_life:  the time it takes for a check to count half as much in the baseline, defaults to "1h"
+//	deviations: the number of standard deviations from the baseline that trigger the alert, defaults to 3
+//	warm_up:    how long the baseline is learned before alerting, defaults to "1h"
+//	state_file: a file the baseline is saved to and loaded from, so it is kept across restarts
+func newAnomalyFromOptions(opts Options) (Listener, error) {
+	a := NewAnomalyListener()
+	if err := a.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The baseline is kept unless the metric changes, and is loaded when the state file changes.
+func (a *Anomaly) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("metric", "interval", "half_life", "deviations", "warm_up", "state_file"); err != nil {
+		return err
+	}
+	metric, err := opts.String("metric", "requests")
+	if err != nil {
+		return err
+	}
+	if _, ok := anomalyMetrics[metric]; !ok {
+		return fmt.Errorf("metric should be one of %s, got %q", strings.Join(anomalyMetricNames(), ", "), metric)
+	}
+	interval, err := opts.Duration("interval", time.Minute)
+	if err != nil {
+		return err
+	}
+	halfLife, err := opts.Duration("half_life", time.Hour)
+	if err != nil {
+		return err
+	}
+	if halfLife <= 0 {
+		return fmt.Errorf("half_life should be positive, got %s", halfLife)
+	}
+	deviations, err := opts.Float("deviations", 3)
+	if err != nil {
+		return err
+	}
+	if deviations <= 0 {
+		return fmt.Errorf("deviations should be positive, got %v", deviations)
+	}
+	warmUp, err := opts.Duration("warm_up", time.Hour)
+	if err != nil {
+		return err
+	}
+	stateFile, err := opts.String("state_file", "")
+	if err != nil {
+		return err
+	}
+	var state *anomalyState
+	if stateFile != "" {
+		if state, err = loadAnomalyState(stateFile, metric); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if metric != a.metric {
+		a.baseline, a.learned, a.value = counter.EWMA{}, 0, 0
+	}
+	if state != nil && stateFile != a.stateFile {
+		a.baseline, a.learned = state.restore(time.Now().UTC(), halfLife)
+	}
+	a.metric = metric
+	a.halfLife = halfLife
+	a.deviations = deviations
+	a.warmUp = warmUp
+	a.stateFile = stateFile
+	if interval != a.triggerInterval {
+		a.triggerInterval = interval
+		a.intervals.set(interval)
+	}
+	return nil
+}
+
+func anomalyMetricNames() []string {
+	names := make([]string, 0, len(anomalyMetrics))
+	for name := range anomalyMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add adds the line to the metric of the current interval
+func (a *Anomaly) Add(line log.Line) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.value += anomalyMetrics[a.metric](line)
+}
+
+// Report compares the metric per second since the last report with the baseline and adds it to
+// the baseline. It returns an AnomalyTriggered or AnomalyRecovered event when the metric becomes
+// unusual or usual again, ErrInHighTrafficState or ErrLowTrafficState when nothing has changed,
+// and ErrWarmingUp until the baseline has been learned for the warm-up period.
+func (a *Anomaly) Report() (Event, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now().UTC()
+	window := Window{Start: a.since, End: now}
+	seconds := window.Duration().Seconds()
+	if seconds <= 0 {
+		return nil, ErrLowTrafficState
+	}
+	value := a.value / seconds
+	a.value = 0
+	a.since = now
+
+	// The standard deviation is at least that of counting random arrivals, so a metric that
+	// doesn't vary, such as no errors at all, doesn't alert on the first one
+	minStdDev := math.Sqrt(math.Max(a.baseline.Mean*seconds, 1)) / seconds
+	traffic := AnomalyTraffic{
+		Timestamp:  now,
+		Window:     window,
+		Metric:     a.metric,
+		Value:      value,
+		Mean:       a.baseline.Mean,
+		StdDev:     math.Max(a.baseline.StdDev(), minStdDev),
+		ZScore:     a.baseline.ZScore(value, minStdDev),
+		Deviations: a.deviations,
+	}
+	warmedUp := a.baseline.Samples > 0 && a.learned >= a.warmUp
+	// Once the baseline is learned, unusual values are added as if they were only the deviations
+	// from the mean, so a spike doesn't become the new normal at once but a lasting change is
+	// still learned
+	added := value
+	if warmedUp {
+		limit := a.deviations * traffic.StdDev
+		added = math.Min(math.Max(value, a.baseline.Mean-limit), a.baseline.Mean+limit)
+	}
+	a.baseline.Add(added, counter.Alpha(window.Duration(), a.halfLife))
+	a.learned += window.Duration()
+
+	if !warmedUp {
+		return nil, ErrWarmingUp
+	}
+	anomalous := math.Abs(traffic.ZScore) > a.deviations
+	if a.isInHighAlertState {
+		if !anomalous {
+			a.isInHighAlertState = false
+			return AnomalyRecovered{traffic}, nil
+		}
+		return nil, ErrInHighTrafficState
+	}
+	if anomalous {
+		a.isInHighAlertState = true
+		return AnomalyTriggered{traffic}, nil
+	}
+	return nil, ErrLowTrafficState
+}
+
+// anomalyState is the baseline of an Anomaly listener as it is saved to its state file
+type anomalyState struct {
+	Metric         string       `json:"metric"`
+	Baseline       counter.EWMA `json:"baseline"`
+	LearnedSeconds float64      `json:"learned_seconds"`
+	Updated        time.Time    `json:"updated"`
+}
+
+// staleHalfLives is the number of half-lives after which a saved baseline counts for less than
+// an eighth, so it is learned again from scratch
+const staleHalfLives = 3
+
+// restore returns the baseline of the state and how long it has been learned for, or nothing if
+// the state was saved so long ago that the traffic it learned no longer says much about now
+func (s *anomalyState) restore(now time.Time, halfLife time.Duration) (counter.EWMA, time.Duration) {
+	if s.Updated.IsZero() || now.Sub(s.Updated) >= staleHalfLives*halfLife {
+		return counter.EWMA{}, 0
+	}
+	return s.Baseline, time.Duration(s.LearnedSeconds * float64(time.Second))
+}
+
+// loadAnomalyState reads the state file, which doesn't have to exist yet, and checks it is for the metric
+func loadAnomalyState(path, metric string) (*anomalyState, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &anomalyState{Metric: metric}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state anomalyState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("state_file %s: %v", path, err)
+	}
+	if state.Metric != metric {
+		return nil, fmt.Errorf("state_file %s has the baseline of the %s metric, not %s", path, state.Metric, metric)
+	}
+	return &state, nil
+}
+
+// save writes the baseline to the state file, if there is one. The file is replaced at once,
+// so a crash doesn't leave half of it.
+func (a *Anomaly) save() error {
+	a.mu.Lock()
+	path := a.stateFile
+	state := anomalyState{
+		Metric:         a.metric,
+		Baseline:       a.baseline,
+		LearnedSeconds: a.learned.Seconds(),
+		Updated:        time.Now().UTC(),
+	}
+	a.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Start starts the Anomaly listener. The baseline is saved after every check, and the
+// OutputChannel is closed when the context is cancelled or the log channel is closed.
+func (a *Anomaly) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	go func() {
+		defer close(recv)
+		a.mu.Lock()
+		interval := a.triggerInterval
+		a.since = time.Now().UTC()
+		a.mu.Unlock()
+		runTicker(ctx, interval, a.intervals, listenChan, a.Add, func() {
+			// While warming up, nothing is reported
+			if report, err := a.Report(); err != ErrWarmingUp {
+				sendAlert(ctx, recv, errs, report, err)
+			}
+			if err := a.save(); err != nil {
+				errs.Send(ctx, &log.Error{Stage: log.StageListener, Reason: log.ReasonWrite, Err: err})
+			}
+		})
+	}()
+
+	return recv
+}
+
+// This ensures adherence to the AlertEvent interface
+var (
+	_ = AlertEvent(AnomalyTriggered{})
+	_ = AlertEvent(AnomalyRecovered{})
+)
+
+// AnomalyTraffic holds the metric that an anomaly alert was evaluated against
+type AnomalyTraffic struct {
+	Timestamp time.Time `json:"-"`
+	Window    Window    `json:"-"`
+	Metric    string    `json:"metric"`
+	// Value is the metric per second in the window, and Mean and StdDev are its baseline
+	Value  float64 `json:"value"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	// ZScore is how many standard deviations the Value is from the Mean, negative if it is below
+	ZScore     float64 `json:"z_score"`
+	Deviations float64 `json:"deviations"`
+}
+
+// Time is part of the Event interface
+func (at AnomalyTraffic) Time() time.Time {
+	return at.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (at AnomalyTraffic) TimeWindow() Window {
+	return at.Window
+}
+
+// AlertName is part of the AlertEvent interface, such as "anomalous_requests"
+func (at AnomalyTraffic) AlertName() string {
+	return "anomalous_" + at.Metric
+}
+
+// AnomalyTriggered is the Event sent when the metric is more than the deviations from its baseline
+type AnomalyTriggered struct {
+	AnomalyTraffic
+}
+
+// Type is part of the Event interface
+func (at AnomalyTriggered) Type() string {
+	return "anomaly_alert_triggered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (at AnomalyTriggered) Triggered() bool {
+	return true
+}
+
+func (at AnomalyTriggered) String() string {
+	return fmt.Sprintf("Anomalous %s generated an alert - %s per second = %.2f, %.1f standard deviations from %.2f, triggered at %s",
+		at.Metric, at.Metric, at.Value, at.ZScore, at.Mean, at.Timestamp)
+}
+
+// AnomalyRecovered is the Event sent when the metric is back within the deviations from its baseline
+type AnomalyRecovered struct {
+	AnomalyTraffic
+}
+
+// Type is part of the Event interface
+func (ar AnomalyRecovered) Type() string {
+	return "anomaly_alert_recovered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (ar AnomalyRecovered) Triggered() bool {
+	return false
+}
+
+func (ar AnomalyRecovered) String() string {
+	return fmt.Sprintf("Anomalous %s state ended: %s", ar.Metric, ar.Timestamp)
+}
@@ -0,0 +1,123 @@
+package listeners
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// reportAnomaly adds n requests to the anomaly listener and reports them as a minute of traffic
+func reportAnomaly(anomaly *Anomaly, n int) (Event, error) {
+	for i := 0; i < n; i++ {
+		anomaly.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api"}})
+	}
+	anomaly.mu.Lock()
+	anomaly.since = time.Now().UTC().Add(-time.Minute)
+	anomaly.mu.Unlock()
+	return anomaly.Report()
+}
+
+func TestAnomalyReport(t *testing.T) {
+	anomaly := NewAnomalyListener()
+	if err := anomaly.Reconfigure(Options{"half_life": "10m", "warm_up": "10m", "deviations": 3.0}); err != nil {
+		t.Fatal(err)
+	}
+	// Between 1200 and 1800 requests a minute, which is 25 per second on average
+	for i := 0; i < 10; i++ {
+		if _, err := reportAnomaly(anomaly, 1200+i%2*600); err != ErrWarmingUp {
+			t.Fatalf("expected to be warming up, got: %v", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := reportAnomaly(anomaly, 1200+i%2*600); err != ErrLowTrafficState {
+			t.Fatalf("expected the usual traffic not to alert, got: %v", err)
+		}
+	}
+
+	// Ten times the usual traffic
+	report, err := reportAnomaly(anomaly, 15000)
+	triggered, ok := report.(AnomalyTriggered)
+	if err != nil || !ok || triggered.Metric != "requests" || triggered.ZScore < 3 {
+		t.Fatalf("expected an anomaly alert, got: %v %v", report, err)
+	}
+	if !strings.HasPrefix(triggered.String(), "Anomalous requests generated an alert - requests per second = 250.00") {
+		t.Errorf("bad message: %s", triggered)
+	}
+	if triggered.AlertName() != "anomalous_requests" {
+		t.Errorf("bad alert name: %s", triggered.AlertName())
+	}
+
+	report, err = reportAnomaly(anomaly, 1500)
+	if _, ok := report.(AnomalyRecovered); err != nil || !ok {
+		t.Errorf("expected the alert to recover, got: %v %v", report, err)
+	}
+
+	// Traffic dropping to nothing is unusual too
+	report, err = reportAnomaly(anomaly, 0)
+	if triggered, ok := report.(AnomalyTriggered); err != nil || !ok || triggered.ZScore > -3 {
+		t.Errorf("expected an alert for the drop in traffic, got: %v %v", report, err)
+	}
+}
+
+func TestAnomalyStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anomaly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{"state_file": filepath.Join(dir, "requests.json"), "warm_up": "5m"}
+
+	anomaly, err := New("anomaly_alert", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		reportAnomaly(anomaly.(*Anomaly), 600)
+	}
+	if err := anomaly.(*Anomaly).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The baseline is loaded after a restart, so there's no need to warm up again
+	restarted, err := New("anomaly_alert", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reportAnomaly(restarted.(*Anomaly), 600); err != ErrLowTrafficState {
+		t.Errorf("expected the baseline to be loaded, got: %v", err)
+	}
+	if b := restarted.(*Anomaly).baseline; b.Samples != 6 || math.Abs(b.Mean-10) > 0.01 {
+		t.Errorf("bad baseline: %+v", b)
+	}
+
+	// A baseline saved more than a few half-lives ago is learned again from scratch
+	baseline := restarted.(*Anomaly).baseline
+	for _, c := range []struct {
+		ago     time.Duration
+		learned float64 // seconds
+	}{
+		{0, 360},
+		{2 * time.Hour, 360},
+		{4 * time.Hour, 0},
+	} {
+		state := anomalyState{Metric: "requests", Baseline: baseline, LearnedSeconds: 360, Updated: time.Now().Add(-c.ago)}
+		b, learned := state.restore(time.Now(), time.Hour)
+		if math.Abs(learned.Seconds()-c.learned) > 1 || (b.Samples == 0) != (c.learned == 0) {
+			t.Errorf("bad baseline %+v learned for %s, %s after it was saved", b, learned, c.ago)
+		}
+	}
+
+	opts["metric"] = "bytes"
+	if _, err := New("anomaly_alert", opts); err == nil {
+		t.Error("expected an error loading the baseline of another metric")
+	}
+	if _, err := New("anomaly_alert", Options{"metric": "latency"}); err == nil {
+		t.Error("expected an error for an unknown metric")
+	}
+}
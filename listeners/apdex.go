@@ -0,0 +1,289 @@
+package listeners
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&ApdexAlert{})
+	_ = Reconfigurable(&ApdexAlert{})
+)
+
+func init() {
+	Register("apdex_alert", newApdexAlertFromOptions)
+}
+
+// defaultApdexSatisfied is the latency users are satisfied with by default
+const defaultApdexSatisfied = 500 * time.Millisecond
+
+// defaultApdexRules are the ApdexRules with the default thresholds for every route
+var defaultApdexRules, _ = log.NewApdexRules(log.ApdexThresholds{Satisfied: defaultApdexSatisfied, Tolerating: 4 * defaultApdexSatisfied}, nil)
+
+// apdexRulesFromOptions returns the ApdexRules from the apdex_satisfied, apdex_tolerating and
+// apdex_routes options, which the summary and the apdex_alert listeners share
+func apdexRulesFromOptions(opts Options) (*log.ApdexRules, error) {
+	satisfied, err := opts.Duration("apdex_satisfied", defaultApdexSatisfied)
+	if err != nil {
+		return nil, err
+	}
+	tolerating, err := opts.Duration("apdex_tolerating", 4*satisfied)
+	if err != nil {
+		return nil, err
+	}
+	if tolerating < satisfied {
+		return nil, fmt.Errorf("apdex_tolerating should not be less than apdex_satisfied, got %s", tolerating)
+	}
+	routes, err := opts.Strings("apdex_routes")
+	if err != nil {
+		return nil, err
+	}
+	return log.NewApdexRules(log.ApdexThresholds{Satisfied: satisfied, Tolerating: tolerating}, routes)
+}
+
+// ApdexAlert is a Listener that will output alerts when the Apdex score of the requests over
+// the window drops below the threshold. Only the requests with a latency are counted, so the
+// log format has to include it, such as log.TimedFormat.
+// It is safe to call Add and Report from multiple goroutines, including while it is started.
+type ApdexAlert struct {
+	intervals intervalChan
+
+	mu                 sync.Mutex // guards everything below
+	triggerInterval    time.Duration
+	thresholdInterval  time.Duration
+	threshold          float64
+	minRequests        int
+	rules              *log.ApdexRules
+	seconds            map[int64]*log.Apdex // by the unix time of the lines
+	isInHighAlertState bool
+}
+
+// NewApdexAlertListener returns an ApdexAlert listener with the score threshold. It counts the
+// requests over 5 minutes with the default thresholds, needs at least 10 of them to alert, and
+// checks every 10 seconds.
+func NewApdexAlertListener(threshold float64) *ApdexAlert {
+	return &ApdexAlert{
+		triggerInterval:   10 * time.Second,
+		thresholdInterval: 5 * time.Minute,
+		threshold:         threshold,
+		minRequests:       10,
+		rules:             defaultApdexRules,
+		seconds:           make(map[int64]*log.Apdex),
+		intervals:         newIntervalChan(),
+	}
+}
+
+// newApdexAlertFromOptions is the Factory for the "apdex_alert" listener. The options are:
+//
+//	threshold:        the Apdex score that the alert is triggered below, defaults to 0.85
+//	window:           the period of time the requests are counted over, defaults to "5m"
+//	interval:         how often to check the score, defaults to "10s"
+//	min_requests:     the number of requests with a latency in the window needed to alert, defaults to 10
+//	apdex_satisfied:  the latency users are satisfied with, defaults to "500ms"
+//	apdex_tolerating: the latency users tolerate, defaults to 4 times apdex_satisfied
+//	apdex_routes:     the thresholds of routes, such as "/search/*=1s/4s", see log.NewApdexRules
+func newApdexAlertFromOptions(opts Options) (Listener, error) {
+	a := NewApdexAlertListener(0.85)
+	if err := a.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The requests in the current window and whether the alert is triggered are kept, and new
+// thresholds only apply to the requests added after the change.
+func (a *ApdexAlert) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("threshold", "window", "interval", "min_requests", "apdex_satisfied", "apdex_tolerating", "apdex_routes"); err != nil {
+		return err
+	}
+	threshold, err := opts.Float("threshold", 0.85)
+	if err != nil {
+		return err
+	}
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("threshold should be an Apdex score above 0 and at most 1, got %v", threshold)
+	}
+	window, err := opts.Duration("window", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	if window < time.Second {
+		return fmt.Errorf("window should be at least 1s, got %s", window)
+	}
+	interval, err := opts.Duration("interval", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	minRequests, err := opts.Int("min_requests", 10)
+	if err != nil {
+		return err
+	}
+	if minRequests < 1 {
+		return fmt.Errorf("min_requests should be at least 1, got %d", minRequests)
+	}
+	rules, err := apdexRulesFromOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.threshold = threshold
+	a.thresholdInterval = window
+	a.minRequests = int(minRequests)
+	a.rules = rules
+	if interval != a.triggerInterval {
+		a.triggerInterval = interval
+		a.intervals.set(interval)
+	}
+	return nil
+}
+
+// Add counts the line in the second of its date, if it has a latency
+func (a *ApdexAlert) Add(line log.Line) {
+	if !line.HasLatency {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	second := line.Date.Unix()
+	apdex, ok := a.seconds[second]
+	if !ok {
+		apdex = &log.Apdex{}
+		a.seconds[second] = apdex
+	}
+	a.rules.Add(apdex, line)
+}
+
+// Report returns an ApdexTriggered or ApdexRecovered event when the Apdex score over the
+// threshold interval crosses the threshold. While there are fewer than the minimum number of
+// requests, the score isn't low. Like the Alert listener, it returns ErrInHighTrafficState or
+// ErrLowTrafficState when nothing has changed.
+func (a *ApdexAlert) Report() (Event, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now().UTC()
+	start := now.Add(-a.thresholdInterval)
+	var apdex log.Apdex
+	for second, counts := range a.seconds {
+		// Drop the seconds that have left the window, since they won't be counted again
+		if second <= start.Unix() {
+			delete(a.seconds, second)
+			continue
+		}
+		apdex.Merge(*counts)
+	}
+
+	low := apdex.Total() >= a.minRequests && apdex.Score() < a.threshold
+	traffic := ApdexTraffic{
+		Timestamp:  now,
+		Window:     Window{Start: start, End: now},
+		ApdexScore: ApdexScore{Score: apdex.Score(), Apdex: apdex},
+		Threshold:  a.threshold,
+	}
+
+	if a.isInHighAlertState {
+		if !low {
+			a.isInHighAlertState = false
+			return ApdexRecovered{traffic}, nil
+		}
+		return nil, ErrInHighTrafficState
+	}
+	if low {
+		a.isInHighAlertState = true
+		return ApdexTriggered{traffic}, nil
+	}
+	return nil, ErrLowTrafficState
+}
+
+// Start starts the ApdexAlert listener. The OutputChannel is closed when the context is cancelled
+// or the log channel is closed.
+func (a *ApdexAlert) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	go func() {
+		defer close(recv)
+		a.mu.Lock()
+		interval := a.triggerInterval
+		a.mu.Unlock()
+		runTicker(ctx, interval, a.intervals, listenChan, a.Add, func() {
+			report, err := a.Report()
+			sendAlert(ctx, recv, errs, report, err)
+		})
+	}()
+
+	return recv
+}
+
+// This ensures adherence to the AlertEvent interface
+var (
+	_ = AlertEvent(ApdexTriggered{})
+	_ = AlertEvent(ApdexRecovered{})
+)
+
+// ApdexTraffic holds the Apdex score that an Apdex alert was evaluated against
+type ApdexTraffic struct {
+	Timestamp time.Time `json:"-"`
+	Window    Window    `json:"-"`
+	ApdexScore
+	Threshold float64 `json:"threshold"`
+}
+
+// Time is part of the Event interface
+func (at ApdexTraffic) Time() time.Time {
+	return at.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (at ApdexTraffic) TimeWindow() Window {
+	return at.Window
+}
+
+// AlertName is part of the AlertEvent interface
+func (at ApdexTraffic) AlertName() string {
+	return "low_apdex"
+}
+
+// ApdexTriggered is the Event sent when the Apdex score drops below the threshold
+type ApdexTriggered struct {
+	ApdexTraffic
+}
+
+// Type is part of the Event interface
+func (at ApdexTriggered) Type() string {
+	return "apdex_alert_triggered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (at ApdexTriggered) Triggered() bool {
+	return true
+}
+
+func (at ApdexTriggered) String() string {
+	return fmt.Sprintf("Low Apdex generated an alert - score = %.2f, frustrated = %d, triggered at %s", at.Score, at.Frustrated, at.Timestamp)
+}
+
+// ApdexRecovered is the Event sent when the Apdex score is back at or above the threshold
+type ApdexRecovered struct {
+	ApdexTraffic
+}
+
+// Type is part of the Event interface
+func (ar ApdexRecovered) Type() string {
+	return "apdex_alert_recovered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (ar ApdexRecovered) Triggered() bool {
+	return false
+}
+
+func (ar ApdexRecovered) String() string {
+	return fmt.Sprintf("Low Apdex state ended: %s", ar.Timestamp)
+}
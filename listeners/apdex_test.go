@@ -0,0 +1,94 @@
+package listeners
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// addApdex adds n requests to the Apdex alert with the latency
+func addApdex(apdex *ApdexAlert, url string, n int, latency time.Duration) {
+	for i := 0; i < n; i++ {
+		apdex.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: url}, Latency: latency, HasLatency: true})
+	}
+}
+
+func TestApdexAlertReport(t *testing.T) {
+	apdex := NewApdexAlertListener(0.85)
+	addApdex(apdex, "/report", 9, 5*time.Second)
+	if _, err := apdex.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected no alert with fewer than the minimum requests, got: %v", err)
+	}
+
+	// 20 satisfied, 1 tolerating and 9 frustrated requests score 0.68
+	addApdex(apdex, "/report", 20, 100*time.Millisecond)
+	addApdex(apdex, "/report", 1, time.Second)
+	report, err := apdex.Report()
+	triggered, ok := report.(ApdexTriggered)
+	if err != nil || !ok || triggered.Apdex != (log.Apdex{Satisfied: 20, Tolerating: 1, Frustrated: 9}) {
+		t.Fatalf("expected a low Apdex alert, got: %v %v", report, err)
+	}
+	if !strings.HasPrefix(triggered.String(), "Low Apdex generated an alert - score = 0.68, frustrated = 9") {
+		t.Errorf("bad message: %s", triggered)
+	}
+	if _, err := apdex.Report(); err != ErrInHighTrafficState {
+		t.Errorf("expected to still be in the low Apdex state, got: %v", err)
+	}
+
+	// The requests that have left the window aren't counted
+	apdex.mu.Lock()
+	for second, a := range apdex.seconds {
+		apdex.seconds[second-600] = a
+		delete(apdex.seconds, second)
+	}
+	apdex.mu.Unlock()
+	addApdex(apdex, "/report", 10, 100*time.Millisecond)
+	report, err = apdex.Report()
+	if recovered, ok := report.(ApdexRecovered); err != nil || !ok || recovered.Score != 1 || recovered.AlertName() != "low_apdex" {
+		t.Errorf("expected the alert to recover, got: %v %v", report, err)
+	}
+
+	// Requests without a latency aren't scored
+	apdex.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/report"}})
+	if _, err := apdex.Report(); err != ErrLowTrafficState {
+		t.Errorf("expected no alert, got: %v", err)
+	}
+}
+
+func TestApdexAlertOptions(t *testing.T) {
+	listener, err := New("apdex_alert", Options{"threshold": 0.9, "min_requests": int64(1), "apdex_routes": []interface{}{"/search/*=2s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apdex := listener.(*ApdexAlert)
+	// Slow searches satisfy their users, but not slow reports
+	addApdex(apdex, "/search/logs", 10, time.Second)
+	if _, err := apdex.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected no alert for the searches, got: %v", err)
+	}
+	addApdex(apdex, "/report", 10, time.Second)
+	if _, err := apdex.Report(); err != nil {
+		t.Errorf("expected an alert for the reports, got: %v", err)
+	}
+
+	for _, opts := range []Options{
+		{"threshold": 1.5},
+		{"min_requests": int64(0)},
+		{"apdex_satisfied": "1s", "apdex_tolerating": "100ms"},
+		{"apdex_routes": []interface{}{"/search/*"}},
+	} {
+		if _, err := New("apdex_alert", opts); err == nil {
+			t.Errorf("expected an error for %v", opts)
+		}
+	}
+
+	// The thresholds can be copied from the summary
+	thresholds := Options{"apdex_satisfied": "200ms", "apdex_tolerating": "1s", "apdex_routes": []interface{}{"/search/*=1s"}}
+	for _, name := range []string{"summary", "apdex_alert"} {
+		if _, err := New(name, thresholds); err != nil {
+			t.Errorf("expected the %s listener to take the Apdex thresholds, got: %v", name, err)
+		}
+	}
+}
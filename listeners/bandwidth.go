@@ -0,0 +1,235 @@
+package listeners
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&Bandwidth{})
+	_ = Reconfigurable(&Bandwidth{})
+)
+
+func init() {
+	Register("bandwidth_alert", newBandwidthFromOptions)
+}
+
+// Bandwidth is a Listener that will output alerts when the bytes sent per second cross the threshold,
+// for abuse such as downloading large objects that doesn't show up in the number of requests.
+// It keeps the bytes sent in every second of the window rather than the lines.
+// It is safe to call Add and Report from multiple goroutines, including while it is started.
+type Bandwidth struct {
+	intervals intervalChan
+
+	mu                 sync.Mutex // guards everything below
+	triggerInterval    time.Duration
+	thresholdInterval  time.Duration
+	threshold          int64           // bytes per second
+	bytes              map[int64]int64 // bytes by the unix time of the lines
+	isInHighAlertState bool
+}
+
+// NewBandwidthListener returns a Bandwidth listener with the bytes per second threshold.
+// Like the Alert listener, it averages over 2 minutes and checks every 10 seconds.
+func NewBandwidthListener(bytesPerSecondThreshold int64) *Bandwidth {
+	return &Bandwidth{
+		triggerInterval:   10 * time.Second,
+		thresholdInterval: 2 * time.Minute,
+		threshold:         bytesPerSecondThreshold,
+		bytes:             make(map[int64]int64),
+		intervals:         newIntervalChan(),
+	}
+}
+
+// defaultBandwidthThreshold is the bytes per second that trigger the alert by default, 10MB/s
+const defaultBandwidthThreshold = 10000000
+
+// newBandwidthFromOptions is the Factory for the "bandwidth_alert" listener. The options are:
+//
+//	threshold: the average bytes per second that triggers the alert, defaults to 10000000
+//	window:    the period of time the bytes are averaged over, defaults to "2m"
+//	interval:  how often to check the bytes, defaults to "10s"
+func newBandwidthFromOptions(opts Options) (Listener, error) {
+	b := NewBandwidthListener(defaultBandwidthThreshold)
+	if err := b.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The bytes in the current window and whether the alert is triggered are kept.
+func (b *Bandwidth) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("threshold", "window", "interval"); err != nil {
+		return err
+	}
+	threshold, err := opts.Int("threshold", defaultBandwidthThreshold)
+	if err != nil {
+		return err
+	}
+	if threshold < 0 {
+		return fmt.Errorf("threshold should not be negative, got %d", threshold)
+	}
+	window, err := opts.Duration("window", 2*time.Minute)
+	if err != nil {
+		return err
+	}
+	if window < time.Second {
+		return fmt.Errorf("window should be at least 1s, got %s", window)
+	}
+	interval, err := opts.Duration("interval", 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	b.thresholdInterval = window
+	if interval != b.triggerInterval {
+		b.triggerInterval = interval
+		b.intervals.set(interval)
+	}
+	return nil
+}
+
+// Add counts the bytes sent for the line
+func (b *Bandwidth) Add(line log.Line) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes[line.Date.Unix()] += int64(line.Size)
+}
+
+// Report returns a BandwidthTriggered or BandwidthRecovered event when the bytes per second over
+// the threshold interval cross the threshold. Like the Alert listener, it returns
+// ErrInHighTrafficState or ErrLowTrafficState when nothing has changed.
+func (b *Bandwidth) Report() (Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().UTC()
+	start := now.Add(-b.thresholdInterval)
+	var total int64
+	for second, bytes := range b.bytes {
+		// Drop the seconds that have left the window, since they won't be counted again
+		if second <= start.Unix() {
+			delete(b.bytes, second)
+			continue
+		}
+		total += bytes
+	}
+
+	bytesPerSecond := total / int64(b.thresholdInterval.Seconds())
+	highBandwidth := bytesPerSecond > b.threshold
+	traffic := BandwidthTraffic{
+		Timestamp:      now,
+		Window:         Window{Start: start, End: now},
+		Bytes:          total,
+		BytesPerSecond: bytesPerSecond,
+		Threshold:      b.threshold,
+	}
+
+	if b.isInHighAlertState {
+		if !highBandwidth {
+			b.isInHighAlertState = false
+			return BandwidthRecovered{traffic}, nil
+		}
+		return nil, ErrInHighTrafficState
+	}
+	if highBandwidth {
+		b.isInHighAlertState = true
+		return BandwidthTriggered{traffic}, nil
+	}
+	return nil, ErrLowTrafficState
+}
+
+// Start starts the Bandwidth listener. The OutputChannel is closed when the context is cancelled
+// or the log channel is closed.
+func (b *Bandwidth) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	go func() {
+		defer close(recv)
+		b.mu.Lock()
+		interval := b.triggerInterval
+		b.mu.Unlock()
+		runTicker(ctx, interval, b.intervals, listenChan, b.Add, func() {
+			report, err := b.Report()
+			sendAlert(ctx, recv, errs, report, err)
+		})
+	}()
+
+	return recv
+}
+
+// This ensures adherence to the AlertEvent interface
+var (
+	_ = AlertEvent(BandwidthTriggered{})
+	_ = AlertEvent(BandwidthRecovered{})
+)
+
+// BandwidthTraffic holds the bytes that a bandwidth alert was evaluated against
+type BandwidthTraffic struct {
+	Timestamp      time.Time `json:"-"`
+	Window         Window    `json:"-"`
+	Bytes          int64     `json:"bytes"`
+	BytesPerSecond int64     `json:"bytes_per_second"`
+	Threshold      int64     `json:"threshold"`
+}
+
+// Time is part of the Event interface
+func (bt BandwidthTraffic) Time() time.Time {
+	return bt.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (bt BandwidthTraffic) TimeWindow() Window {
+	return bt.Window
+}
+
+// AlertName is part of the AlertEvent interface
+func (bt BandwidthTraffic) AlertName() string {
+	return "high_bandwidth"
+}
+
+// BandwidthTriggered is the Event sent when the average bytes per second exceeds the threshold
+type BandwidthTriggered struct {
+	BandwidthTraffic
+}
+
+// Type is part of the Event interface
+func (bt BandwidthTriggered) Type() string {
+	return "bandwidth_alert_triggered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (bt BandwidthTriggered) Triggered() bool {
+	return true
+}
+
+func (bt BandwidthTriggered) String() string {
+	return fmt.Sprintf("High bandwidth generated an alert - bytes per second = %d, triggered at %s", bt.BytesPerSecond, bt.Timestamp)
+}
+
+// BandwidthRecovered is the Event sent when the average bytes per second drops back below the threshold
+type BandwidthRecovered struct {
+	BandwidthTraffic
+}
+
+// Type is part of the Event interface
+func (br BandwidthRecovered) Type() string {
+	return "bandwidth_alert_recovered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (br BandwidthRecovered) Triggered() bool {
+	return false
+}
+
+func (br BandwidthRecovered) String() string {
+	return fmt.Sprintf("High bandwidth state ended: %s", br.Timestamp)
+}
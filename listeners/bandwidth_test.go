@@ -0,0 +1,53 @@
+package listeners
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+func TestBandwidthReport(t *testing.T) {
+	bandwidth := NewBandwidthListener(1000)
+	if err := bandwidth.Reconfigure(Options{"threshold": int64(1000), "window": "10s"}); err != nil {
+		t.Fatal(err)
+	}
+	// 100 requests for 100 bytes each is 1000 bytes per second over the window, which isn't over the threshold
+	for i := 0; i < 100; i++ {
+		bandwidth.Add(log.Line{Date: time.Now(), Size: 100})
+	}
+	if _, err := bandwidth.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected low bandwidth, got: %v", err)
+	}
+
+	// A single large download triggers the alert
+	bandwidth.Add(log.Line{Date: time.Now(), Size: 50000})
+	report, err := bandwidth.Report()
+	triggered, ok := report.(BandwidthTriggered)
+	if err != nil || !ok || triggered.Bytes != 60000 || triggered.BytesPerSecond != 6000 {
+		t.Fatalf("expected a high bandwidth alert, got: %v %v", report, err)
+	}
+	if !strings.HasPrefix(triggered.String(), "High bandwidth generated an alert - bytes per second = 6000") {
+		t.Errorf("bad message: %s", triggered)
+	}
+	if _, err := bandwidth.Report(); err != ErrInHighTrafficState {
+		t.Errorf("expected to still be in the high bandwidth state, got: %v", err)
+	}
+
+	// Lines older than the window aren't counted
+	bandwidth.mu.Lock()
+	bandwidth.bytes = map[int64]int64{time.Now().Add(-time.Minute).Unix(): 1000000}
+	bandwidth.mu.Unlock()
+	report, err = bandwidth.Report()
+	if recovered, ok := report.(BandwidthRecovered); err != nil || !ok || recovered.Bytes != 0 {
+		t.Errorf("expected the alert to recover, got: %v %v", report, err)
+	}
+	if len(bandwidth.bytes) != 0 {
+		t.Errorf("expected the old bytes to be dropped, got: %v", bandwidth.bytes)
+	}
+
+	if _, err := New("bandwidth_alert", Options{"threshold": int64(-1)}); err == nil {
+		t.Error("expected an error for a negative threshold")
+	}
+}
@@ -1,16 +1,122 @@
 package listeners
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/caitlin615/logmonitor/log"
 )
 
-// OutputChannel is a channel that accepts strings.
-type OutputChannel chan string
+// OutputChannel is a channel that accepts Events.
+type OutputChannel chan Event
 
 // Listener is an interface for listening to a log Channel and providing a channel
 // that it will output to
 type Listener interface {
 	// Start should start listening on the supplied log channel and return an OutputChannel
-	// that the caller will listen and typically output that to somewhere
-	Start(log.Channel) (recv OutputChannel)
+	// that the caller will listen and typically output that to somewhere.
+	// When the context is cancelled or the log channel is closed, the listener should send
+	// anything it still has to report and then close the OutputChannel, so callers should
+	// keep receiving until it is closed.
+	// Errors the listener encounters are sent into the ErrorChannel as a *log.Error with the
+	// log.StageListener stage, it can be nil to discard them.
+	Start(context.Context, log.Channel, log.ErrorChannel) (recv OutputChannel)
+}
+
+// Reconfigurable is implemented by Listeners that can take new options while they are started,
+// such as when the configuration file is reloaded, without losing what they have collected so far
+type Reconfigurable interface {
+	// Reconfigure applies the options, which are validated the same way as by the listener's Factory.
+	// If an error is returned, none of the options are applied.
+	Reconfigure(Options) error
+}
+
+// Event is a typed report sent by a Listener into its OutputChannel.
+// Callers can use a type switch to tell the reports apart and read their values,
+// or render them with the output package.
+type Event interface {
+	// Type returns a short, stable name for the kind of event, such as "summary"
+	Type() string
+	// Time returns when the event was generated
+	Time() time.Time
+	// TimeWindow returns the period of time that the event covers
+	TimeWindow() Window
+}
+
+// AlertEvent is an Event that an alert sends when it is triggered or recovers, so callers can
+// keep track of which alerts are active without knowing every kind of alert
+type AlertEvent interface {
+	Event
+	// AlertName returns the name of the kind of alert, such as "high_traffic"
+	AlertName() string
+	// Triggered returns true if the alert was triggered, or false if it recovered
+	Triggered() bool
+}
+
+// This ensures adherence to the AlertEvent interface
+var _ = AlertEvent(NamedAlert{})
+
+// NamedAlert is an AlertEvent with the name of the listener that sent it, as declared in the
+// configuration. Alerts are sent with the name of their listener so that several listeners
+// with the same kind of alert, such as alerts on different routes, can be told apart.
+type NamedAlert struct {
+	AlertEvent
+	Listener string
+}
+
+func (n NamedAlert) String() string {
+	return fmt.Sprint(n.AlertEvent)
+}
+
+// MarshalJSON encodes the AlertEvent without the name of the listener
+func (n NamedAlert) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.AlertEvent)
+}
+
+// AlertKey returns what the alert is active under: the name of its listener for a NamedAlert,
+// or else its AlertName
+func AlertKey(e AlertEvent) string {
+	if n, ok := e.(NamedAlert); ok {
+		return n.Listener
+	}
+	return e.AlertName()
+}
+
+// Window is the period of time that an Event covers
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of the window
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// ShortDuration formats the duration without zero minutes and seconds, such as "1h" or "5m"
+func ShortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// FormatBytes formats a number of bytes with a binary prefix, such as "1.5KiB"
+func FormatBytes(n float64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	prefixes := "KMGTPE"
+	i := 0
+	for n /= 1024; n >= 1024 && i < len(prefixes)-1; i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f%ciB", n, prefixes[i])
 }
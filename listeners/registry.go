@@ -0,0 +1,188 @@
+package listeners
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are the parameters for a Listener, usually from the configuration file.
+// Values are strings, int64s, float64s, bools or []interface{} of those.
+type Options map[string]interface{}
+
+// String returns the string value for the key, or def if it isn't set
+func (o Options) String(key, def string) (string, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s should be a string, got %v", key, v)
+	}
+	return s, nil
+}
+
+// Int returns the integer value for the key, or def if it isn't set
+func (o Options) Int(key string, def int64) (int64, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch i := v.(type) {
+	case int64:
+		return i, nil
+	case int:
+		return int64(i), nil
+	}
+	return 0, fmt.Errorf("%s should be an integer, got %v", key, v)
+}
+
+// Float returns the number value for the key, or def if it isn't set
+func (o Options) Float(key string, def float64) (float64, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case int64:
+		return float64(f), nil
+	case int:
+		return float64(f), nil
+	}
+	return 0, fmt.Errorf("%s should be a number, got %v", key, v)
+}
+
+// Bool returns the boolean value for the key, or def if it isn't set
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s should be true or false, got %v", key, v)
+	}
+	return b, nil
+}
+
+// Duration returns the duration for the key, or def if it isn't set.
+// The value is either a string such as "10s" or "2m", or an integer number of seconds.
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	var d time.Duration
+	switch value := v.(type) {
+	case string:
+		var err error
+		if d, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("%s should be a duration such as \"10s\", got %q", key, value)
+		}
+	case int64:
+		d = time.Duration(value) * time.Second
+	default:
+		return 0, fmt.Errorf("%s should be a duration such as \"10s\", got %v", key, v)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s should be greater than zero, got %s", key, d)
+	}
+	return d, nil
+}
+
+// Strings returns the list of strings for the key, or nil if it isn't set
+func (o Options) Strings(key string) ([]string, error) {
+	v, ok := o[key]
+	if !ok {
+		return nil, nil
+	}
+	var values []interface{}
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		values = list
+	default:
+		return nil, fmt.Errorf("%s should be a list of strings, got %v", key, v)
+	}
+	strs := make([]string, len(values))
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s should be a list of strings, got %v", key, v)
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// CheckKeys returns an error naming every key that isn't one of the known keys,
+// so typos in the configuration file aren't silently ignored
+func (o Options) CheckKeys(known ...string) error {
+	var unknown []string
+	for key := range o {
+		found := false
+		for _, k := range known {
+			if key == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown option(s) %s, expected one of %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+}
+
+// Factory returns a new Listener configured with the options
+type Factory func(Options) (Listener, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a Listener available by the name, so it can be used in the configuration file.
+// It is meant to be called from the init function of the package that implements the Listener,
+// and panics if the name is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("listeners: %q is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// New returns a new Listener of the registered type, configured with the options
+func New(name string, opts Options) (Listener, error) {
+	registryMu.Lock()
+	factory, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown listener type %q, expected one of %s", name, strings.Join(Registered(), ", "))
+	}
+	return factory(opts)
+}
+
+// Registered returns the names of all of the registered Listeners, sorted
+func Registered() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,54 @@
+package listeners
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	l, err := New("summary", Options{"interval": "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := l.(*Summary); !ok || s.Interval() != 30*time.Second {
+		t.Errorf("bad summary listener: %#v", l)
+	}
+
+	l, err = New("alert", Options{"threshold": int64(20), "window": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := l.(*Alert); !ok || a.rpsThreshold != 20 || a.thresholdInterval != time.Minute {
+		t.Errorf("bad alert listener: %#v", l)
+	}
+
+	if _, err := New("security", nil); err == nil || !strings.Contains(err.Error(), "alert, summary") {
+		t.Errorf("expected an unknown listener error listing the registered listeners, got: %v", err)
+	}
+	if _, err := New("summary", Options{"intervall": "30s"}); err == nil || !strings.Contains(err.Error(), "intervall") {
+		t.Errorf("expected an unknown option error, got: %v", err)
+	}
+	if _, err := New("alert", Options{"threshold": "ten"}); err == nil {
+		t.Error("expected an error for a threshold that isn't a number")
+	}
+}
+
+func TestOptionsDuration(t *testing.T) {
+	opts := Options{"string": "2m", "seconds": int64(5), "bad": "soon", "negative": "-1s"}
+	if d, err := opts.Duration("string", 0); err != nil || d != 2*time.Minute {
+		t.Errorf("bad duration: %v %v", d, err)
+	}
+	if d, err := opts.Duration("seconds", 0); err != nil || d != 5*time.Second {
+		t.Errorf("bad duration in seconds: %v %v", d, err)
+	}
+	if d, err := opts.Duration("missing", time.Hour); err != nil || d != time.Hour {
+		t.Errorf("expected the default duration: %v %v", d, err)
+	}
+	if _, err := opts.Duration("bad", 0); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+	if _, err := opts.Duration("negative", 0); err == nil {
+		t.Error("expected an error for a negative duration")
+	}
+}
@@ -0,0 +1,485 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&SLO{})
+	_ = Reconfigurable(&SLO{})
+)
+
+func init() {
+	Register("slo_alert", newSLOFromOptions)
+}
+
+// SLO is a Listener that keeps track of an availability objective, the share of responses that
+// aren't 5xx server errors. It reports how much of the error budget is left over the SLO period,
+// and alerts when the budget is burnt too quickly over both windows of one of its burn rate rules,
+// the multiwindow, multi-burn-rate alerts of the Site Reliability Workbook. The requests can be
+// saved to a file, so the budget isn't reset by a restart.
+// It is safe to call Add, Report and Status from multiple goroutines, including while it is started.
+type SLO struct {
+	intervals intervalChan
+
+	mu                 sync.Mutex // guards everything below
+	triggerInterval    time.Duration
+	objective          float64 // such as 0.999
+	period             time.Duration
+	rules              []burnRateRule
+	stateFile          string
+	buckets            map[int64]*sloBucket // by the unix minute of the lines
+	isInHighAlertState bool
+}
+
+// burnRateRule alerts when the burn rate is at least the threshold over both the short and long windows
+type burnRateRule struct {
+	short, long time.Duration
+	threshold   float64
+}
+
+// sloBucket is the requests and server errors of a minute
+type sloBucket struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// defaultBurnRates are the rules that the Site Reliability Workbook recommends paging for, which
+// spend 2% of a 30 day budget in an hour and 5% in 6 hours
+var defaultBurnRates = []string{"5m/1h:14.4", "30m/6h:6"}
+
+// NewSLOListener returns an SLO listener for an objective of 99.9% over 30 days, with the default
+// burn rate rules, that checks every minute
+func NewSLOListener() *SLO {
+	rules, _ := parseBurnRates(defaultBurnRates)
+	return &SLO{
+		triggerInterval: time.Minute,
+		objective:       0.999,
+		period:          30 * 24 * time.Hour,
+		rules:           rules,
+		buckets:         make(map[int64]*sloBucket),
+		intervals:       newIntervalChan(),
+	}
+}
+
+// newSLOFromOptions is the Factory for the "slo_alert" listener. The options are:
+//
+//	objective:  the percentage of responses that should not be 5xx, defaults to 99.9
+//	period:     the period the error budget is spent over, defaults to "720h" (30 days)
+//	burn_rates: rules such as "5m/1h:14.4", which alert when the error budget is burnt at least 14.4
+//	            times as fast as the period allows over both 5m and 1h, defaults to ["5m/1h:14.4", "30m/6h:6"]
+//	interval:   how often the burn rates are checked and reported, defaults to "1m"
+//	state_file: a file the requests are saved to and loaded from, so the budget is kept across restarts
+func newSLOFromOptions(opts Options) (Listener, error) {
+	s := NewSLOListener()
+	if err := s.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The requests that have been counted and whether the alert is triggered are kept, unless the
+// state file changes to one that exists, when the requests are loaded from it.
+func (s *SLO) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("objective", "period", "burn_rates", "interval", "state_file"); err != nil {
+		return err
+	}
+	objective, err := opts.Float("objective", 99.9)
+	if err != nil {
+		return err
+	}
+	if objective <= 0 || objective >= 100 {
+		return fmt.Errorf("objective should be a percentage between 0 and 100, got %v", objective)
+	}
+	period, err := opts.Duration("period", 30*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	burnRates, err := opts.Strings("burn_rates")
+	if err != nil {
+		return err
+	}
+	if burnRates == nil {
+		burnRates = defaultBurnRates
+	}
+	rules, err := parseBurnRates(burnRates)
+	if err != nil {
+		return err
+	}
+	interval, err := opts.Duration("interval", time.Minute)
+	if err != nil {
+		return err
+	}
+	stateFile, err := opts.String("state_file", "")
+	if err != nil {
+		return err
+	}
+	var buckets map[int64]*sloBucket
+	if stateFile != "" {
+		if buckets, err = loadSLOState(stateFile); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if buckets != nil && stateFile != s.stateFile {
+		s.buckets = buckets
+	}
+	s.objective = objective / 100
+	s.period = period
+	s.rules = rules
+	s.stateFile = stateFile
+	if interval != s.triggerInterval {
+		s.triggerInterval = interval
+		s.intervals.set(interval)
+	}
+	return nil
+}
+
+// parseBurnRates parses rules such as "5m/1h:14.4", the short and long windows and the threshold
+func parseBurnRates(specs []string) ([]burnRateRule, error) {
+	rules := make([]burnRateRule, 0, len(specs))
+	for _, spec := range specs {
+		invalid := fmt.Errorf("burn rate %q should be the short and long windows and the threshold, such as \"5m/1h:14.4\"", spec)
+		colon := strings.LastIndex(spec, ":")
+		slash := strings.Index(spec, "/")
+		if colon < 0 || slash < 0 || slash > colon {
+			return nil, invalid
+		}
+		short, err := time.ParseDuration(spec[:slash])
+		if err != nil {
+			return nil, invalid
+		}
+		long, err := time.ParseDuration(spec[slash+1 : colon])
+		if err != nil {
+			return nil, invalid
+		}
+		threshold, err := strconv.ParseFloat(spec[colon+1:], 64)
+		if err != nil || threshold <= 0 {
+			return nil, invalid
+		}
+		if short < time.Minute || long < short {
+			return nil, fmt.Errorf("burn rate %q should have a short window of at least 1m that isn't longer than the long window", spec)
+		}
+		rules = append(rules, burnRateRule{short: short, long: long, threshold: threshold})
+	}
+	return rules, nil
+}
+
+// Add counts the line, and whether it is a server error, in the minute of its date
+func (s *SLO) Add(line log.Line) {
+	// Lines without a date would be dropped at once, and lines dated later than now, such as from
+	// a skewed clock, would never leave the period, so both are counted in the current minute
+	date := line.Date
+	if now := time.Now(); date.IsZero() || date.After(now) {
+		date = now
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	minute := date.Unix() / 60
+	b, ok := s.buckets[minute]
+	if !ok {
+		b = &sloBucket{}
+		s.buckets[minute] = b
+	}
+	b.Requests++
+	if line.IsServerError() {
+		b.Errors++
+	}
+}
+
+// Status returns the error budget that is left over the SLO period, and the burn rates of the rules
+func (s *SLO) Status() SLOReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status(time.Now().UTC())
+}
+
+// status returns the SLOReport at now, dropping the minutes that are older than the period and
+// every window. It is called with s.mu held.
+func (s *SLO) status(now time.Time) SLOReport {
+	keep := s.period
+	for _, rule := range s.rules {
+		if rule.long > keep {
+			keep = rule.long
+		}
+	}
+	// sum returns the requests and errors in the minutes that are at most d old, the windows
+	// are as precise as a minute
+	sum := func(d time.Duration) (requests, errors int64) {
+		start := now.Add(-d).Unix() / 60
+		for minute, b := range s.buckets {
+			if minute >= start {
+				requests += b.Requests
+				errors += b.Errors
+			}
+		}
+		return
+	}
+	// The window of the budget starts at the oldest minute that has been counted, when the
+	// requests haven't been counted for the whole period
+	start := now.Add(-s.period)
+	counted := now
+	oldest := now.Add(-keep).Unix() / 60
+	for minute := range s.buckets {
+		if minute < oldest {
+			delete(s.buckets, minute)
+			continue
+		}
+		if t := time.Unix(minute*60, 0).UTC(); t.Before(counted) {
+			counted = t
+		}
+	}
+	if counted.After(start) {
+		start = counted
+	}
+
+	budget := 1 - s.objective
+	burnRate := func(d time.Duration) float64 {
+		requests, errors := sum(d)
+		if requests == 0 {
+			return 0
+		}
+		return float64(errors) / float64(requests) / budget
+	}
+
+	report := SLOReport{
+		Timestamp:       now,
+		Window:          Window{Start: start, End: now},
+		Objective:       s.objective * 100,
+		Period:          ShortDuration(s.period),
+		BudgetRemaining: 1,
+		Rules:           make([]BurnRateRule, len(s.rules)),
+	}
+	report.Requests, report.Errors = sum(s.period)
+	if report.Requests > 0 {
+		report.BudgetRemaining = 1 - float64(report.Errors)/(budget*float64(report.Requests))
+	}
+	for i, rule := range s.rules {
+		r := BurnRateRule{
+			Short:     ShortDuration(rule.short),
+			Long:      ShortDuration(rule.long),
+			ShortRate: burnRate(rule.short),
+			LongRate:  burnRate(rule.long),
+			Threshold: rule.threshold,
+		}
+		r.Firing = r.ShortRate >= r.Threshold && r.LongRate >= r.Threshold
+		report.Rules[i] = r
+	}
+	return report
+}
+
+// Report returns an SLOTriggered event when one of the burn rate rules starts firing, and an
+// SLORecovered event when none of them are firing anymore. Like the Alert listener, it returns
+// ErrInHighTrafficState or ErrLowTrafficState when nothing has changed.
+func (s *SLO) Report() (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status(time.Now().UTC())
+	firing := false
+	for _, rule := range status.Rules {
+		firing = firing || rule.Firing
+	}
+	if s.isInHighAlertState {
+		if !firing {
+			s.isInHighAlertState = false
+			return SLORecovered{status}, nil
+		}
+		return nil, ErrInHighTrafficState
+	}
+	if firing {
+		s.isInHighAlertState = true
+		return SLOTriggered{status}, nil
+	}
+	return nil, ErrLowTrafficState
+}
+
+// loadSLOState reads the requests by minute from the state file, or returns nil if it doesn't exist yet
+func loadSLOState(path string) (map[int64]*sloBucket, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state sloState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("state_file %s: %v", path, err)
+	}
+	if state.Buckets == nil {
+		state.Buckets = make(map[int64]*sloBucket)
+	}
+	return state.Buckets, nil
+}
+
+// sloState is the requests of an SLO listener as they are saved to its state file
+type sloState struct {
+	Buckets map[int64]*sloBucket `json:"minutes"` // by unix minute
+	Updated time.Time            `json:"updated"`
+}
+
+// save writes the requests to the state file, if there is one. The file is replaced at once,
+// so a crash doesn't leave half of it.
+func (s *SLO) save() error {
+	s.mu.Lock()
+	path := s.stateFile
+	var b []byte
+	var err error
+	if path != "" {
+		b, err = json.Marshal(sloState{Buckets: s.buckets, Updated: time.Now().UTC()})
+	}
+	s.mu.Unlock()
+
+	if path == "" || err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Start starts the SLO listener, which sends an SLOReport every interval after any alert, and saves
+// the requests after every check. The OutputChannel is closed when the context is cancelled or the
+// log channel is closed.
+func (s *SLO) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	go func() {
+		defer close(recv)
+		s.mu.Lock()
+		interval := s.triggerInterval
+		s.mu.Unlock()
+		runTicker(ctx, interval, s.intervals, listenChan, s.Add, func() {
+			report, err := s.Report()
+			sendAlert(ctx, recv, errs, report, err)
+			recv <- s.Status()
+			if err := s.save(); err != nil {
+				errs.Send(ctx, &log.Error{Stage: log.StageListener, Reason: log.ReasonWrite, Err: err})
+			}
+		})
+	}()
+
+	return recv
+}
+
+// This ensures adherence to the Event and AlertEvent interfaces
+var (
+	_ = Event(SLOReport{})
+	_ = AlertEvent(SLOTriggered{})
+	_ = AlertEvent(SLORecovered{})
+)
+
+// SLOReport is the error budget that is left over the SLO period, and the burn rates of the rules.
+// Its Window starts at the oldest request that has been counted, when that is within the period.
+type SLOReport struct {
+	Timestamp time.Time `json:"-"`
+	Window    Window    `json:"-"`
+	// Objective is the percentage of responses that should not be 5xx, such as 99.9
+	Objective float64 `json:"objective"`
+	Period    string  `json:"period"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	// BudgetRemaining is the share of the error budget of the period that is left, which is
+	// negative once more errors than the objective allows have been served
+	BudgetRemaining float64        `json:"budget_remaining"`
+	Rules           []BurnRateRule `json:"rules"`
+}
+
+// BurnRateRule is how many times faster than the period allows the error budget was spent over
+// the short and long windows of a rule, which is firing when both are at least the threshold
+type BurnRateRule struct {
+	Short     string  `json:"short"`
+	Long      string  `json:"long"`
+	ShortRate float64 `json:"short_rate"`
+	LongRate  float64 `json:"long_rate"`
+	Threshold float64 `json:"threshold"`
+	Firing    bool    `json:"firing"`
+}
+
+// Type is part of the Event interface
+func (sr SLOReport) Type() string {
+	return "slo"
+}
+
+// Time is part of the Event interface
+func (sr SLOReport) Time() time.Time {
+	return sr.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (sr SLOReport) TimeWindow() Window {
+	return sr.Window
+}
+
+// AlertName is part of the AlertEvent interface
+func (sr SLOReport) AlertName() string {
+	return "slo_burn_rate"
+}
+
+func (sr SLOReport) String() string {
+	return fmt.Sprintf("SLO %v%% over %s: %.1f%% of the error budget remaining over the last %s, burn rates %s",
+		sr.Objective, sr.Period, sr.BudgetRemaining*100, ShortDuration(sr.Window.Duration().Truncate(time.Minute)), sr.burnRates())
+}
+
+// burnRates formats the burn rates of the rules, such as "5m/1h = 20.00/15.00 (alert at 14.4)"
+func (sr SLOReport) burnRates() string {
+	rates := make([]string, len(sr.Rules))
+	for i, r := range sr.Rules {
+		rates[i] = fmt.Sprintf("%s/%s = %.2f/%.2f (alert at %v)", r.Short, r.Long, r.ShortRate, r.LongRate, r.Threshold)
+	}
+	return strings.Join(rates, ", ")
+}
+
+// SLOTriggered is the Event sent when one of the burn rate rules starts firing
+type SLOTriggered struct {
+	SLOReport
+}
+
+// Type is part of the Event interface
+func (st SLOTriggered) Type() string {
+	return "slo_alert_triggered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (st SLOTriggered) Triggered() bool {
+	return true
+}
+
+func (st SLOTriggered) String() string {
+	return fmt.Sprintf("SLO burn rate generated an alert - %s, %.1f%% of the error budget remaining, triggered at %s",
+		st.burnRates(), st.BudgetRemaining*100, st.Timestamp)
+}
+
+// SLORecovered is the Event sent when none of the burn rate rules are firing anymore
+type SLORecovered struct {
+	SLOReport
+}
+
+// Type is part of the Event interface
+func (sr SLORecovered) Type() string {
+	return "slo_alert_recovered"
+}
+
+// Triggered is part of the AlertEvent interface
+func (sr SLORecovered) Triggered() bool {
+	return false
+}
+
+func (sr SLORecovered) String() string {
+	return fmt.Sprintf("SLO burn rate state ended: %s", sr.Timestamp)
+}
@@ -0,0 +1,148 @@
+package listeners
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// addSLO adds n requests dated ago, of which errors are 503s
+func addSLO(slo *SLO, ago time.Duration, n, errors int) {
+	for i := 0; i < n; i++ {
+		code := 200
+		if i < errors {
+			code = 503
+		}
+		slo.Add(log.Line{Date: time.Now().Add(-ago), StatusCode: code})
+	}
+}
+
+func TestSLOReport(t *testing.T) {
+	slo := NewSLOListener()
+	addSLO(slo, 3*time.Hour, 10000, 0)
+	if _, err := slo.Report(); err != ErrLowTrafficState {
+		t.Fatalf("expected no alert without errors, got: %v", err)
+	}
+
+	// 5% errors in the last few minutes burn the budget 50 times too fast
+	addSLO(slo, 2*time.Minute, 1000, 50)
+	report, err := slo.Report()
+	triggered, ok := report.(SLOTriggered)
+	if err != nil || !ok {
+		t.Fatalf("expected an SLO alert, got: %v %v", report, err)
+	}
+	if r := triggered.Rules[0]; !r.Firing || math.Abs(r.ShortRate-50) > 0.01 || math.Abs(r.LongRate-50) > 0.01 {
+		t.Errorf("bad burn rates: %+v", r)
+	}
+	// 50 errors out of the 11 that 11000 requests allow
+	if math.Abs(triggered.BudgetRemaining-(1-50.0/11)) > 1e-6 || triggered.Requests != 11000 {
+		t.Errorf("bad error budget: %v of %d requests", triggered.BudgetRemaining, triggered.Requests)
+	}
+	if !strings.HasPrefix(triggered.String(), "SLO burn rate generated an alert - 5m/1h = 50.00/50.00 (alert at 14.4)") {
+		t.Errorf("bad message: %s", triggered)
+	}
+
+	// The errors have left the short window of the first rule, and the second rule only burns
+	// 4.5 times too fast over 6h
+	slo.mu.Lock()
+	for minute, b := range slo.buckets {
+		slo.buckets[minute-10] = b
+		delete(slo.buckets, minute)
+	}
+	slo.mu.Unlock()
+	report, err = slo.Report()
+	if _, ok := report.(SLORecovered); err != nil || !ok {
+		t.Errorf("expected the alert to recover, got: %v %v", report, err)
+	}
+	status := slo.Status()
+	if status.Rules[0].Firing || status.Rules[0].ShortRate != 0 || status.Rules[1].Firing || math.Abs(status.Rules[1].LongRate-50.0/11) > 0.01 {
+		t.Errorf("bad burn rates: %+v", status.Rules)
+	}
+}
+
+func TestSLOAddDates(t *testing.T) {
+	slo := NewSLOListener()
+	slo.Add(log.Line{StatusCode: 503})
+	slo.Add(log.Line{Date: time.Now().Add(365 * 24 * time.Hour), StatusCode: 503})
+
+	// Both are counted now rather than dropped or kept forever
+	status := slo.Status()
+	if status.Requests != 2 || status.Errors != 2 {
+		t.Errorf("expected the lines to be counted, got %d requests and %d errors", status.Requests, status.Errors)
+	}
+	now := time.Now().Unix() / 60
+	slo.mu.Lock()
+	for minute := range slo.buckets {
+		if minute < now-1 || minute > now {
+			t.Errorf("expected the lines in the current minute, got: %s", time.Unix(minute*60, 0))
+		}
+	}
+	slo.mu.Unlock()
+}
+
+func TestSLOOptions(t *testing.T) {
+	slo, err := New("slo_alert", Options{"objective": 99.0, "period": "24h", "burn_rates": []interface{}{"1m/10m:2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSLO(slo.(*SLO), 30*time.Hour, 100, 100)
+	addSLO(slo.(*SLO), 5*time.Minute, 100, 3)
+	status := slo.(*SLO).Status()
+	// The errors before the period aren't counted
+	if status.Requests != 100 || math.Abs(status.BudgetRemaining+2) > 1e-9 || math.Abs(status.Rules[0].LongRate-3) > 1e-9 {
+		t.Errorf("bad status: %+v", status)
+	}
+
+	for _, rates := range []string{"5m:14", "1h/5m:14", "5m/1h:x", "30s/1h:2"} {
+		if _, err := New("slo_alert", Options{"burn_rates": []interface{}{rates}}); err == nil {
+			t.Errorf("expected an error for burn rate %q", rates)
+		}
+	}
+	if _, err := New("slo_alert", Options{"objective": 100.0}); err == nil {
+		t.Error("expected an error for an objective of 100%")
+	}
+}
+
+func TestSLOStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{"state_file": filepath.Join(dir, "slo.json")}
+
+	slo, err := New("slo_alert", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSLO(slo.(*SLO), 2*time.Hour, 1000, 1)
+	// Only the minutes that have been counted are in the window of the budget
+	if w := slo.(*SLO).Status().Window; w.Duration() < 2*time.Hour || w.Duration() > 2*time.Hour+time.Minute {
+		t.Errorf("expected the window to start at the oldest request, got: %s", w.Duration())
+	}
+	if err := slo.(*SLO).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requests are loaded after a restart, so the budget isn't reset
+	restarted, err := New("slo_alert", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := restarted.(*SLO).Status(); status.Requests != 1000 || status.Errors != 1 {
+		t.Errorf("expected the requests to be loaded, got: %+v", status)
+	}
+
+	if err := ioutil.WriteFile(opts["state_file"].(string), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("slo_alert", opts); err == nil {
+		t.Error("expected an error loading a broken state file")
+	}
+}
@@ -0,0 +1,131 @@
+package listeners
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// These tests hammer the listeners from multiple goroutines and are meant to be run
+// with the race detector: go test -race ./...
+
+// stressLine returns a Line for the current time without using math/rand,
+// so the stress tests don't change the random lines of the other tests
+func stressLine(i int) log.Line {
+	return log.Line{
+		IPAddress:  "10.0.0." + strconv.Itoa(i%256),
+		UserID:     "user" + strconv.Itoa(i%7),
+		Date:       time.Now().UTC(),
+		Request:    log.LineRequest{Method: "GET", URL: "/section" + strconv.Itoa(i%5) + "/page", Protocol: "HTTP/1.0"},
+		StatusCode: 200 + i%4*100,
+		Size:       i,
+	}
+}
+
+func TestSummaryConcurrentAddAndReport(t *testing.T) {
+	summary := NewSummaryListener()
+	const writers, linesPerWriter = 8, 500
+
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < linesPerWriter; j++ {
+				summary.Add(stressLine(j))
+			}
+		}()
+	}
+
+	// Report while the lines are being added, every line should be in exactly one report
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	total := 0
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if report, err := summary.Report(); err == nil {
+			total += report.Hits
+		}
+	}
+	if total != writers*linesPerWriter {
+		t.Errorf("expected %d hits across all reports, got: %d", writers*linesPerWriter, total)
+	}
+}
+
+func TestSummaryStartStress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	listenChan := make(log.Channel)
+	summary := NewSummaryListener()
+	summary.triggerInterval = time.Millisecond
+	recv := summary.Start(ctx, listenChan, nil)
+
+	const lines = 5000
+	go func() {
+		for i := 0; i < lines; i++ {
+			listenChan <- stressLine(i)
+			if i%500 == 0 {
+				summary.SetInterval(time.Duration(i%3+1) * time.Millisecond)
+			}
+		}
+		cancel()
+	}()
+
+	total := 0
+	for e := range recv {
+		total += e.(SummaryReport).Hits
+	}
+	if total != lines {
+		t.Errorf("expected %d hits across all reports, got: %d", lines, total)
+	}
+}
+
+func TestAlertConcurrentAddAndReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	listenChan := make(log.Channel)
+	alert := NewAlertListener(1)
+	alert.triggerInterval = time.Millisecond
+	alert.thresholdInterval = time.Second
+	recv := alert.Start(ctx, listenChan, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			listenChan <- stressLine(i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			alert.Add(stressLine(i))
+		}
+	}()
+	go func() {
+		wg.Wait()
+		// Give the listener a chance to check the traffic before stopping it
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	triggered := false
+	for e := range recv {
+		if _, ok := e.(AlertTriggered); ok {
+			triggered = true
+		}
+	}
+	if !triggered {
+		t.Error("expected the high traffic alert to be triggered")
+	}
+}
@@ -1,86 +1,585 @@
 package listeners
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/caitlin615/logmonitor/counter"
 	"github.com/caitlin615/logmonitor/log"
 )
 
-// This ensures adherence to the Listener interface
-var _ = Listener(Summary{})
+// This ensures adherence to the Listener and Reconfigurable interfaces
+var (
+	_ = Listener(&Summary{})
+	_ = Reconfigurable(&Summary{})
+)
+
+func init() {
+	Register("summary", newSummaryFromOptions)
+}
+
+// ErrNoRequests is the error returned when there are no requests to summarize
+var ErrNoRequests = errors.New("no requests available to summarize")
 
-// Summary is a Listener that will output summary reports
+// Summary is a Listener that will output summary reports.
+// It keeps counts rather than lines, and the top lists are counted in bounded memory with
+// counter.SpaceSaving, so a report's counts are exact unless there are more distinct keys
+// than the capacity. The distinct IP addresses, users and URLs are estimated with
+// counter.HyperLogLog, for every report and over rolling windows of the last reports, and the
+// percentiles of the response sizes with counter.Histogram.
+// It is safe to call Add and Report from multiple goroutines, including while it is started.
 type Summary struct {
+	intervals intervalChan
+
+	mu              sync.Mutex // guards everything below
 	triggerInterval time.Duration
-	logs            log.Lines
+	router          *log.Router
+	apdex           *log.ApdexRules
+	sectionDepth    int
+	treeDepth       int
+	capacity        int
+	precision       int
+	uniqueWindows   []time.Duration
+	counts          *summaryCounts
+	since           time.Time // start of the current reporting window
+	// history has the distinct keys of the reports that are in the longest of the uniqueWindows
+	history []uniqueInterval
+	// baseline is the decayed hits of every section before the current report, observed is the
+	// decayed number of seconds they were counted over, and baselineSince is when they were started
+	baseline      *counter.Decayed
+	observed      *counter.Decayed
+	baselineSince time.Time
+}
+
+// uniqueInterval is the distinct keys of the reports that ended between start and end
+type uniqueInterval struct {
+	start, end time.Time
+	unique     uniqueSketches
+}
+
+// NewSummaryListener returns an Summary listener that will report every 10 seconds.
+// The interval can be changed with the "interval" option in the configuration file.
+func NewSummaryListener() *Summary {
+	return &Summary{
+		triggerInterval: 10 * time.Second,
+		router:          log.DefaultRouter,
+		apdex:           defaultApdexRules,
+		sectionDepth:    1,
+		treeDepth:       3,
+		capacity:        defaultCapacity,
+		precision:       defaultPrecision,
+		uniqueWindows:   defaultUniqueWindows,
+		counts:          newSummaryCounts(defaultCapacity, defaultPrecision),
+		since:           time.Now().UTC(),
+		baseline:        counter.NewDecayed(defaultHalfLife),
+		observed:        counter.NewDecayed(defaultHalfLife),
+		baselineSince:   time.Now().UTC(),
+		intervals:       newIntervalChan(),
+	}
+}
+
+// newSummaryFromOptions is the Factory for the "summary" listener. The options are:
+//
+//	interval:           how often to report, defaults to "10s"
+//	routes:             patterns such as "/api/v1/orders/:id" that the top routes are counted by
+//	collapse_ids:       whether numeric, UUID and hash segments of other paths are collapsed, defaults to true
+//	section_depth:      the number of segments of the path in a section, defaults to 1
+//	tree_depth:         the number of levels of the section tree, defaults to 3
+//	capacity:           the number of keys each top list keeps track of, defaults to 1000
+//	unique_precision:   the precision of the estimates of distinct keys, from 4 to 18, defaults to 12
+//	unique_windows:     the rolling windows distinct keys are estimated over, defaults to ["5m", "1h"]
+//	trending_half_life: the half-life of the baselines the trending sections are compared with, defaults to "5m"
+//	apdex_satisfied:    the latency users are satisfied with for the Apdex score, defaults to "500ms"
+//	apdex_tolerating:   the latency users tolerate, defaults to 4 times apdex_satisfied
+//	apdex_routes:       the thresholds of routes, such as "/search/*=1s/4s", see log.NewApdexRules
+func newSummaryFromOptions(opts Options) (Listener, error) {
+	s := NewSummaryListener()
+	if err := s.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-// NewSummaryListener returns an Summary listener that will report every 10 seconds
-// TODO: Defaults to a 10 seconds trigger interval, should this be configurable?
-func NewSummaryListener() Summary {
-	return Summary{triggerInterval: 10 * time.Second}
+// Reconfigure is part of the Reconfigurable interface, options that aren't set go back to their defaults.
+// The lines collected for the current report are kept.
+func (s *Summary) Reconfigure(opts Options) error {
+	if err := opts.CheckKeys("interval", "routes", "collapse_ids", "section_depth", "tree_depth", "capacity", "unique_precision", "unique_windows", "trending_half_life", "apdex_satisfied", "apdex_tolerating", "apdex_routes"); err != nil {
+		return err
+	}
+	interval, err := opts.Duration("interval", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	routes, err := opts.Strings("routes")
+	if err != nil {
+		return err
+	}
+	collapse, err := opts.Bool("collapse_ids", true)
+	if err != nil {
+		return err
+	}
+	router, err := log.NewRouter(routes, collapse)
+	if err != nil {
+		return err
+	}
+	sectionDepth, err := opts.Int("section_depth", 1)
+	if err != nil {
+		return err
+	}
+	if sectionDepth < 1 {
+		return fmt.Errorf("section_depth should be at least 1, got %d", sectionDepth)
+	}
+	treeDepth, err := opts.Int("tree_depth", 3)
+	if err != nil {
+		return err
+	}
+	if treeDepth < 1 {
+		return fmt.Errorf("tree_depth should be at least 1, got %d", treeDepth)
+	}
+	capacity, err := opts.Int("capacity", defaultCapacity)
+	if err != nil {
+		return err
+	}
+	if capacity < topN {
+		return fmt.Errorf("capacity should be at least %d, got %d", topN, capacity)
+	}
+	precision, err := opts.Int("unique_precision", defaultPrecision)
+	if err != nil {
+		return err
+	}
+	if precision < counter.MinPrecision || precision > counter.MaxPrecision {
+		return fmt.Errorf("unique_precision should be between %d and %d, got %d", counter.MinPrecision, counter.MaxPrecision, precision)
+	}
+	uniqueWindows := defaultUniqueWindows
+	if windows, err := opts.Strings("unique_windows"); err != nil {
+		return err
+	} else if windows != nil {
+		uniqueWindows = make([]time.Duration, len(windows))
+		for i, window := range windows {
+			d, err := time.ParseDuration(window)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("unique_windows should be durations such as \"5m\", got %q", window)
+			}
+			uniqueWindows[i] = d
+		}
+	}
+	halfLife, err := opts.Duration("trending_half_life", defaultHalfLife)
+	if err != nil {
+		return err
+	}
+	apdex, err := apdexRulesFromOptions(opts)
+	if err != nil {
+		return err
+	}
+	s.SetRouter(router)
+	s.SetApdex(apdex)
+	s.SetHalfLife(halfLife)
+	s.SetSectionDepth(int(sectionDepth), int(treeDepth))
+	s.SetCapacity(int(capacity))
+	s.SetUnique(int(precision), uniqueWindows)
+	if interval != s.Interval() {
+		s.SetInterval(interval)
+	}
+	return nil
+}
+
+// Interval returns how often the Summary listener sends reports when it is started
+func (s *Summary) Interval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.triggerInterval
+}
+
+// SetInterval changes how often a started Summary listener sends reports.
+// The next report is sent after the new interval. If the listener hasn't picked up
+// a previous change yet, that change is replaced.
+func (s *Summary) SetInterval(d time.Duration) {
+	s.mu.Lock()
+	s.triggerInterval = d
+	s.mu.Unlock()
+	s.intervals.set(d)
+}
+
+// SetRouter changes the Router that the top routes of the reports are counted by
+func (s *Summary) SetRouter(router *log.Router) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router = router
+}
+
+// SetApdex changes the thresholds that the Apdex scores of the reports are counted with
+func (s *Summary) SetApdex(rules *log.ApdexRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apdex = rules
+}
+
+// SetSectionDepth changes the number of segments of the path in the sections of the reports,
+// and the number of levels of their section tree
+func (s *Summary) SetSectionDepth(sectionDepth, treeDepth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sectionDepth = sectionDepth
+	s.treeDepth = treeDepth
+}
+
+// SetCapacity changes the number of keys each top list keeps track of, from the next report
+func (s *Summary) SetCapacity(capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = capacity
+	if s.counts.hits == 0 {
+		s.counts = newSummaryCounts(capacity, s.precision)
+	}
+}
+
+// SetUnique changes the precision of the estimates of distinct keys from the next report, and
+// the rolling windows they are estimated over. Changing the precision starts the windows over.
+func (s *Summary) SetUnique(precision int, windows []time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uniqueWindows = windows
+	s.precision = precision
+	if s.counts.hits == 0 {
+		s.counts = newSummaryCounts(s.capacity, precision)
+	}
+}
+
+// SetHalfLife changes how long it takes for the hits of a section to count half as much in its
+// baseline for the trending sections. Changing it starts the baselines over.
+func (s *Summary) SetHalfLife(halfLife time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if halfLife != s.baseline.HalfLife() {
+		s.baseline = counter.NewDecayed(halfLife)
+		s.observed = counter.NewDecayed(halfLife)
+		s.baselineSince = time.Now().UTC()
+	}
 }
 
-// Add appends the line to the log storage
+// topN is the number of entries in each of the top lists of the SummaryReport
+const topN = 5
+
+// defaultCapacity is the number of keys each top list keeps track of by default
+const defaultCapacity = 1000
+
+// defaultPrecision is the precision of the estimates of distinct keys by default,
+// which takes 4KB for each of them and has a standard error of 1.6%
+const defaultPrecision = 12
+
+// defaultUniqueWindows are the rolling windows distinct keys are estimated over by default
+var defaultUniqueWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// defaultHalfLife is the half-life of the baselines of the trending sections by default
+const defaultHalfLife = 5 * time.Minute
+
+// minGrowth is how many times a section's rate needs to be over its baseline to be trending
+const minGrowth = 1.5
+
+// Add counts the line for the next report
 func (s *Summary) Add(line log.Line) {
-	s.logs = append(s.logs, line)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts.add(line, s.since, s.sectionDepth, s.treeDepth, s.router, s.apdex)
 }
 
-// Report returns the summary report during based on what's currently in the logs
+// Report returns the summary report on the lines added since the last report,
+// and starts counting the next one
 func (s *Summary) Report() (report SummaryReport, err error) {
-	section, hits := s.logs.SectionWithMostHits()
-	if len(s.logs) == 0 {
-		err = fmt.Errorf("no requests available to summarize")
+	now := time.Now().UTC()
+
+	// Swap the counts out so the report can be built without holding the lock
+	s.mu.Lock()
+	counts := s.counts
+	window := Window{Start: s.since, End: now}
+	s.counts = newSummaryCounts(s.capacity, s.precision)
+	s.since = now
+	history, windows := s.addHistory(now, counts)
+	trending := s.trending(now, window.Duration(), counts.sections)
+	s.mu.Unlock()
+
+	if counts.hits == 0 {
+		err = ErrNoRequests
 		return
 	}
 
-	report.Section = SummaryReportItem{section, hits}
-	mau, mauCount := s.logs.MostActiveUser()
-	report.MostActiveUser = SummaryReportItem{mau, mauCount}
-	report.Error4XX = SummaryReportItem{"Requests with error code 4XX", s.logs.ErrorCode4XX()}
-	report.Error5XX = SummaryReportItem{"Requests with error code 5XX", s.logs.ErrorCode5XX()}
-	defer s.logs.Clear()
+	report.Timestamp = now
+	report.Window = window
+	report.Hits = counts.hits
+	report.Section = SummaryReportItem{"", -1}
+	if top := counts.sections.TopN(1); len(top) > 0 {
+		report.Section = SummaryReportItem{top[0].Key, top[0].Value}
+	}
+	if top := counts.users.TopN(1); len(top) > 0 {
+		report.MostActiveUser = SummaryReportItem{top[0].Key, top[0].Value}
+	}
+	report.Error4XX = SummaryReportItem{"Requests with error code 4XX", counts.error4XX}
+	report.Error5XX = SummaryReportItem{"Requests with error code 5XX", counts.error5XX}
+	report.TopSections = newSummaryReportItems(counts.sections.TopN(topN))
+	report.SectionTree = counts.sectionTree()
+	report.Trending = trending
+	report.TopRoutes = newSummaryReportItems(counts.routes.TopN(topN))
+	report.TopUsers = newSummaryReportItems(counts.users.TopN(topN))
+	report.TopIPAddresses = newSummaryReportItems(counts.ipAddresses.TopN(topN))
+	if sources := counts.sources.TopN(topN); len(sources) > 1 {
+		report.TopSources = newSummaryReportItems(sources)
+	}
+	report.StatusClasses = make([]SummaryReportItem, 0, len(counts.statusClasses))
+	for class, hits := range counts.statusClasses {
+		report.StatusClasses = append(report.StatusClasses, SummaryReportItem{class, hits})
+	}
+	sort.Slice(report.StatusClasses, func(i, j int) bool {
+		return report.StatusClasses[i].Key < report.StatusClasses[j].Key
+	})
+	report.StatusCodes = newSummaryReportItems(counts.statusCodes.TopN(0))
+	sort.Slice(report.StatusCodes, func(i, j int) bool {
+		return report.StatusCodes[i].Key < report.StatusCodes[j].Key
+	})
+	report.Methods = newSummaryReportItems(counts.methods.TopN(0))
+	if counts.apdex.Total() > 0 {
+		report.Apdex = &ApdexScore{Score: counts.apdex.Score(), Apdex: counts.apdex}
+	}
+	for _, section := range report.TopSections {
+		if a, ok := counts.sectionApdex[section.Key]; ok {
+			report.ApdexBySection = append(report.ApdexBySection, SectionApdex{section.Key, ApdexScore{a.Score(), *a}})
+		}
+	}
+	report.TopSectionsByStatusClass = make(map[string][]SummaryReportItem, len(counts.errorSections))
+	for class, sections := range counts.errorSections {
+		report.TopSectionsByStatusClass[class] = newSummaryReportItems(sections.TopN(topN))
+	}
+	report.HitsPerSecond = counts.hitsPerSecond(window.Start, window.End)
+	report.Bytes = counts.bytes
+	if seconds := window.Duration().Seconds(); seconds > 0 {
+		report.BytesPerSecond = float64(counts.bytes) / seconds
+	}
+	report.ResponseSize = ResponseSize{
+		Average: counts.sizes.Mean(),
+		P50:     counts.sizes.Quantile(0.5),
+		P90:     counts.sizes.Quantile(0.9),
+		P99:     counts.sizes.Quantile(0.99),
+		Max:     counts.sizes.Max(),
+	}
+	report.TopSectionsByBytes = newSummaryReportItems(counts.sectionsByBytes.TopN(topN))
+	report.TopIPAddressesByBytes = newSummaryReportItems(counts.ipAddressesByBytes.TopN(topN))
+	report.Unique = counts.unique.counts()
+	report.UniqueOver = make([]UniqueWindow, len(windows))
+	for i, d := range windows {
+		// The intervals in the history aren't changed once they are added, so they can be
+		// merged without holding the lock
+		merged := newUniqueSketches(counts.unique.ipAddresses.Precision())
+		for _, interval := range history {
+			if interval.end.After(now.Add(-d)) {
+				merged.merge(interval.unique)
+			}
+		}
+		report.UniqueOver[i] = UniqueWindow{Duration: d, Window: d.String(), UniqueCounts: merged.counts()}
+	}
 	return
 }
 
-// Start starts the Summary listener
-func (s Summary) Start(listenChan log.Channel) OutputChannel {
-	recv := make(OutputChannel)
-	// start a goroutine that will listen for log entries
-	go func() {
-		for {
-			select {
-			case in := <-listenChan:
-				// TODO: Use mutexes since this will be accessed across multiple goroutines
-				s.Add(in)
-			}
+// addHistory adds the distinct keys of the counts to the history, drops the intervals that have
+// left the longest window, and returns the history and the windows. It is called with s.mu held.
+//
+// The reports are grouped into intervals a tenth of the shortest window long, so the number of
+// intervals merged for a report doesn't depend on how often reports are sent. The windows are
+// as precise as the length of the intervals.
+func (s *Summary) addHistory(now time.Time, counts *summaryCounts) ([]uniqueInterval, []time.Duration) {
+	var shortest, longest time.Duration
+	for _, d := range s.uniqueWindows {
+		if d > longest {
+			longest = d
 		}
-	}()
+		if shortest == 0 || d < shortest {
+			shortest = d
+		}
+	}
+	// Intervals with another precision can't be merged with the new ones
+	precision := counts.unique.ipAddresses.Precision()
+
+	if counts.hits > 0 {
+		last := len(s.history) - 1
+		if last >= 0 && now.Sub(s.history[last].start) < shortest/10 && s.history[last].unique.ipAddresses.Precision() == precision {
+			// The intervals aren't changed once they are added, since they are merged without
+			// holding the lock, so the last one is replaced by a copy
+			unique := s.history[last].unique.clone()
+			unique.merge(counts.unique)
+			s.history[last] = uniqueInterval{start: s.history[last].start, end: now, unique: unique}
+		} else {
+			s.history = append(s.history, uniqueInterval{start: now, end: now, unique: counts.unique})
+		}
+	}
+	kept := s.history[:0]
+	for _, interval := range s.history {
+		if interval.end.After(now.Add(-longest)) && interval.unique.ipAddresses.Precision() == precision {
+			kept = append(kept, interval)
+		}
+	}
+	// Copy the history, since the intervals are shifted down in place when they are dropped
+	s.history = kept
+	history := make([]uniqueInterval, len(kept))
+	copy(history, kept)
+	return history, s.uniqueWindows
+}
+
+// trending returns the sections of the report that are growing the fastest against their
+// baseline, the rate of their hits before the report with the older hits decayed, and then
+// adds the hits of the report to the baselines. It is called with s.mu held.
+//
+// Until the baselines have been counted for a half-life, every section would look new,
+// so no sections are trending.
+func (s *Summary) trending(now time.Time, interval time.Duration, sections *counter.SpaceSaving) []TrendingSection {
+	trending := []TrendingSection{}
+	if interval <= 0 {
+		return trending
+	}
+	observed := s.observed.Value("seconds", now)
+	warm := now.Sub(s.baselineSince) >= s.baseline.HalfLife() && observed > 0
+	// A section without a baseline is compared with the rate of a single hit
+	floor := 1 / observed
+	for _, e := range sections.Estimates(0) {
+		rate := float64(e.Count) / interval.Seconds()
+		var baseline float64
+		if observed > 0 {
+			baseline = s.baseline.Value(e.Key, now) / observed
+		}
+		if warm && rate >= minGrowth*math.Max(baseline, floor) {
+			trending = append(trending, TrendingSection{
+				Section:  e.Key,
+				Hits:     e.Count,
+				Rate:     rate,
+				Baseline: baseline,
+				Growth:   rate / math.Max(baseline, floor),
+				New:      baseline == 0,
+			})
+		}
+		s.baseline.Add(e.Key, float64(e.Count), now)
+	}
+	s.observed.Add("seconds", interval.Seconds(), now)
+	// Forget the sections that are down to a fraction of a hit
+	s.baseline.Prune(now, 0.01)
 
-	// Start a goroutine to send a report into the output channel every X seconds based on the trigger time
+	sort.SliceStable(trending, func(i, j int) bool { return trending[i].Growth > trending[j].Growth })
+	if len(trending) > topN {
+		trending = trending[:topN]
+	}
+	return trending
+}
+
+// Start starts the Summary listener. When the context is cancelled or the log channel is closed,
+// a final report is sent for the partial interval and the OutputChannel is closed.
+// Lines with a URL that a section can't be determined for are sent into errs.
+func (s *Summary) Start(ctx context.Context, listenChan log.Channel, errs log.ErrorChannel) OutputChannel {
+	recv := make(OutputChannel)
+	// start a goroutine that will listen for log entries and send a report into the
+	// output channel every X seconds based on the trigger time
 	go func() {
-		clock := time.Tick(s.triggerInterval)
-		for range clock {
+		defer close(recv)
+		runTicker(ctx, s.Interval(), s.intervals, listenChan, func(in log.Line) {
+			if _, err := in.Request.Section(); err != nil {
+				errs.Send(ctx, &log.Error{Stage: log.StageListener, Reason: log.ReasonInvalidURL, Raw: in.String(), Err: err})
+			}
+			s.Add(in)
+		}, func() {
 			if report, err := s.Report(); err == nil {
-				recv <- report.String()
+				recv <- report
+			} else if err != ErrNoRequests {
+				errs.Send(ctx, &log.Error{Stage: log.StageListener, Reason: log.ReasonReport, Err: err})
 			}
-		}
+		})
+		s.flush(recv)
 	}()
 
 	return recv
 }
 
+// flush sends a report for whatever has been added since the last report
+func (s *Summary) flush(recv OutputChannel) {
+	if report, err := s.Report(); err == nil {
+		recv <- report
+	}
+}
+
+// This ensures adherence to the Event interface
+var _ = Event(SummaryReport{})
+
 // SummaryReport is the data structure that holds all the information for the report
 type SummaryReport struct {
-	Section        SummaryReportItem
-	MostActiveUser SummaryReportItem
-	Error4XX       SummaryReportItem
-	Error5XX       SummaryReportItem
+	Timestamp time.Time `json:"-"`
+	Window    Window    `json:"-"`
+	Hits      int       `json:"hits"`
+
+	Section        SummaryReportItem `json:"section"`
+	MostActiveUser SummaryReportItem `json:"most_active_user"`
+	Error4XX       SummaryReportItem `json:"error_4xx"`
+	Error5XX       SummaryReportItem `json:"error_5xx"`
+
+	TopSections []SummaryReportItem `json:"top_sections"`
+	// Unique is the estimated number of distinct IP addresses, users and URLs in the report
+	Unique UniqueCounts `json:"unique"`
+	// UniqueOver is the estimated number of distinct IP addresses, users and URLs in each of
+	// the rolling windows that end with the report
+	UniqueOver []UniqueWindow `json:"unique_over"`
+	// Trending is the sections that are growing the fastest against their baseline
+	Trending []TrendingSection `json:"trending"`
+	// SectionTree is the top sections with the top sections inside each of them, down to the tree depth
+	SectionTree []SectionNode `json:"section_tree"`
+	// TopRoutes are the endpoints with the most hits, such as "/users/:id"
+	TopRoutes      []SummaryReportItem `json:"top_routes"`
+	TopUsers       []SummaryReportItem `json:"top_users"`
+	TopIPAddresses []SummaryReportItem `json:"top_ip_addresses"`
+	// TopSources is empty unless the lines were read from more than one source
+	TopSources    []SummaryReportItem `json:"top_sources,omitempty"`
+	StatusClasses []SummaryReportItem `json:"status_classes"`
+	// StatusCodes are the hits of every status code, and Methods of every request method
+	StatusCodes []SummaryReportItem `json:"status_codes"`
+	Methods     []SummaryReportItem `json:"methods"`
+	// TopSectionsByStatusClass are the sections with the most errors of each class, "4xx" and "5xx"
+	TopSectionsByStatusClass map[string][]SummaryReportItem `json:"top_sections_by_status_class"`
+
+	// Apdex is the score of the requests with a latency, it is nil if none of them have one.
+	// ApdexBySection has the scores of the top sections that have requests with a latency.
+	Apdex          *ApdexScore    `json:"apdex,omitempty"`
+	ApdexBySection []SectionApdex `json:"apdex_by_section,omitempty"`
+	// HitsPerSecond is the number of requests for every second of the window
+	HitsPerSecond []int `json:"hits_per_second"`
+
+	// Bytes is the total size of the responses, and BytesPerSecond is averaged over the window
+	Bytes          int64        `json:"bytes"`
+	BytesPerSecond float64      `json:"bytes_per_second"`
+	ResponseSize   ResponseSize `json:"response_size"`
+	// TopSectionsByBytes and TopIPAddressesByBytes have the bytes sent as their values
+	TopSectionsByBytes    []SummaryReportItem `json:"top_sections_by_bytes"`
+	TopIPAddressesByBytes []SummaryReportItem `json:"top_ip_addresses_by_bytes"`
+}
+
+// Type is part of the Event interface
+func (sr SummaryReport) Type() string {
+	return "summary"
 }
 
+// Time is part of the Event interface
+func (sr SummaryReport) Time() time.Time {
+	return sr.Timestamp
+}
+
+// TimeWindow is part of the Event interface
+func (sr SummaryReport) TimeWindow() Window {
+	return sr.Window
+}
+
+// String returns the report in the text output. The lists that are empty, such as the top
+// routes when no routes are configured, are left out.
 func (sr SummaryReport) String() string {
-	return fmt.Sprintf(`Section with the most hits: %s (%d),
+	var b strings.Builder
+	fmt.Fprintf(&b, `Section with the most hits: %s (%d),
 * Most Active User: %s (%d)
 * %s: %d
 * %s: %d
@@ -88,10 +587,388 @@ func (sr SummaryReport) String() string {
 		sr.MostActiveUser.Key, sr.MostActiveUser.Value,
 		sr.Error4XX.Key, sr.Error4XX.Value,
 		sr.Error5XX.Key, sr.Error5XX.Value)
+	line := func(name string, items []SummaryReportItem, format func(int) string) {
+		if len(items) == 0 {
+			return
+		}
+		values := make([]string, len(items))
+		for i, item := range items {
+			values[i] = fmt.Sprintf("%s (%s)", item.Key, format(item.Value))
+		}
+		fmt.Fprintf(&b, "* %s: %s\n", name, strings.Join(values, ", "))
+	}
+	hits := strconv.Itoa
+	bytes := func(n int) string { return FormatBytes(float64(n)) }
+
+	line("Top sections", sr.TopSections, hits)
+	if len(sr.SectionTree) > 0 {
+		fmt.Fprintf(&b, "* Section tree: %s\n", sectionTree(sr.SectionTree))
+	}
+	line("Top routes", sr.TopRoutes, hits)
+	if len(sr.Trending) > 0 {
+		trending := make([]string, len(sr.Trending))
+		for i, t := range sr.Trending {
+			growth := fmt.Sprintf("x%.1f", t.Growth)
+			if t.New {
+				growth = "new"
+			}
+			trending[i] = fmt.Sprintf("%s (%d, %s)", t.Section, t.Hits, growth)
+		}
+		fmt.Fprintf(&b, "* Trending: %s\n", strings.Join(trending, ", "))
+	}
+	line("Top sources", sr.TopSources, hits)
+	fmt.Fprintf(&b, "* Distinct IP addresses: %d, users: %d, URLs: %d\n", sr.Unique.IPAddresses, sr.Unique.Users, sr.Unique.URLs)
+	for _, w := range sr.UniqueOver {
+		fmt.Fprintf(&b, "* Distinct over the last %s: IP addresses: %d, users: %d, URLs: %d\n",
+			ShortDuration(w.Duration), w.IPAddresses, w.Users, w.URLs)
+	}
+	line("Status codes", sr.StatusCodes, hits)
+	line("Methods", sr.Methods, hits)
+	line("Top sections with 4xx", sr.TopSectionsByStatusClass["4xx"], hits)
+	line("Top sections with 5xx", sr.TopSectionsByStatusClass["5xx"], hits)
+	size := sr.ResponseSize
+	fmt.Fprintf(&b, "* Bytes: %s (%s per second), response size avg: %s, p50: %s, p90: %s, p99: %s, max: %s\n",
+		FormatBytes(float64(sr.Bytes)), FormatBytes(sr.BytesPerSecond), FormatBytes(size.Average),
+		FormatBytes(float64(size.P50)), FormatBytes(float64(size.P90)), FormatBytes(float64(size.P99)), FormatBytes(float64(size.Max)))
+	line("Top sections by bytes", sr.TopSectionsByBytes, bytes)
+	line("Top IP addresses by bytes", sr.TopIPAddressesByBytes, bytes)
+	if sr.Apdex != nil {
+		fmt.Fprintf(&b, "* Apdex: %.2f (satisfied: %d, tolerating: %d, frustrated: %d)\n",
+			sr.Apdex.Score, sr.Apdex.Satisfied, sr.Apdex.Tolerating, sr.Apdex.Frustrated)
+	}
+	if len(sr.ApdexBySection) > 0 {
+		sections := make([]string, len(sr.ApdexBySection))
+		for i, a := range sr.ApdexBySection {
+			sections[i] = fmt.Sprintf("%s (%.2f of %d)", a.Section, a.Score, a.Total())
+		}
+		fmt.Fprintf(&b, "* Apdex by section: %s\n", strings.Join(sections, ", "))
+	}
+	return b.String()
+}
+
+// sectionTree returns the nodes with their hits, each followed by the nodes inside it in brackets,
+// such as "/api (10) [/api/user (4), /api/report (3)]"
+func sectionTree(nodes []SectionNode) string {
+	values := make([]string, len(nodes))
+	for i, node := range nodes {
+		values[i] = fmt.Sprintf("%s (%d)", node.Section, node.Hits)
+		if len(node.Children) > 0 {
+			values[i] += " [" + sectionTree(node.Children) + "]"
+		}
+	}
+	return strings.Join(values, ", ")
 }
 
 // SummaryReportItem ...
 type SummaryReportItem struct {
-	Key   string
-	Value int
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+}
+
+func newSummaryReportItems(dd []counter.Dict) []SummaryReportItem {
+	items := make([]SummaryReportItem, len(dd))
+	for i, d := range dd {
+		items[i] = SummaryReportItem{d.Key, d.Value}
+	}
+	return items
+}
+
+// TrendingSection is a section with more hits than usual
+type TrendingSection struct {
+	Section string `json:"section"`
+	Hits    int    `json:"hits"`
+	// Rate is the requests per second in the report, and Baseline is the decayed rate before it
+	Rate     float64 `json:"rate"`
+	Baseline float64 `json:"baseline"`
+	// Growth is how many times the Rate is over the Baseline, or over the rate of a single hit
+	// in the time the baselines were counted over if the section is New
+	Growth float64 `json:"growth"`
+	New    bool    `json:"new"`
+}
+
+// ApdexScore is an Apdex score, from 0 when every user was frustrated to 1 when every user was
+// satisfied, and the requests it was counted from
+type ApdexScore struct {
+	Score float64 `json:"score"`
+	log.Apdex
+}
+
+// SectionApdex is the Apdex score of a section
+type SectionApdex struct {
+	Section string `json:"section"`
+	ApdexScore
+}
+
+// ResponseSize is the average and percentiles of the response sizes in bytes. The percentiles
+// are estimated to within about 3%.
+type ResponseSize struct {
+	Average float64 `json:"average"`
+	P50     int64   `json:"p50"`
+	P90     int64   `json:"p90"`
+	P99     int64   `json:"p99"`
+	Max     int64   `json:"max"`
+}
+
+// UniqueCounts are the estimated numbers of distinct keys
+type UniqueCounts struct {
+	IPAddresses uint64 `json:"ip_addresses"`
+	Users       uint64 `json:"users"`
+	URLs        uint64 `json:"urls"`
+}
+
+// UniqueWindow is the estimated numbers of distinct keys over a rolling window
+type UniqueWindow struct {
+	Duration time.Duration `json:"-"`
+	// Window is the Duration, such as "5m0s"
+	Window string `json:"window"`
+	UniqueCounts
+}
+
+// SectionNode is a section of the SectionTree, with the sections one segment deeper inside it
+type SectionNode struct {
+	Section  string        `json:"section"`
+	Hits     int           `json:"hits"`
+	Children []SectionNode `json:"children,omitempty"`
+}
+
+// summaryCounts is what a Summary has counted for a report
+type summaryCounts struct {
+	hits               int
+	error4XX, error5XX int
+	sections           *counter.SpaceSaving
+	routes             *counter.SpaceSaving
+	users              *counter.SpaceSaving
+	ipAddresses        *counter.SpaceSaving
+	sources            *counter.SpaceSaving
+	// bytes is the total size of the responses, and the ByBytes top lists are counted in bytes
+	bytes              int64
+	sizes              *counter.Histogram
+	sectionsByBytes    *counter.SpaceSaving
+	ipAddressesByBytes *counter.SpaceSaving
+	// tree has the sections at every depth of the section tree. Below the first depth they are
+	// keyed by their parent section and themselves, separated by treeSeparator.
+	tree          []*counter.SpaceSaving
+	statusClasses map[string]int
+	// statusCodes and methods are sketches since they come from the lines, which can have anything in them
+	statusCodes *counter.SpaceSaving
+	methods     *counter.SpaceSaving
+	// errorSections are the sections of the requests with each class of error, "4xx" and "5xx"
+	errorSections map[string]*counter.SpaceSaving
+	// apdex counts the requests with a latency, and sectionApdex those of the sections that are
+	// kept track of
+	apdex        log.Apdex
+	sectionApdex map[string]*log.Apdex
+	perSecond    map[int64]int // hits by unix time
+	unique       uniqueSketches
+	capacity     int
+}
+
+const treeSeparator = "\x00"
+
+func newSummaryCounts(capacity, precision int) *summaryCounts {
+	return &summaryCounts{
+		unique:             newUniqueSketches(precision),
+		sections:           counter.NewSpaceSaving(capacity),
+		routes:             counter.NewSpaceSaving(capacity),
+		users:              counter.NewSpaceSaving(capacity),
+		ipAddresses:        counter.NewSpaceSaving(capacity),
+		sources:            counter.NewSpaceSaving(capacity),
+		sizes:              counter.NewHistogram(),
+		sectionsByBytes:    counter.NewSpaceSaving(capacity),
+		ipAddressesByBytes: counter.NewSpaceSaving(capacity),
+		statusClasses:      make(map[string]int),
+		statusCodes:        counter.NewSpaceSaving(capacity),
+		sectionApdex:       make(map[string]*log.Apdex),
+		methods:            counter.NewSpaceSaving(capacity),
+		errorSections: map[string]*counter.SpaceSaving{
+			"4xx": counter.NewSpaceSaving(capacity),
+			"5xx": counter.NewSpaceSaving(capacity),
+		},
+		perSecond: make(map[int64]int),
+		capacity:  capacity,
+	}
+}
+
+// add counts the line, with the hits per second only counted from since
+func (c *summaryCounts) add(line log.Line, since time.Time, sectionDepth, treeDepth int, router *log.Router, apdex *log.ApdexRules) {
+	c.hits++
+	if line.IsClientError() {
+		c.error4XX++
+	}
+	if line.IsServerError() {
+		c.error5XX++
+	}
+	c.bytes += int64(line.Size)
+	apdex.Add(&c.apdex, line)
+	c.sizes.Add(int64(line.Size))
+	if section, ok := log.BySectionDepth(sectionDepth)(line); ok {
+		// A section that had been replaced in the top sections starts its Apdex over
+		if c.sections.Count(section).Count == 0 {
+			delete(c.sectionApdex, section)
+		}
+		c.sections.Increment(section)
+		c.sectionsByBytes.Add(section, line.Size)
+		if byClass, ok := c.errorSections[line.StatusClass()]; ok {
+			byClass.Increment(section)
+		}
+		if a, ok := c.sectionApdex[section]; ok {
+			apdex.Add(a, line)
+		} else if line.HasLatency {
+			if len(c.sectionApdex) >= 2*c.capacity {
+				c.pruneSectionApdex()
+			}
+			a = &log.Apdex{}
+			apdex.Add(a, line)
+			c.sectionApdex[section] = a
+		}
+	}
+	if route, ok := router.Key(line); ok {
+		c.routes.Increment(route)
+	}
+	c.users.Increment(line.UserID)
+	c.ipAddresses.Increment(line.IPAddress)
+	c.ipAddressesByBytes.Add(line.IPAddress, line.Size)
+	if line.Source != "" {
+		c.sources.Increment(line.Source)
+	}
+	c.statusClasses[line.StatusClass()]++
+	if code, ok := log.ByStatusCode(line); ok {
+		c.statusCodes.Increment(code)
+	}
+	c.methods.Increment(line.Request.Method)
+	c.unique.add(line)
+	if !line.Date.Before(since.Truncate(time.Second)) {
+		c.perSecond[line.Date.Unix()]++
+	}
+
+	// Count the line in the sections of its path at every depth, stopping where the path ends
+	parent := ""
+	for depth := 1; depth <= treeDepth; depth++ {
+		section, err := line.Request.SectionAt(depth)
+		if err != nil || section == parent {
+			break
+		}
+		if len(c.tree) < depth {
+			c.tree = append(c.tree, counter.NewSpaceSaving(c.capacity))
+		}
+		key := section
+		if depth > 1 {
+			key = parent + treeSeparator + section
+		}
+		c.tree[depth-1].Increment(key)
+		parent = section
+	}
+}
+
+// pruneSectionApdex drops the Apdex of the sections that have been replaced in the top sections.
+// They are only pruned once there are twice the capacity of them, so adding a line stays cheap.
+func (c *summaryCounts) pruneSectionApdex() {
+	for section := range c.sectionApdex {
+		if c.sections.Count(section).Count == 0 {
+			delete(c.sectionApdex, section)
+		}
+	}
+}
+
+// hitsPerSecond returns the number of hits for every second between start and end
+func (c *summaryCounts) hitsPerSecond(start, end time.Time) []int {
+	start = start.Truncate(time.Second)
+	if !end.After(start) {
+		return nil
+	}
+	hits := make([]int, int((end.Sub(start)+time.Second-1)/time.Second))
+	for second, n := range c.perSecond {
+		t := time.Unix(second, 0)
+		if t.Before(start) || !t.Before(end) {
+			continue
+		}
+		hits[int(t.Sub(start)/time.Second)] += n
+	}
+	return hits
+}
+
+// sectionTree returns the topN sections at the first depth, each with the topN sections
+// inside it, down to the deepest depth. Lines with a path that ends at a section are
+// counted in its hits, but not in any of its children.
+func (c *summaryCounts) sectionTree() []SectionNode {
+	levels := make([][]counter.Estimate, len(c.tree))
+	for i, sections := range c.tree {
+		levels[i] = sections.Estimates(0)
+	}
+	nodes := sectionNodes(levels, 0, "")
+	if nodes == nil {
+		nodes = []SectionNode{}
+	}
+	return nodes
+}
+
+func sectionNodes(levels [][]counter.Estimate, depth int, parent string) []SectionNode {
+	if depth >= len(levels) {
+		return nil
+	}
+	var nodes []SectionNode
+	for _, e := range levels[depth] {
+		section := e.Key
+		if depth > 0 {
+			if !strings.HasPrefix(e.Key, parent+treeSeparator) {
+				continue
+			}
+			section = strings.TrimPrefix(e.Key, parent+treeSeparator)
+		}
+		nodes = append(nodes, SectionNode{
+			Section:  section,
+			Hits:     e.Count,
+			Children: sectionNodes(levels, depth+1, section),
+		})
+		if len(nodes) == topN {
+			break
+		}
+	}
+	return nodes
+}
+
+// uniqueSketches estimate the distinct IP addresses, users and URLs
+type uniqueSketches struct {
+	ipAddresses, users, urls *counter.HyperLogLog
+}
+
+// newUniqueSketches returns empty uniqueSketches with the precision, which has been validated
+func newUniqueSketches(precision int) uniqueSketches {
+	var u uniqueSketches
+	for _, h := range []**counter.HyperLogLog{&u.ipAddresses, &u.users, &u.urls} {
+		var err error
+		if *h, err = counter.NewHyperLogLog(precision); err != nil {
+			panic(err)
+		}
+	}
+	return u
+}
+
+func (u uniqueSketches) add(line log.Line) {
+	u.ipAddresses.Add(line.IPAddress)
+	u.users.Add(line.UserID)
+	u.urls.Add(line.Request.URL)
+}
+
+// merge adds the keys of other, which has the same precision
+func (u uniqueSketches) merge(other uniqueSketches) {
+	u.ipAddresses.Merge(other.ipAddresses)
+	u.users.Merge(other.users)
+	u.urls.Merge(other.urls)
+}
+
+func (u uniqueSketches) clone() uniqueSketches {
+	return uniqueSketches{
+		ipAddresses: u.ipAddresses.Clone(),
+		users:       u.users.Clone(),
+		urls:        u.urls.Clone(),
+	}
+}
+
+func (u uniqueSketches) counts() UniqueCounts {
+	return UniqueCounts{
+		IPAddresses: u.ipAddresses.Count(),
+		Users:       u.users.Count(),
+		URLs:        u.urls.Count(),
+	}
 }
@@ -1,9 +1,14 @@
 package listeners
 
 import (
-	// "fmt"
+	"context"
+	"fmt"
+	"math"
 	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/caitlin615/logmonitor/log"
 )
@@ -43,3 +48,369 @@ func TestSummaryReport(t *testing.T) {
 		t.Errorf("bad Error5XX summary: got: %v", report.Error5XX)
 	}
 }
+
+// checkText fails the test unless the text output of the report has each of the lines
+func checkText(t *testing.T, report SummaryReport, lines ...string) {
+	t.Helper()
+	text := report.String()
+	for _, line := range lines {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected %q in the text output:\n%s", line, text)
+		}
+	}
+}
+
+func TestSummaryStartFlushesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	listenChan := make(log.Channel)
+	recv := NewSummaryListener().Start(ctx, listenChan, nil)
+
+	for i := 0; i < 10; i++ {
+		listenChan <- log.RandomLine()
+	}
+	cancel()
+
+	// The partial interval should be reported before the channel is closed
+	var reports []Event
+	for e := range recv {
+		reports = append(reports, e)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected one final report, got: %v", reports)
+	}
+	if report := reports[0].(SummaryReport); report.Hits != 10 {
+		t.Errorf("expected 10 hits in the final report, got: %d", report.Hits)
+	}
+}
+
+func TestSummaryStartClosesWithLogChannel(t *testing.T) {
+	listenChan := make(log.Channel)
+	recv := NewSummaryListener().Start(context.Background(), listenChan, nil)
+	close(listenChan)
+
+	select {
+	case _, ok := <-recv:
+		if ok {
+			t.Error("expected no report when nothing was added")
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the output channel to be closed")
+	}
+}
+
+func TestSummaryReportTopSources(t *testing.T) {
+	summary := NewSummaryListener()
+	summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api"}, Source: "shop.log"})
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.TopSources) != 0 {
+		t.Errorf("expected no sources with a single source, got: %v", report.TopSources)
+	}
+	if strings.Contains(report.String(), "Top sources") {
+		t.Errorf("expected no sources in the text output:\n%s", report)
+	}
+
+	summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api"}, Source: "shop.log"})
+	summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api"}, Source: "shop.log"})
+	summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api"}, Source: "blog.log"})
+	if report, err = summary.Report(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []SummaryReportItem{{"shop.log", 2}, {"blog.log", 1}}
+	if !reflect.DeepEqual(report.TopSources, expected) {
+		t.Errorf("expected %v, got: %v", expected, report.TopSources)
+	}
+	checkText(t, report, "* Top sources: shop.log (2), blog.log (1)\n")
+}
+
+func TestSummaryReportTopRoutes(t *testing.T) {
+	summary := NewSummaryListener()
+	if err := summary.Reconfigure(Options{"routes": []interface{}{"/api/v1/orders/:id"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"/api/v1/orders/abc", "/api/v1/orders/def", "/users/1", "/users/2", "/users/3"} {
+		summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: url}})
+	}
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SummaryReportItem{{"/users/:id", 3}, {"/api/v1/orders/:id", 2}}
+	if !reflect.DeepEqual(report.TopRoutes, expected) {
+		t.Errorf("expected %v, got: %v", expected, report.TopRoutes)
+	}
+	checkText(t, report, "* Top routes: /users/:id (3), /api/v1/orders/:id (2)\n")
+
+	if err := summary.Reconfigure(Options{"routes": []interface{}{"api"}}); err == nil {
+		t.Error("expected an error for a route that doesn't start with /")
+	}
+}
+
+func TestSummaryReportSectionTree(t *testing.T) {
+	summary := NewSummaryListener()
+	if err := summary.Reconfigure(Options{"section_depth": int64(2), "tree_depth": int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"/api/user/settings", "/api/user", "/api/orders", "/api", "/report"} {
+		summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: url}})
+	}
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Section != (SummaryReportItem{"/api/user", 2}) {
+		t.Errorf("expected the section with the most hits at depth 2, got: %v", report.Section)
+	}
+	expected := []SectionNode{
+		{Section: "/api", Hits: 4, Children: []SectionNode{{Section: "/api/user", Hits: 2}, {Section: "/api/orders", Hits: 1}}},
+		{Section: "/report", Hits: 1},
+	}
+	if !reflect.DeepEqual(report.SectionTree, expected) {
+		t.Errorf("expected %v, got: %v", expected, report.SectionTree)
+	}
+	checkText(t, report, "Section with the most hits: /api/user (2),\n", "* Section tree: /api (4) [/api/user (2), /api/orders (1)], /report (1)\n")
+
+	if err := summary.Reconfigure(Options{"section_depth": int64(0)}); err == nil {
+		t.Error("expected an error for a section depth of 0")
+	}
+}
+
+func TestSummaryReportUnique(t *testing.T) {
+	summary := NewSummaryListener()
+	if err := summary.Reconfigure(Options{"unique_precision": int64(10), "unique_windows": []interface{}{"1h"}}); err != nil {
+		t.Fatal(err)
+	}
+	add := func(n int, prefix string) {
+		for i := 0; i < n; i++ {
+			summary.Add(log.Line{
+				Date:      time.Now(),
+				IPAddress: fmt.Sprintf("%s.%d", prefix, i),
+				UserID:    "frank",
+				Request:   log.LineRequest{URL: fmt.Sprintf("/api/%d", i%10)},
+			})
+		}
+	}
+	add(100, "10.0.0")
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unique != (UniqueCounts{IPAddresses: 100, Users: 1, URLs: 10}) {
+		t.Errorf("bad unique counts: %+v", report.Unique)
+	}
+	checkText(t, report, "* Distinct IP addresses: 100, users: 1, URLs: 10\n")
+
+	// The rolling window counts the distinct keys of both reports
+	add(100, "10.0.1")
+	if report, err = summary.Report(); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.UniqueOver) != 1 || report.UniqueOver[0].Window != "1h0m0s" || report.UniqueOver[0].IPAddresses < 190 || report.UniqueOver[0].IPAddresses > 210 {
+		t.Errorf("bad unique counts over the window: %+v", report.UniqueOver)
+	}
+	checkText(t, report, "* Distinct over the last 1h: IP addresses: ")
+
+	for _, opts := range []Options{{"unique_precision": int64(20)}, {"unique_windows": []interface{}{"soon"}}} {
+		if err := summary.Reconfigure(opts); err == nil {
+			t.Errorf("expected an error for %v", opts)
+		}
+	}
+}
+
+func TestSummaryReportTrending(t *testing.T) {
+	summary := NewSummaryListener()
+	if err := summary.Reconfigure(Options{"trending_half_life": "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	add := func(url string, n int) {
+		for i := 0; i < n; i++ {
+			summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{URL: url}})
+		}
+	}
+	report := func() SummaryReport {
+		// Pretend the report is a minute long, and the baselines have been counted for long enough
+		summary.mu.Lock()
+		summary.since = time.Now().UTC().Add(-time.Minute)
+		summary.baselineSince = summary.since.Add(-time.Hour)
+		summary.mu.Unlock()
+		report, err := summary.Report()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	add("/api", 600)
+	add("/report", 60)
+	report()
+
+	// /api stays the same and /report grows, /user is new
+	add("/api", 600)
+	add("/report", 600)
+	add("/user", 120)
+	r := report()
+	trending := r.Trending
+	if len(trending) != 2 {
+		t.Fatalf("expected 2 trending sections, got: %+v", trending)
+	}
+	// A new section is compared with a single hit over the minute before
+	if trending[0].Section != "/user" || !trending[0].New || math.Abs(trending[0].Growth-120) > 0.1 {
+		t.Errorf("expected /user to be new and trending the most, got: %+v", trending[0])
+	}
+	if trending[1].Section != "/report" || trending[1].New || math.Abs(trending[1].Growth-10) > 0.1 {
+		t.Errorf("expected /report to be 10 times its baseline, got: %+v", trending[1])
+	}
+	checkText(t, r, "* Trending: /user (120, new), /report (600, x")
+}
+
+func TestSummaryReportBandwidth(t *testing.T) {
+	summary := NewSummaryListener()
+	for _, l := range []struct {
+		ip, url string
+		size    int
+	}{
+		{"10.0.0.1", "/report/daily", 100},
+		{"10.0.0.1", "/report/weekly", 300},
+		{"10.0.0.2", "/download/big.iso", 10000},
+		{"10.0.0.3", "/user/frank", 200},
+	} {
+		summary.Add(log.Line{IPAddress: l.ip, Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: l.url}, Size: l.size})
+	}
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Bytes != 10600 || report.BytesPerSecond <= 0 {
+		t.Errorf("bad bytes: %d %v", report.Bytes, report.BytesPerSecond)
+	}
+	if size := report.ResponseSize; size.Average != 2650 || size.P50 < 195 || size.P50 > 205 || size.Max != 10000 || size.P99 != 10000 {
+		t.Errorf("bad response size: %+v", size)
+	}
+	if expected := []SummaryReportItem{{"/download", 10000}, {"/report", 400}, {"/user", 200}}; !reflect.DeepEqual(report.TopSectionsByBytes, expected) {
+		t.Errorf("bad top sections by bytes: %v", report.TopSectionsByBytes)
+	}
+	if top := report.TopIPAddressesByBytes; len(top) != 3 || top[0] != (SummaryReportItem{"10.0.0.2", 10000}) || top[1] != (SummaryReportItem{"10.0.0.1", 400}) {
+		t.Errorf("bad top IP addresses by bytes: %v", top)
+	}
+	checkText(t, report,
+		"* Bytes: 10.4KiB (",
+		"max: 9.8KiB\n",
+		"* Top sections by bytes: /download (9.8KiB), /report (400B), /user (200B)\n",
+		"* Top IP addresses by bytes: 10.0.0.2 (9.8KiB), 10.0.0.1 (400B), ",
+	)
+}
+
+func TestSummaryReportStatusCodes(t *testing.T) {
+	summary := NewSummaryListener()
+	for _, l := range []struct {
+		method, url string
+		code        int
+	}{
+		{"GET", "/report/daily", 200},
+		{"GET", "/report/daily", 404},
+		{"GET", "/user/frank", 404},
+		{"POST", "/report/weekly", 404},
+		{"POST", "/api/user", 503},
+		{"GET", "/api/user", 500},
+		{"GET", "/user/frank", 999},
+	} {
+		summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: l.method, URL: l.url}, StatusCode: l.code})
+	}
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Error4XX.Value != 3 || report.Error5XX.Value != 2 {
+		t.Errorf("expected 3 4xx and 2 5xx, got: %d %d", report.Error4XX.Value, report.Error5XX.Value)
+	}
+	expected := []SummaryReportItem{{"200", 1}, {"404", 3}, {"500", 1}, {"503", 1}, {"999", 1}}
+	if !reflect.DeepEqual(report.StatusCodes, expected) {
+		t.Errorf("bad status codes: %v", report.StatusCodes)
+	}
+	if expected := []SummaryReportItem{{"GET", 5}, {"POST", 2}}; !reflect.DeepEqual(report.Methods, expected) {
+		t.Errorf("bad methods: %v", report.Methods)
+	}
+	if expected := []SummaryReportItem{{"/report", 2}, {"/user", 1}}; !reflect.DeepEqual(report.TopSectionsByStatusClass["4xx"], expected) {
+		t.Errorf("bad top 4xx sections: %v", report.TopSectionsByStatusClass["4xx"])
+	}
+	if expected := []SummaryReportItem{{"/api", 2}}; !reflect.DeepEqual(report.TopSectionsByStatusClass["5xx"], expected) {
+		t.Errorf("bad top 5xx sections: %v", report.TopSectionsByStatusClass["5xx"])
+	}
+	checkText(t, report,
+		"* Status codes: 200 (1), 404 (3), 500 (1), 503 (1), 999 (1)\n",
+		"* Methods: GET (5), POST (2)\n",
+		"* Top sections with 4xx: /report (2), /user (1)\n",
+		"* Top sections with 5xx: /api (2)\n",
+	)
+}
+
+func TestSummaryReportApdex(t *testing.T) {
+	summary, err := New("summary", Options{"apdex_satisfied": "100ms", "apdex_routes": []interface{}{"/search/*=1s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []struct {
+		url     string
+		latency time.Duration
+	}{
+		{"/report/daily", 50 * time.Millisecond},
+		{"/report/weekly", 300 * time.Millisecond},
+		{"/report/monthly", time.Second},
+		{"/search/logs", 800 * time.Millisecond},
+		{"/search/logs", 2 * time.Second},
+	} {
+		summary.(*Summary).Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: l.url}, Latency: l.latency, HasLatency: true})
+	}
+	// Requests without a latency aren't scored
+	summary.(*Summary).Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: "/user/frank"}})
+	report, err := summary.(*Summary).Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := report.Apdex; a == nil || a.Apdex != (log.Apdex{Satisfied: 2, Tolerating: 2, Frustrated: 1}) || a.Score != 0.6 {
+		t.Fatalf("bad apdex: %+v", a)
+	}
+	expected := []SectionApdex{
+		{"/report", ApdexScore{0.5, log.Apdex{Satisfied: 1, Tolerating: 1, Frustrated: 1}}},
+		{"/search", ApdexScore{0.75, log.Apdex{Satisfied: 1, Tolerating: 1}}},
+	}
+	if !reflect.DeepEqual(report.ApdexBySection, expected) {
+		t.Errorf("bad apdex by section: %+v", report.ApdexBySection)
+	}
+	checkText(t, report,
+		"* Apdex: 0.60 (satisfied: 2, tolerating: 2, frustrated: 1)\n",
+		"* Apdex by section: /report (0.50 of 3), /search (0.75 of 2)\n",
+	)
+
+	if _, err := New("summary", Options{"apdex_satisfied": "1s", "apdex_tolerating": "500ms"}); err == nil {
+		t.Error("expected an error for a tolerating threshold below the satisfied threshold")
+	}
+}
+
+func TestSummaryReportApdexEvicted(t *testing.T) {
+	summary := NewSummaryListener()
+	summary.SetCapacity(topN)
+	add := func(url string, latency time.Duration) {
+		summary.Add(log.Line{Date: time.Now(), Request: log.LineRequest{Method: "GET", URL: url}, Latency: latency, HasLatency: true})
+	}
+	// Fill the top sections and the Apdex with sections that are only seen once
+	for i := 0; i < 4*topN; i++ {
+		add(fmt.Sprintf("/once%d/page", i), time.Millisecond)
+	}
+	if n := len(summary.counts.sectionApdex); n > 2*topN {
+		t.Errorf("expected the replaced sections to be pruned, got the Apdex of %d sections", n)
+	}
+	// A section that shows up after them is still scored once it is in the top sections
+	for i := 0; i < 3; i++ {
+		add("/hot/page", 10*time.Second)
+	}
+	report, err := summary.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.ApdexBySection) == 0 || report.ApdexBySection[0].Section != "/hot" || report.ApdexBySection[0].Apdex != (log.Apdex{Frustrated: 3}) {
+		t.Errorf("bad apdex by section: %+v", report.ApdexBySection)
+	}
+}
@@ -0,0 +1,68 @@
+package listeners
+
+import (
+	"context"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// intervalChan passes a new interval to a started listener, it holds at most one change
+type intervalChan chan time.Duration
+
+func newIntervalChan() intervalChan {
+	return make(intervalChan, 1)
+}
+
+// set sends the interval to the started listener. If the listener hasn't picked up
+// a previous change yet, that change is replaced.
+func (c intervalChan) set(d time.Duration) {
+	for {
+		select {
+		case c <- d:
+			return
+		default:
+		}
+		select {
+		case <-c:
+		default:
+		}
+	}
+}
+
+// runTicker calls add with every line received from listenChan and tick every interval, or every
+// interval received from changes once it is set, until the context is cancelled or listenChan
+// is closed
+func runTicker(ctx context.Context, interval time.Duration, changes intervalChan, listenChan log.Channel, add func(log.Line), tick func()) {
+	clock := time.NewTicker(interval)
+	defer func() { clock.Stop() }()
+	for {
+		select {
+		case in, ok := <-listenChan:
+			if !ok {
+				return
+			}
+			add(in)
+		case <-clock.C:
+			tick()
+		case d := <-changes:
+			clock.Stop()
+			clock = time.NewTicker(d)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendAlert sends the event from the Report of an alert into recv. ErrInHighTrafficState and
+// ErrLowTrafficState mean that nothing has changed since the last check, other errors are
+// sent into errs.
+func sendAlert(ctx context.Context, recv OutputChannel, errs log.ErrorChannel, report Event, err error) {
+	switch err {
+	case nil:
+		recv <- report
+	case ErrInHighTrafficState, ErrLowTrafficState:
+	default:
+		errs.Send(ctx, &log.Error{Stage: log.StageListener, Reason: log.ReasonReport, Err: err})
+	}
+}
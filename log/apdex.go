@@ -0,0 +1,130 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Apdex counts requests by how satisfied their users were with their latency, to score the
+// performance of a site from 0, when every user was frustrated, to 1, when every user was
+// satisfied (https://en.wikipedia.org/wiki/Apdex)
+type Apdex struct {
+	Satisfied  int `json:"satisfied"`
+	Tolerating int `json:"tolerating"`
+	Frustrated int `json:"frustrated"`
+}
+
+// Total returns the number of requests that have been counted
+func (a Apdex) Total() int {
+	return a.Satisfied + a.Tolerating + a.Frustrated
+}
+
+// Score returns the Apdex score, the satisfied requests plus half of the tolerating ones out
+// of all of them, or 1 if no requests have been counted
+func (a Apdex) Score() float64 {
+	if a.Total() == 0 {
+		return 1
+	}
+	return (float64(a.Satisfied) + float64(a.Tolerating)/2) / float64(a.Total())
+}
+
+// Merge adds the requests counted by other
+func (a *Apdex) Merge(other Apdex) {
+	a.Satisfied += other.Satisfied
+	a.Tolerating += other.Tolerating
+	a.Frustrated += other.Frustrated
+}
+
+// ApdexThresholds are the latencies that users are satisfied with, and that they tolerate
+type ApdexThresholds struct {
+	Satisfied, Tolerating time.Duration
+}
+
+// ApdexRules are the ApdexThresholds of every route, so a search can be slower than a page
+// view and still satisfy its users. ApdexRules are safe to use from multiple goroutines.
+type ApdexRules struct {
+	defaults ApdexThresholds
+	router   *Router
+	routes   map[string]ApdexThresholds
+}
+
+// NewApdexRules returns ApdexRules with the thresholds for the requests that don't match any
+// of the routes. Routes are patterns as for NewRouter followed by their thresholds, such as
+// "/search/*=1s" or "/search/*=1s/3s", where the tolerating threshold defaults to 4 times the
+// satisfied threshold. The first route that matches a request is used.
+func NewApdexRules(defaults ApdexThresholds, routes []string) (*ApdexRules, error) {
+	if err := defaults.check(); err != nil {
+		return nil, err
+	}
+	r := &ApdexRules{defaults: defaults, routes: make(map[string]ApdexThresholds)}
+	patterns := make([]string, 0, len(routes))
+	for _, route := range routes {
+		eq := strings.LastIndex(route, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("apdex route %q should be a pattern and thresholds, such as \"/search/*=1s/3s\"", route)
+		}
+		pattern, thresholds := route[:eq], route[eq+1:]
+		var t ApdexThresholds
+		var err error
+		satisfied, tolerating := thresholds, ""
+		if slash := strings.Index(thresholds, "/"); slash >= 0 {
+			satisfied, tolerating = thresholds[:slash], thresholds[slash+1:]
+		}
+		if t.Satisfied, err = time.ParseDuration(satisfied); err != nil {
+			return nil, fmt.Errorf("apdex route %q: %v", route, err)
+		}
+		t.Tolerating = 4 * t.Satisfied
+		if tolerating != "" {
+			if t.Tolerating, err = time.ParseDuration(tolerating); err != nil {
+				return nil, fmt.Errorf("apdex route %q: %v", route, err)
+			}
+		}
+		if err := t.check(); err != nil {
+			return nil, fmt.Errorf("apdex route %q: %v", route, err)
+		}
+		if _, ok := r.routes[pattern]; !ok {
+			patterns = append(patterns, pattern)
+			r.routes[pattern] = t
+		}
+	}
+	router, err := NewRouter(patterns, false)
+	if err != nil {
+		return nil, err
+	}
+	r.router = router
+	return r, nil
+}
+
+func (t ApdexThresholds) check() error {
+	if t.Satisfied <= 0 || t.Tolerating < t.Satisfied {
+		return fmt.Errorf("the satisfied threshold should be positive and not more than the tolerating threshold, got %s and %s", t.Satisfied, t.Tolerating)
+	}
+	return nil
+}
+
+// Thresholds returns the thresholds of the first route that matches the request, or the defaults
+func (r *ApdexRules) Thresholds(lr *LineRequest) ApdexThresholds {
+	if pattern, ok := r.router.Match(lr); ok {
+		return r.routes[pattern]
+	}
+	return r.defaults
+}
+
+// Add counts the Line in the Apdex by its latency, and returns false without counting it if
+// its latency isn't known
+func (r *ApdexRules) Add(a *Apdex, l Line) bool {
+	if !l.HasLatency {
+		return false
+	}
+	t := r.Thresholds(&l.Request)
+	switch {
+	case l.Latency <= t.Satisfied:
+		a.Satisfied++
+	case l.Latency <= t.Tolerating:
+		a.Tolerating++
+	default:
+		a.Frustrated++
+	}
+	return true
+}
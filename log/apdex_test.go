@@ -0,0 +1,63 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApdexRules(t *testing.T) {
+	rules, err := NewApdexRules(ApdexThresholds{500 * time.Millisecond, 2 * time.Second}, []string{"/search/*=1s", "/checkout/:id=100ms/300ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a Apdex
+	if a.Score() != 1 {
+		t.Errorf("expected a score of 1 without requests, got: %v", a.Score())
+	}
+	for _, l := range []struct {
+		url     string
+		latency time.Duration
+	}{
+		{"/report", 400 * time.Millisecond},       // satisfied
+		{"/report", 3 * time.Second},              // frustrated
+		{"/search/shoes", 3 * time.Second},        // tolerating, up to 4s
+		{"/checkout/123", 200 * time.Millisecond}, // tolerating
+		{"/checkout/123", time.Second},            // frustrated
+	} {
+		if !rules.Add(&a, Line{Request: LineRequest{URL: l.url}, Latency: l.latency, HasLatency: true}) {
+			t.Errorf("expected %s to be counted", l.url)
+		}
+	}
+	if rules.Add(&a, Line{Request: LineRequest{URL: "/report"}}) {
+		t.Error("expected a line without a latency not to be counted")
+	}
+	if expected := (Apdex{Satisfied: 1, Tolerating: 2, Frustrated: 2}); a != expected {
+		t.Errorf("expected %+v, got: %+v", expected, a)
+	}
+	if a.Score() != 0.4 {
+		t.Errorf("expected a score of 0.4, got: %v", a.Score())
+	}
+
+	for _, route := range []string{"/search", "/search=fast", "search=1s", "/search=1s/500ms"} {
+		if _, err := NewApdexRules(ApdexThresholds{time.Second, 4 * time.Second}, []string{route}); err == nil {
+			t.Errorf("expected an error for route %q", route)
+		}
+	}
+}
+
+func TestTimedFormat(t *testing.T) {
+	raw := `127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123 "-" "curl/7.54.0" 0.250`
+	line, err := TimedFormat.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !line.HasLatency || line.Latency != 250*time.Millisecond || line.UserAgent != "curl/7.54.0" {
+		t.Errorf("bad line: %+v", line)
+	}
+	if line, err := TimedFormat.Parse(raw[:len(raw)-5] + "-"); err != nil || line.HasLatency {
+		t.Errorf("expected a line without a latency, got: %+v %v", line, err)
+	}
+	if line, err := CombinedFormat.Parse(raw); err != nil || line.HasLatency {
+		t.Errorf("expected the combined format not to have a latency, got: %+v %v", line, err)
+	}
+}
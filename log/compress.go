@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrZstdUnavailable is the error when the input is compressed with zstd and the zstd command
+// isn't installed, since the standard library can't decompress it
+var ErrZstdUnavailable = errors.New("zstd compressed input needs the zstd command, install it or decompress the input first with zstd -dc")
+
+// The magic bytes that compressed files start with
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressedExtensions are removed from the names of rotated logs to find their series
+var compressedExtensions = []string{".gz", ".bz2", ".zst"}
+
+// compression returns the name of the compression that the header starts with,
+// or an empty string if it isn't compressed
+func compression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(header, bzip2Magic):
+		return "bzip2"
+	case bytes.HasPrefix(header, zstdMagic):
+		return "zstd"
+	}
+	return ""
+}
+
+// Decompress returns a reader of the decompressed contents of r. The compression is detected
+// from the first bytes rather than the name of the file: gzip and bzip2 are decompressed with
+// the standard library, and zstd with the zstd command. If r isn't compressed, its contents
+// are returned as they are.
+// The returned reader should be closed once it is no longer needed, which doesn't close r.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(zstdMagic)) // anything shorter isn't compressed
+	switch compression(header) {
+	case "gzip":
+		return gzip.NewReader(br)
+	case "bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	case "zstd":
+		return newZstdReader(br)
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// zstdReader reads the output of the zstd command decompressing its input
+type zstdReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+
+	once    sync.Once
+	waitErr error
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, ErrZstdUnavailable
+	}
+	z := &zstdReader{cmd: exec.Command(path, "-dc")}
+	z.cmd.Stdin = r
+	z.cmd.Stderr = &z.stderr
+	if z.ReadCloser, err = z.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := z.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Read returns the error from the zstd command at the end of its output, such as for corrupt input
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.ReadCloser.Read(p)
+	if err == io.EOF {
+		if werr := z.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close stops the zstd command if it hasn't finished
+func (z *zstdReader) Close() error {
+	z.ReadCloser.Close()
+	z.cmd.Process.Kill()
+	z.wait()
+	return nil
+}
+
+func (z *zstdReader) wait() error {
+	z.once.Do(func() {
+		if err := z.cmd.Wait(); err != nil {
+			z.waitErr = fmt.Errorf("zstd: %v: %s", err, strings.TrimSpace(z.stderr.String()))
+		}
+	})
+	return z.waitErr
+}
+
+// rotation returns the series of rotated logs that the path belongs to and its position in the
+// series, where higher positions are older: access.log.2.gz is at 2 in the series access.log,
+// and access.log is at 0.
+func rotation(path string) (series string, n int) {
+	series = path
+	for _, ext := range compressedExtensions {
+		if strings.HasSuffix(series, ext) {
+			series = strings.TrimSuffix(series, ext)
+			break
+		}
+	}
+	if i := strings.LastIndexByte(series, '.'); i >= 0 {
+		if n, err := strconv.Atoi(series[i+1:]); err == nil && n >= 0 {
+			return series[:i], n
+		}
+	}
+	return series, 0
+}
+
+// rotationSeries groups the paths by the series of rotated logs they belong to, with the paths
+// of each series in chronological order, such as access.log.3.gz, access.log.2.gz, access.log.1,
+// access.log. The series are returned sorted by name.
+func rotationSeries(paths []string) (names []string, series map[string][]string) {
+	series = make(map[string][]string)
+	for _, path := range paths {
+		name, _ := rotation(path)
+		if _, ok := series[name]; !ok {
+			names = append(names, name)
+		}
+		series[name] = append(series[name], path)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		paths := series[name]
+		sort.Slice(paths, func(i, j int) bool {
+			_, ni := rotation(paths[i])
+			_, nj := rotation(paths[j])
+			if ni != nj {
+				return ni > nj
+			}
+			return paths[i] < paths[j]
+		})
+	}
+	return names, series
+}
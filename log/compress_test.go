@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// bzip2Line is testFileLine compressed with bzip2, since the standard library can't compress it
+var bzip2Line = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x55, 0x5d, 0x5a, 0x39, 0x00, 0x00,
+	0x13, 0xdf, 0x80, 0x40, 0x10, 0x50, 0x0b, 0xf9, 0xf0, 0x02, 0xc2, 0x44, 0x0a, 0x23, 0x09, 0xd4,
+	0x20, 0x20, 0x00, 0x54, 0x51, 0x0c, 0x8c, 0x99, 0x19, 0x1a, 0x7a, 0x34, 0x99, 0x1a, 0x7a, 0x84,
+	0x4d, 0x31, 0x4f, 0x48, 0xc4, 0x1a, 0x34, 0x06, 0x80, 0x39, 0xfa, 0xdf, 0x0d, 0x12, 0xbf, 0x79,
+	0x89, 0xc1, 0x8b, 0x22, 0x0d, 0xbe, 0x7d, 0x46, 0x18, 0x30, 0x71, 0x19, 0x66, 0x5c, 0x1a, 0x4d,
+	0x66, 0xa8, 0x47, 0x10, 0x72, 0x35, 0xa3, 0x92, 0x82, 0x58, 0x0f, 0x4c, 0x60, 0x0a, 0x29, 0x95,
+	0x0a, 0xa8, 0xc6, 0xfe, 0x15, 0xfe, 0x69, 0x68, 0xaf, 0xc5, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x15,
+	0x57, 0x56, 0x8e, 0x40,
+}
+
+func TestDecompress(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"plain": []byte(testFileLine),
+		"gzip":  gzipped(t, testFileLine),
+		"bzip2": bzip2Line,
+	} {
+		r, err := Decompress(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if out, err := ioutil.ReadAll(r); err != nil || string(out) != testFileLine {
+			t.Errorf("%s: expected the line, got: %q %v", name, out, err)
+		}
+		r.Close()
+	}
+}
+
+// zstdCompressed returns the data compressed with the zstd command, or skips the test if it isn't installed
+func zstdCompressed(t *testing.T, data string) []byte {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("the zstd command isn't installed")
+	}
+	cmd := exec.Command(zstd, "-c")
+	cmd.Stdin = bytes.NewReader([]byte(data))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDecompressZstd(t *testing.T) {
+	data := zstdCompressed(t, testFileLine)
+	r, err := Decompress(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if out, err := ioutil.ReadAll(r); err != nil || string(out) != testFileLine {
+		t.Errorf("expected the line, got: %q %v", out, err)
+	}
+
+	// Corrupt input is an error rather than the end of the input
+	r, err = Decompress(bytes.NewReader(append(zstdMagic, 0, 1, 2, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("expected an error for corrupt input")
+	}
+}
+
+func TestRotationSeries(t *testing.T) {
+	names, series := rotationSeries([]string{
+		"access.log", "access.log.1", "access.log.10.gz", "access.log.2.gz", "error.log.1.zst", "error.log",
+	})
+	if !reflect.DeepEqual(names, []string{"access.log", "error.log"}) {
+		t.Errorf("bad series: %v", names)
+	}
+	if expected := []string{"access.log.10.gz", "access.log.2.gz", "access.log.1", "access.log"}; !reflect.DeepEqual(series["access.log"], expected) {
+		t.Errorf("expected %v, got: %v", expected, series["access.log"])
+	}
+	if expected := []string{"error.log.1.zst", "error.log"}; !reflect.DeepEqual(series["error.log"], expected) {
+		t.Errorf("expected %v, got: %v", expected, series["error.log"])
+	}
+}
+
+func TestChannelReplayFilesRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Each file has a different user, so the order they are read in can be checked
+	for name, data := range map[string][]byte{
+		"access.log.2.gz": gzipped(t, `127.0.0.1 - oldest [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`+"\n"),
+		"access.log.1":    []byte(`127.0.0.1 - older [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123` + "\n"),
+		"access.log":      []byte(`127.0.0.1 - newest [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123` + "\n"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lc := make(Channel)
+	if err := lc.ReplayFiles(context.Background(), []string{filepath.Join(dir, "access.log*")}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for line := range lc {
+		users = append(users, line.UserID)
+		if line.Source != filepath.Join(dir, "access.log") {
+			t.Errorf("expected the series as the source, got: %q", line.Source)
+		}
+	}
+	if expected := []string{"oldest", "older", "newest"}; !reflect.DeepEqual(users, expected) {
+		t.Errorf("expected %v, got: %v", expected, users)
+	}
+}
+
+func TestChannelReplayFilesZstd(t *testing.T) {
+	data := zstdCompressed(t, `127.0.0.1 - older [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`+"\n")
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "access.log.1.zst"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "access.log"), []byte(testFileLine), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lc := make(Channel)
+	if err := lc.ReplayFiles(context.Background(), []string{filepath.Join(dir, "access.log*")}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for line := range lc {
+		users = append(users, line.UserID)
+	}
+	if expected := []string{"older", "frank"}; !reflect.DeepEqual(users, expected) {
+		t.Errorf("expected %v, got: %v", expected, users)
+	}
+}
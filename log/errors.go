@@ -0,0 +1,180 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrorChannel is a channel that accepts the errors from reading, parsing and listening to log lines
+type ErrorChannel chan error
+
+// Send sends the error into the ErrorChannel unless the context is cancelled first.
+// Sending to a nil ErrorChannel discards the error.
+func (ec ErrorChannel) Send(ctx context.Context, err error) {
+	if ec == nil {
+		return
+	}
+	select {
+	case ec <- err:
+	case <-ctx.Done():
+	}
+}
+
+// Stage is the part of the pipeline that an Error came from
+type Stage string
+
+// The stages of the pipeline
+const (
+	StageRead     Stage = "read"
+	StageParse    Stage = "parse"
+	StageListener Stage = "listener"
+	StageOutput   Stage = "output"
+)
+
+// The reasons an Error can have
+const (
+	ReasonRead        = "read_failed"
+	ReasonNoMatch     = "no_match"
+	ReasonInvalidDate = "invalid_date"
+	ReasonSyslog      = "invalid_syslog"
+	ReasonInvalidJSON = "invalid_json"
+	ReasonInvalidURL  = "invalid_url"
+	ReasonReport      = "report_failed"
+	ReasonWrite       = "write_failed"
+)
+
+// Error is an error from one of the stages of the pipeline
+type Error struct {
+	Stage Stage
+	// Reason is a short, stable description of the error, such as "no_match"
+	Reason string
+	// Raw is the raw log line that caused the error, if there is one
+	Raw string
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Raw != "" {
+		return fmt.Sprintf("%s: %s: %v: %q", e.Stage, e.Reason, e.Err, e.Raw)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Stage, e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorPolicy is what should happen when an error is received
+type ErrorPolicy string
+
+// The ErrorPolicies
+const (
+	// ErrorPolicyIgnore only counts the error
+	ErrorPolicyIgnore ErrorPolicy = "ignore"
+	// ErrorPolicyWarn counts the error and prints a warning
+	ErrorPolicyWarn ErrorPolicy = "warn"
+	// ErrorPolicyFail counts the error and stops the program
+	ErrorPolicyFail ErrorPolicy = "fail"
+)
+
+// ParseErrorPolicy returns the ErrorPolicy with the name, either "ignore", "warn" or "fail"
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	switch p := ErrorPolicy(name); p {
+	case ErrorPolicyIgnore, ErrorPolicyWarn, ErrorPolicyFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown error policy %q, expected ignore, warn or fail", name)
+}
+
+// maxErrorSamples is the number of raw lines that ErrorStats keeps
+const maxErrorSamples = 10
+
+// ErrorStats counts errors by their stage and reason and keeps a sample of the offending raw lines.
+// It is safe to use from multiple goroutines.
+type ErrorStats struct {
+	mu      sync.Mutex
+	counts  map[errorKey]int
+	samples []ErrorSample
+}
+
+type errorKey struct {
+	stage  Stage
+	reason string
+}
+
+// ErrorSample is a raw line that caused an error
+type ErrorSample struct {
+	Stage  Stage  `json:"stage"`
+	Reason string `json:"reason"`
+	Raw    string `json:"raw"`
+}
+
+// ErrorCount is the number of errors with a stage and reason
+type ErrorCount struct {
+	Stage  Stage  `json:"stage"`
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// NewErrorStats returns an empty ErrorStats
+func NewErrorStats() *ErrorStats {
+	return &ErrorStats{counts: make(map[errorKey]int)}
+}
+
+// Add counts the error. Errors that aren't an *Error are counted with an "unknown" stage and reason.
+func (es *ErrorStats) Add(err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Stage: "unknown", Reason: "unknown", Err: err}
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.counts[errorKey{e.Stage, e.Reason}]++
+	if e.Raw != "" {
+		es.samples = append(es.samples, ErrorSample{e.Stage, e.Reason, e.Raw})
+		if len(es.samples) > maxErrorSamples {
+			es.samples = es.samples[len(es.samples)-maxErrorSamples:]
+		}
+	}
+}
+
+// Counts returns the number of errors for each stage and reason, sorted by stage and reason
+func (es *ErrorStats) Counts() []ErrorCount {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	counts := make([]ErrorCount, 0, len(es.counts))
+	for key, count := range es.counts {
+		counts = append(counts, ErrorCount{Stage: key.stage, Reason: key.reason, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Stage != counts[j].Stage {
+			return counts[i].Stage < counts[j].Stage
+		}
+		return counts[i].Reason < counts[j].Reason
+	})
+	return counts
+}
+
+// Total returns the number of errors that have been counted
+func (es *ErrorStats) Total() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	total := 0
+	for _, count := range es.counts {
+		total += count
+	}
+	return total
+}
+
+// Samples returns the most recent raw lines that caused an error, oldest first
+func (es *ErrorStats) Samples() []ErrorSample {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return append([]ErrorSample{}, es.samples...)
+}
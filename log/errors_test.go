@@ -0,0 +1,103 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewLineErrors(t *testing.T) {
+	_, err := NewLine("not an access log line")
+	if e, ok := err.(*Error); !ok || e.Reason != ReasonNoMatch || e.Err != ErrInvalidLine {
+		t.Errorf("expected a no_match error, got: %v", err)
+	}
+
+	_, err = NewLine(`127.0.0.1 - james [2018-05-09T16:00:39Z] "GET /report HTTP/1.0" 200 1234`)
+	if e, ok := err.(*Error); !ok || e.Reason != ReasonInvalidDate || e.Stage != StageParse {
+		t.Errorf("expected an invalid_date error, got: %v", err)
+	}
+
+	if _, err = NewLine(`127.0.0.1 - james - "GET /report HTTP/1.0" 200 1234`); err != nil {
+		t.Errorf("a missing date should be allowed, got: %v", err)
+	}
+}
+
+func TestChannelTailErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	input := strings.NewReader("garbage\n" + `127.0.0.1 - james [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 1234` + "\n")
+
+	lc := make(Channel)
+	errs := make(ErrorChannel)
+	go lc.Tail(ctx, bufio.NewReader(input), errs)
+
+	err := <-errs
+	if e, ok := err.(*Error); !ok || e.Raw != "garbage" {
+		t.Errorf("expected a parse error for the garbage line, got: %v", err)
+	}
+	if line := <-lc; line.UserID != "james" {
+		t.Errorf("expected the valid line after the error, got: %s", line.String())
+	}
+}
+
+func TestChannelTailInvalidDate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	badDate := `127.0.0.1 - frank [2018-05-09T16:00:39Z] "GET /report HTTP/1.0" 200 1234`
+	input := strings.NewReader(badDate + "\n" + `127.0.0.1 - james [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 1234` + "\n")
+
+	lc := make(Channel)
+	errs := make(ErrorChannel)
+	go lc.Tail(ctx, bufio.NewReader(input), errs)
+
+	// The line with the bad date is dropped rather than sent without a date
+	err := <-errs
+	if e, ok := err.(*Error); !ok || e.Reason != ReasonInvalidDate || e.Raw != badDate {
+		t.Errorf("expected an invalid_date error for the line, got: %v", err)
+	}
+	if line := <-lc; line.UserID != "james" || line.Date.IsZero() {
+		t.Errorf("expected only the line with a valid date, got: %s", line.String())
+	}
+}
+
+func TestErrorStats(t *testing.T) {
+	stats := NewErrorStats()
+	for i := 0; i < maxErrorSamples+5; i++ {
+		_, err := NewLine("garbage")
+		stats.Add(err)
+	}
+	stats.Add(&Error{Stage: StageRead, Reason: ReasonRead, Err: errors.New("disk on fire")})
+	stats.Add(errors.New("not a log error"))
+
+	if stats.Total() != maxErrorSamples+7 {
+		t.Errorf("bad total: %d", stats.Total())
+	}
+	counts := stats.Counts()
+	expected := []ErrorCount{
+		{StageParse, ReasonNoMatch, maxErrorSamples + 5},
+		{StageRead, ReasonRead, 1},
+		{"unknown", "unknown", 1},
+	}
+	if len(counts) != len(expected) {
+		t.Fatalf("bad counts: %v", counts)
+	}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Errorf("bad count: expected %v, got %v", expected[i], counts[i])
+		}
+	}
+	if samples := stats.Samples(); len(samples) != maxErrorSamples || samples[0].Raw != "garbage" {
+		t.Errorf("bad samples: %v", samples)
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	if p, err := ParseErrorPolicy("fail"); err != nil || p != ErrorPolicyFail {
+		t.Errorf("bad policy: %v %v", p, err)
+	}
+	if _, err := ParseErrorPolicy("explode"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,373 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// globInterval is how often TailFiles looks for files that have started matching the patterns
+var globInterval = time.Second
+
+// drainDelay is how long TailFiles keeps following a file that has been replaced or removed, for
+// the lines its writers add until they reopen it, before reading it to its end and closing it
+var drainDelay = 5 * time.Second
+
+// Stdin is the pattern for reading from standard input
+const Stdin = "-"
+
+// stdin is what the Stdin pattern reads from, it is replaced by the tests
+var stdin io.Reader = os.Stdin
+
+// TailFiles tails every file that matches one of the patterns concurrently and sends their Lines
+// into the Channel, with the Source set to the path of the file. Patterns are either paths or
+// globs in the syntax of filepath.Match, such as /var/log/nginx/*.access.log, or Stdin.
+//
+// Files that exist when it is called are read from their end, like Tail. The patterns are checked
+// again every second: files that start matching are read from their beginning, files that are
+// replaced, such as by log rotation, are reopened, and files that are removed are no longer read.
+// Files that are replaced or removed are still read to their end, so no lines are lost.
+// Compressed files, such as rotated logs, are skipped. Standard input and named pipes are read
+// from where they are, standard input until it is closed and named pipes as writers come and go.
+//
+// It returns an error if a pattern is invalid or a path that isn't a glob can't be opened, then
+// nothing is started. Otherwise the files are tailed in the background until the context is
+// cancelled, or standard input is closed when it is the only pattern, then the Channel is closed.
+// Errors reading files that are found later are sent into errs.
+func (lc Channel) TailFiles(ctx context.Context, patterns []string, format *Format, errs ErrorChannel) error {
+	return lc.readFiles(ctx, patterns, format, false, errs)
+}
+
+// ReplayFiles is like TailFiles, except that the files are read from their beginning and the
+// Channel is closed once all of them have been read to the end, rather than following them.
+// Named pipes are still followed until the context is cancelled.
+//
+// Files and standard input compressed with gzip, bzip2 or zstd are decompressed, see Decompress.
+// The files of a series of rotated logs, such as access.log.2.gz, access.log.1 and access.log, are
+// read one after the other in chronological order, with the Source set to the name of the series.
+func (lc Channel) ReplayFiles(ctx context.Context, patterns []string, format *Format, errs ErrorChannel) error {
+	return lc.readFiles(ctx, patterns, format, true, errs)
+}
+
+func (lc Channel) readFiles(ctx context.Context, patterns []string, format *Format, replay bool, errs ErrorChannel) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("no files to read")
+	}
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: %v", pattern, err)
+		}
+	}
+
+	t := &fileTailer{
+		ctx:      ctx,
+		out:      lc,
+		patterns: patterns,
+		format:   format,
+		replay:   replay,
+		errs:     errs,
+		files:    make(map[string]*tailedFile),
+	}
+	scan := t.scan
+	if replay {
+		scan = func(bool) error { return t.replayFiles() }
+	}
+	if err := scan(true); err != nil {
+		for _, f := range t.files {
+			f.cancel()
+		}
+		t.wg.Wait()
+		return err
+	}
+	go t.run()
+	return nil
+}
+
+// fileTailer is the state of TailFiles, it is only used from the goroutine running it
+type fileTailer struct {
+	ctx      context.Context
+	out      Channel
+	patterns []string
+	format   *Format
+	replay   bool
+	errs     ErrorChannel
+
+	wg    sync.WaitGroup
+	files map[string]*tailedFile
+}
+
+type tailedFile struct {
+	info   os.FileInfo
+	cancel context.CancelFunc
+	// finish stops following the file, once the lines that are in it have been read
+	finish func()
+}
+
+func (t *fileTailer) run() {
+	if t.replay || t.onlyStdin() {
+		// Nothing else will be read once the inputs have ended, so don't look for new files
+		ended := make(chan struct{})
+		go func() {
+			t.wg.Wait()
+			close(ended)
+		}()
+		select {
+		case <-ended:
+		case <-t.ctx.Done():
+			<-ended
+		}
+		close(t.out)
+		return
+	}
+
+	clock := time.NewTicker(globInterval)
+	defer clock.Stop()
+	for {
+		select {
+		case <-clock.C:
+			t.scan(false)
+		case <-t.ctx.Done():
+			t.wg.Wait()
+			close(t.out)
+			return
+		}
+	}
+}
+
+// scan starts tailing the files that match the patterns and aren't being tailed yet, and stops
+// tailing files that no longer exist. When initial is true, errors are returned rather than
+// sent into errs and files are read from their end.
+func (t *fileTailer) scan(initial bool) error {
+	matched := make(map[string]bool)
+	for _, pattern := range t.patterns {
+		if pattern == Stdin {
+			if initial {
+				if err := t.forwardStdin(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		paths := []string{pattern}
+		if isGlob(pattern) {
+			paths, _ = filepath.Glob(pattern) // the pattern has already been checked
+		}
+		for _, path := range paths {
+			matched[path] = true
+			info, err := os.Stat(path)
+			if err != nil {
+				if initial {
+					return err
+				}
+				continue // it has been removed or it hasn't been created again yet
+			}
+			if f, ok := t.files[path]; ok {
+				if os.SameFile(f.info, info) {
+					continue
+				}
+				// The file has been replaced, read the new one from the start
+				t.retire(path)
+			}
+			if err := t.tail(path, initial); err != nil {
+				if initial {
+					return err
+				}
+				t.errs.Send(t.ctx, &Error{Stage: StageRead, Reason: ReasonRead, Raw: path, Err: err})
+			}
+		}
+	}
+
+	for path := range t.files {
+		if path == Stdin {
+			continue
+		}
+		if _, err := os.Stat(path); !matched[path] || err != nil {
+			t.retire(path)
+		}
+	}
+	return nil
+}
+
+// retire stops tailing the file at path, which has been replaced or removed. It is followed for
+// drainDelay, since a writer that has it open can still be adding to it, and then read to its end.
+func (t *fileTailer) retire(path string) {
+	f := t.files[path]
+	delete(t.files, path)
+	time.AfterFunc(drainDelay, f.finish)
+}
+
+// replayFiles starts reading the files that match the patterns from their beginning. The series of
+// rotated logs are read one file after the other, oldest first, with the name of the series as the
+// source. Named pipes are followed, since they can have more than one writer.
+func (t *fileTailer) replayFiles() error {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range t.patterns {
+		if pattern == Stdin {
+			if err := t.forwardStdin(); err != nil {
+				return err
+			}
+			continue
+		}
+		matches := []string{pattern}
+		if isGlob(pattern) {
+			matches, _ = filepath.Glob(pattern) // the pattern has already been checked
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if info.Mode()&os.ModeNamedPipe != 0 {
+				if err := t.tail(path, false); err != nil {
+					return err
+				}
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	names, series := rotationSeries(paths)
+	t.wg.Add(len(names))
+	for _, name := range names {
+		go func(name string, paths []string) {
+			defer t.wg.Done()
+			for _, path := range paths {
+				if err := t.replayFile(name, path); err != nil {
+					t.errs.Send(t.ctx, &Error{Stage: StageRead, Reason: ReasonRead, Raw: path, Err: err})
+				}
+				if t.ctx.Err() != nil {
+					return
+				}
+			}
+		}(name, series[name])
+	}
+	return nil
+}
+
+// replayFile reads the file at path from its beginning, decompressing it if it's compressed
+func (t *fileTailer) replayFile(source, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := Decompress(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	t.copy(t.ctx, source, r, closedChan)
+	return nil
+}
+
+// forwardStdin starts reading standard input until it is closed, decompressing it if it's compressed
+func (t *fileTailer) forwardStdin() error {
+	r, err := Decompress(stdin)
+	if err != nil {
+		return err
+	}
+	t.forward(Stdin, "stdin", nil, r, false)
+	return nil
+}
+
+// tail starts tailing the file at path, from its end if fromEnd is true.
+// Compressed files, such as rotated logs, are skipped since they aren't appended to.
+func (t *fileTailer) tail(path string, fromEnd bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	pipe := info.Mode()&os.ModeNamedPipe != 0
+	flag := os.O_RDONLY
+	if pipe {
+		// Opening a named pipe blocks until there's a writer, unless it's non-blocking.
+		// Until then reading it returns io.EOF, so it is followed even when replaying.
+		flag |= syscall.O_NONBLOCK
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return err
+	}
+	if info, err = file.Stat(); err == nil && info.Mode().IsRegular() {
+		header := make([]byte, len(zstdMagic))
+		n, _ := file.ReadAt(header, 0)
+		if compression(header[:n]) != "" {
+			file.Close()
+			// Remember the file so it isn't opened again at the next scan
+			t.files[path] = &tailedFile{info: info, cancel: func() {}, finish: func() {}}
+			return nil
+		}
+		if fromEnd {
+			_, err = file.Seek(0, io.SeekEnd)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	t.forward(path, path, info, file, true)
+	return nil
+}
+
+// forward starts reading the Lines from the reader and sending them into the output with the
+// source, following the reader if follow is true until it is finished. The reader is closed when it stops.
+func (t *fileTailer) forward(path, source string, info os.FileInfo, r io.ReadCloser, follow bool) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	var stop <-chan struct{} = closedChan
+	finish := func() {}
+	if follow {
+		finished := make(chan struct{})
+		var once sync.Once
+		stop, finish = finished, func() { once.Do(func() { close(finished) }) }
+	}
+	t.files[path] = &tailedFile{info: info, cancel: cancel, finish: finish}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer cancel()
+		defer r.Close()
+		t.copy(ctx, source, r, stop)
+	}()
+}
+
+// copy sends the Lines from the reader into the output with the source until the context is
+// cancelled, or until the end of the reader once stop is closed
+func (t *fileTailer) copy(ctx context.Context, source string, r io.Reader, stop <-chan struct{}) {
+	lines := make(Channel)
+	go lines.read(ctx, bufio.NewReader(r), t.format, stop, t.errs)
+	for line := range lines {
+		line.Source = source
+		select {
+		case t.out <- line:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// onlyStdin returns true if standard input is the only pattern
+func (t *fileTailer) onlyStdin() bool {
+	for _, pattern := range t.patterns {
+		if pattern != Stdin {
+			return false
+		}
+	}
+	return true
+}
+
+// isGlob returns true if the pattern has any of the special characters of filepath.Match
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
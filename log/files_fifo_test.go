@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestChannelTailFilesNamedPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting doesn't wait for a writer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lc := make(Channel)
+	if err := lc.TailFiles(ctx, []string{path}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every writer's lines are read, the pipe isn't closed when one of them goes away
+	for i := 0; i < 2; i++ {
+		appendLine(t, path)
+		if line := receiveLine(t, lc); line.Source != path {
+			t.Errorf("bad line from the pipe: %+v", line)
+		}
+	}
+	cancel()
+	for range lc {
+	}
+}
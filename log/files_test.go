@@ -0,0 +1,177 @@
+package log
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFileLine = `127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123` + "\n"
+
+func appendLine(t *testing.T, path string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(testFileLine); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveLine(t *testing.T, lc Channel) Line {
+	select {
+	case line := <-lc:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a line")
+	}
+	return Line{}
+}
+
+func TestChannelTailFiles(t *testing.T) {
+	defer func(d time.Duration) { globInterval = d }(globInterval)
+	globInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.access.log")
+	second := filepath.Join(dir, "second.access.log")
+	// The lines that are already in the file aren't read
+	appendLine(t, first)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := make(Channel)
+	if err := lc.TailFiles(ctx, []string{filepath.Join(dir, "*.access.log*")}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLine(t, first)
+	if line := receiveLine(t, lc); line.Source != first || line.UserID != "frank" {
+		t.Errorf("bad line from the first file: %+v", line)
+	}
+	// Files that are created later are picked up and read from the beginning, unless they're compressed
+	if err := ioutil.WriteFile(filepath.Join(dir, "first.access.log.1.gz"), gzipped(t, testFileLine), 0644); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(t, second)
+	if line := receiveLine(t, lc); line.Source != second {
+		t.Errorf("expected a line from the second file, got: %+v", line)
+	}
+
+	cancel()
+	for range lc {
+	}
+}
+
+func TestChannelTailFilesRotated(t *testing.T) {
+	defer func(d, drain time.Duration) { globInterval, drainDelay = d, drain }(globInterval, drainDelay)
+	globInterval, drainDelay = 10*time.Millisecond, 50*time.Millisecond
+
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	appendLine(t, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := make(Channel)
+	if err := lc.TailFiles(ctx, []string{path}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is rotated, and the writer adds to the old file before it reopens the log
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(t, path)
+	time.Sleep(5 * globInterval) // the new file is found
+	appendLine(t, path+".1")
+	for i := 0; i < 2; i++ {
+		if line := receiveLine(t, lc); line.Source != path {
+			t.Errorf("bad line: %+v", line)
+		}
+	}
+	select {
+	case line := <-lc:
+		t.Errorf("expected only the lines written since it started, got: %+v", line)
+	case <-time.After(5 * drainDelay):
+	}
+
+	cancel()
+	for range lc {
+	}
+}
+
+func TestChannelTailFilesErrors(t *testing.T) {
+	lc := make(Channel)
+	if err := lc.TailFiles(context.Background(), []string{"/does/not/exist.log"}, CommonFormat, nil); err == nil {
+		t.Error("expected an error for a path that doesn't exist")
+	}
+	if err := lc.TailFiles(context.Background(), []string{"/var/log/[.log"}, CommonFormat, nil); err == nil {
+		t.Error("expected an error for an invalid glob")
+	}
+	// A glob that doesn't match anything yet is fine
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := lc.TailFiles(ctx, []string{"/does/not/exist/*.log"}, CommonFormat, nil); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	for range lc {
+	}
+}
+
+func TestChannelTailFilesStdin(t *testing.T) {
+	defer func(r io.Reader) { stdin = r }(stdin)
+	// The last line doesn't need a newline, and the Channel is closed when stdin is
+	stdin = strings.NewReader(testFileLine + strings.TrimSpace(testFileLine))
+
+	lc := make(Channel)
+	if err := lc.TailFiles(context.Background(), []string{Stdin}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for line := range lc {
+		count++
+		if line.Source != "stdin" {
+			t.Errorf("bad source: %q", line.Source)
+		}
+	}
+	if count != 2 {
+		t.Errorf("expected 2 lines, got: %d", count)
+	}
+}
+
+func TestChannelReplayFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	appendLine(t, path)
+	appendLine(t, path)
+
+	// The lines already in the file are read, and the Channel is closed at the end of the file
+	lc := make(Channel)
+	if err := lc.ReplayFiles(context.Background(), []string{path}, CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range lc {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected 2 lines, got: %d", count)
+	}
+}
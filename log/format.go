@@ -0,0 +1,140 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format parses raw log lines that are written in a specific format into Lines.
+// The regular expression of a Format uses named groups for the fields of the Line:
+// ip, identity, user, date, request, status, size, referer, agent and latency.
+type Format struct {
+	Name string
+	re   *regexp.Regexp
+}
+
+var (
+	// CommonFormat is the Common Log Format (https://en.wikipedia.org/wiki/Common_Log_Format).
+	// Lines with extra fields at the end, such as the Combined Log Format, are also accepted.
+	// FIXME: Add tests specifically for this regex
+	CommonFormat = NewFormat("common", `([^ ]*) ([^ ]*) ([^ ]*) (?:-|\[([^\]]*)\]) \"(.*)\" (-|[0-9]{3}) ([0-9]*)`,
+		"ip", "identity", "user", "date", "request", "status", "size")
+	// CombinedFormat is the Combined Log Format used by default by Apache and nginx,
+	// which adds the referer and user agent to the Common Log Format
+	CombinedFormat = NewFormat("combined", `^(\S*) (\S*) (\S*) (?:-|\[([^\]]*)\]) "(.*)" (-|[0-9]{3}) (-|[0-9]*) "([^"]*)" "([^"]*)"`,
+		"ip", "identity", "user", "date", "request", "status", "size", "referer", "agent")
+	// TimedFormat is the Combined Log Format followed by the time taken to serve the request in
+	// seconds, such as nginx's $request_time
+	TimedFormat = NewFormat("timed", `^(\S*) (\S*) (\S*) (?:-|\[([^\]]*)\]) "(.*)" (-|[0-9]{3}) (-|[0-9]*) "([^"]*)" "([^"]*)" (-|[0-9]*\.?[0-9]+)`,
+		"ip", "identity", "user", "date", "request", "status", "size", "referer", "agent", "latency")
+)
+
+// formats are the Formats that can be looked up by name
+var formats = map[string]*Format{
+	CommonFormat.Name:   CommonFormat,
+	CombinedFormat.Name: CombinedFormat,
+	TimedFormat.Name:    TimedFormat,
+}
+
+// NewFormat returns a Format that parses lines with the regular expression, where fields
+// are the names of the capturing groups in order. It panics if the expression doesn't compile.
+func NewFormat(name, expr string, fields ...string) *Format {
+	re := regexp.MustCompile(expr)
+	if re.NumSubexp() != len(fields) {
+		panic(fmt.Sprintf("log: format %q has %d groups but %d fields", name, re.NumSubexp(), len(fields)))
+	}
+	return &Format{Name: name, re: regexp.MustCompile(nameGroups(expr, fields))}
+}
+
+// nameGroups turns the capturing groups of expr into named groups, so the fields can be
+// looked up by name when parsing
+func nameGroups(expr string, fields []string) string {
+	var b strings.Builder
+	group := 0
+	for i := 0; i < len(expr); i++ {
+		b.WriteByte(expr[i])
+		switch {
+		case expr[i] == '\\':
+			if i+1 < len(expr) {
+				i++
+				b.WriteByte(expr[i])
+			}
+		case expr[i] == '(' && (i+1 >= len(expr) || expr[i+1] != '?'):
+			b.WriteString("?P<" + fields[group] + ">")
+			group++
+		}
+	}
+	return b.String()
+}
+
+// FormatByName returns the Format with the name, "common", "combined" or "timed"
+func FormatByName(name string) (*Format, error) {
+	if f, ok := formats[name]; ok {
+		return f, nil
+	}
+	names := make([]string, 0, len(formats))
+	for n := range formats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown log format %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Parse returns a Line from a raw string.
+// If the line can't be parsed, the error is an *Error with the reason it couldn't be parsed.
+func (f *Format) Parse(raw string) (Line, error) {
+	line := Line{}
+	parsed := f.re.FindStringSubmatch(raw)
+	if parsed == nil {
+		return line, &Error{Stage: StageParse, Reason: ReasonNoMatch, Raw: raw, Err: ErrInvalidLine}
+	}
+
+	var date string
+	for i, name := range f.re.SubexpNames() {
+		value := parsed[i]
+		switch name {
+		case "ip":
+			line.IPAddress = value
+		case "identity":
+			line.Identity = value
+		case "user":
+			line.UserID = value
+		case "request":
+			line.Request = *NewLineRequest(value)
+		case "status":
+			if statusCode, err := strconv.Atoi(value); err == nil {
+				line.StatusCode = statusCode
+			}
+		case "size":
+			if size, err := strconv.Atoi(value); err == nil {
+				line.Size = size
+			}
+		case "referer":
+			line.Referer = value
+		case "agent":
+			line.UserAgent = value
+		case "latency":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				line.Latency = time.Duration(seconds * float64(time.Second))
+				line.HasLatency = true
+			}
+		case "date":
+			date = value
+		}
+	}
+
+	// The date is optional, but if there is one it has to be valid: a date in another format means
+	// the lines don't match the format, and they would be left out of every window without one
+	if date != "" {
+		reqTime, err := time.ParseInLocation(dateFormat, date, time.UTC)
+		if err != nil {
+			return line, &Error{Stage: StageParse, Reason: ReasonInvalidDate, Raw: raw, Err: err}
+		}
+		line.Date = reqTime
+	}
+	return line, nil
+}
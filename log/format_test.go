@@ -0,0 +1,36 @@
+package log
+
+import (
+	"testing"
+)
+
+func TestCombinedFormat(t *testing.T) {
+	raw := `127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report/daily HTTP/1.0" 200 123 "http://example.com/start" "Mozilla/5.0 (X11; Linux x86_64)"`
+	line, err := CombinedFormat.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line.UserID != "frank" || line.Request.URL != "/report/daily" || line.StatusCode != 200 || line.Size != 123 {
+		t.Errorf("bad line: %+v", line)
+	}
+	if line.Referer != "http://example.com/start" || line.UserAgent != "Mozilla/5.0 (X11; Linux x86_64)" {
+		t.Errorf("bad referer or user agent: %q %q", line.Referer, line.UserAgent)
+	}
+
+	// The common format ignores the extra fields, the combined format requires them
+	if line, err := CommonFormat.Parse(raw); err != nil || line.Referer != "" || line.Size != 123 {
+		t.Errorf("expected the common format to parse the line without the extra fields: %+v %v", line, err)
+	}
+	if _, err := CombinedFormat.Parse(`127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`); err == nil {
+		t.Error("expected an error for a line without a referer and user agent")
+	}
+}
+
+func TestFormatByName(t *testing.T) {
+	if f, err := FormatByName("combined"); err != nil || f != CombinedFormat {
+		t.Errorf("expected the combined format, got: %v %v", f, err)
+	}
+	if _, err := FormatByName("apache"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -2,14 +2,15 @@ package log
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/caitlin615/logmonitor/counter"
@@ -18,22 +19,149 @@ import (
 // Channel is a channel that accepts LogLines
 type Channel chan Line
 
-// Tail will listen on the reader and send all Lines into the Channel
+// pollInterval is how long Tail waits for more data after reaching the end of the reader
+const pollInterval = 100 * time.Millisecond
+
+// Tail will listen on the reader and send all Lines in the CommonFormat into the Channel,
+// see TailFormat.
+// This should be run within a goroutine
+func (lc Channel) Tail(ctx context.Context, reader *bufio.Reader, errs ErrorChannel) {
+	lc.TailFormat(ctx, reader, CommonFormat, errs)
+}
+
+// TailFormat will listen on the reader and send all Lines in the format into the Channel until
+// the context is cancelled, then it closes the Channel. When it reaches the end of the reader it
+// waits for more data to be written, so it can follow a file that is being appended to.
 // This should be run within a goroutine
-func (lc Channel) Tail(reader *bufio.Reader) {
+//
+// Read errors and lines that can't be parsed are sent into errs as an *Error, errs can be nil
+// to discard them. TailFormat stops at the first read error other than io.EOF.
+func (lc Channel) TailFormat(ctx context.Context, reader *bufio.Reader, format *Format, errs ErrorChannel) {
+	lc.read(ctx, reader, format, nil, errs)
+}
+
+// closedChan is a closed channel, for reading that stops at the end of the reader
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// read sends the Lines from the reader into the Channel. It waits for more data at the end of
+// the reader until stop is closed, after which it stops at the end of the reader.
+// A nil stop is never closed, so the reader is followed until the context is cancelled.
+func (lc Channel) read(ctx context.Context, reader *bufio.Reader, format *Format, stop <-chan struct{}, errs ErrorChannel) {
+	defer close(lc)
+	partial := "" // a line that has been read up to the end of the reader but isn't complete yet
 	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
 		line, err := reader.ReadString('\n') // TODO: Use reader.Readline()
+		if err == io.EOF {
+			select {
+			case <-stop:
+				// The last line doesn't need to end with a newline
+				if partial+line == "" {
+					return
+				}
+			default:
+				partial += line
+				select {
+				case <-ctx.Done():
+					return
+				case <-stop:
+				case <-time.After(pollInterval):
+				}
+				continue
+			}
+		}
 		if err != nil && err != io.EOF {
+			errs.Send(ctx, &Error{Stage: StageRead, Reason: ReasonRead, Err: err})
+			return
+		}
+		line = strings.Trim(partial+line, "\n")
+		partial = ""
+		if len(line) == 0 {
+			continue
+		}
+		logLine, err := format.Parse(line)
+		if err != nil {
+			errs.Send(ctx, err)
 			continue
 		}
-		line = strings.Trim(line, "\n")
-		if len(line) > 0 {
-			if logLine, err := NewLine(line); err == nil {
-				// FIXME: Not sure why this needs to be in a goroutine, but otherwise it blocks
-				// the for loop from continuing
-				go logLine.Send(lc)
+		select {
+		case lc <- logLine:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Fanout returns n Channels that each receive every Line sent into the Channel, so multiple
+// listeners can listen to the same log. A Line is sent to all of the Channels before the next
+// one is received, so a slow listener holds up the others. The returned Channels are closed
+// once the Channel is closed, or once the context is cancelled, after which the Lines sent
+// into the Channel are discarded until it is closed so its sender isn't blocked.
+// This starts a goroutine, so it should be called before anything is sent into the Channel.
+func (lc Channel) Fanout(ctx context.Context, n int) []Channel {
+	outs := make([]Channel, n)
+	for i := range outs {
+		outs[i] = make(Channel)
+	}
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for line := range lc {
+			for _, out := range outs {
+				select {
+				case out <- line:
+				case <-ctx.Done():
+					lc.discard()
+					return
+				}
 			}
 		}
+	}()
+	return outs
+}
+
+// Merge returns a Channel that receives every Line sent into the Channels, so multiple inputs
+// can be listened to as one. It is closed once all of the Channels are closed. Once the context
+// is cancelled, the Lines sent into the Channels are discarded so their senders aren't blocked.
+func Merge(ctx context.Context, chans ...Channel) Channel {
+	out := make(Channel)
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, lc := range chans {
+		go func(lc Channel) {
+			defer wg.Done()
+			for line := range lc {
+				select {
+				case out <- line:
+				case <-ctx.Done():
+					lc.discard()
+					return
+				}
+			}
+		}(lc)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// discard receives the Lines sent into the Channel until it is closed
+func (lc Channel) discard() {
+	for range lc {
 	}
 }
 
@@ -62,40 +190,24 @@ type Line struct {
 	Request    LineRequest
 	StatusCode int
 	Size       int
+	// Referer and UserAgent are only set for formats that include them, such as CombinedFormat
+	Referer   string
+	UserAgent string
+	// Source is where the line was read from, such as the path of the file from TailFiles
+	Source string
+	// Latency is the time it took to serve the request, it is only known if HasLatency is set
+	// by a format that includes it, such as TimedFormat
+	Latency    time.Duration
+	HasLatency bool
 }
 
-// FIXME: Add tests specifically for this regex
-var re = regexp.MustCompile(`([^ ]*) ([^ ]*) ([^ ]*) (?:-|\[([^\]]*)\]) \"(.*)\" (-|[0-9]{3}) ([0-9]*)`)
-
 // ErrInvalidLine is the error if the line supplied did not match the regex
 var ErrInvalidLine = errors.New("Invalid Line")
 
-// NewLine returns a Line from a raw string
+// NewLine returns a Line from a raw string in the CommonFormat.
+// If the line can't be parsed, the error is an *Error with the reason it couldn't be parsed.
 func NewLine(raw string) (Line, error) {
-	line := Line{}
-	parsed := re.FindStringSubmatch(raw)
-	if len(parsed) != 8 {
-		return line, ErrInvalidLine
-	}
-	req := NewLineRequest(parsed[5])
-	line.IPAddress = parsed[1]
-	line.Identity = parsed[2]
-	line.UserID = parsed[3]
-	line.Request = *req
-
-	if statusCode, err := strconv.Atoi(parsed[6]); err == nil {
-		line.StatusCode = statusCode
-	}
-
-	if size, err := strconv.Atoi(parsed[7]); err == nil {
-		line.Size = size
-	}
-
-	if reqTime, err := time.ParseInLocation(dateFormat, parsed[4], time.UTC); err == nil {
-		line.Date = reqTime
-	}
-
-	return line, nil
+	return CommonFormat.Parse(raw)
 }
 
 func (l *Line) String() string {
@@ -125,17 +237,30 @@ func (l *Line) Send(c Channel) {
 // Section returns what's before the second '/' in the URL's path.
 // For example, the section for "http://my.site.com/pages/create” is "http://my.site.com/pages".
 func (lr *LineRequest) Section() (string, error) {
+	return lr.SectionAt(1)
+}
+
+// SectionAt returns the first depth segments of the URL's path, so at a depth of 2 the section for
+// "http://my.site.com/pages/create/new" is "http://my.site.com/pages/create". Paths with fewer
+// segments than the depth are their own section.
+func (lr *LineRequest) SectionAt(depth int) (string, error) {
+	if depth < 1 {
+		return "", fmt.Errorf("section depth should be at least 1, got %d", depth)
+	}
 	u, err := url.Parse(lr.URL)
 	if err != nil {
 		return "", err
 	}
 
 	paths := strings.Split(u.EscapedPath(), "/")
-	if len(paths) < 1 {
+	if len(paths) < 2 {
 		// No path provided in the url, so we can't determine the section
 		return "", fmt.Errorf("No path provided in the url, so the section cannot be determined. URL = %v", u)
 	}
-	u.Path = "/" + paths[1]
+	if depth+1 < len(paths) {
+		paths = paths[:depth+1]
+	}
+	u.Path = strings.Join(paths, "/")
 	return u.String(), nil
 }
 
@@ -175,18 +300,18 @@ func (ll *Lines) MostActiveUser() (string, int) {
 func (ll *Lines) ErrorCode4XX() int {
 	count := 0
 	for _, line := range *ll {
-		if line.StatusCode >= 400 && line.StatusCode < 500 {
+		if line.IsClientError() {
 			count++
 		}
 	}
 	return count
 }
 
-// ErrorCode5XX returns the number of logs that have an error code 5xx
+// ErrorCode5XX returns the number of logs that have an error code 5xx, codes over 599 aren't counted
 func (ll *Lines) ErrorCode5XX() int {
 	count := 0
 	for _, line := range *ll {
-		if line.StatusCode >= 500 {
+		if line.IsServerError() {
 			count++
 		}
 	}
@@ -214,6 +339,98 @@ func (ll *Lines) SectionWithMostHits() (string, int) {
 	return top.Key, top.Value
 }
 
+// KeyFunc returns the key that a Line is counted under, or false if the Line should be skipped
+type KeyFunc func(Line) (string, bool)
+
+var (
+	// BySection counts Lines by the section of the request
+	BySection = KeyFunc(func(l Line) (string, bool) {
+		section, err := l.Request.Section()
+		return section, err == nil
+	})
+	// ByUser counts Lines by the UserID
+	ByUser = KeyFunc(func(l Line) (string, bool) {
+		return l.UserID, true
+	})
+	// ByIPAddress counts Lines by the client IP address
+	ByIPAddress = KeyFunc(func(l Line) (string, bool) {
+		return l.IPAddress, true
+	})
+	// BySource counts Lines by where they were read from, skipping Lines without a Source
+	BySource = KeyFunc(func(l Line) (string, bool) {
+		return l.Source, l.Source != ""
+	})
+	// ByMethod counts Lines by the method of the request, such as "GET"
+	ByMethod = KeyFunc(func(l Line) (string, bool) {
+		return l.Request.Method, true
+	})
+	// ByStatusCode counts Lines by the status code, skipping Lines without one
+	ByStatusCode = KeyFunc(func(l Line) (string, bool) {
+		return strconv.Itoa(l.StatusCode), l.StatusCode != 0
+	})
+	// ByStatusClass counts Lines by the class of the status code, such as "2xx"
+	ByStatusClass = KeyFunc(func(l Line) (string, bool) {
+		return l.StatusClass(), true
+	})
+)
+
+// BySectionDepth counts Lines by the section of the request at the depth, see LineRequest.SectionAt
+func BySectionDepth(depth int) KeyFunc {
+	return func(l Line) (string, bool) {
+		section, err := l.Request.SectionAt(depth)
+		return section, err == nil
+	}
+}
+
+// StatusClass returns the class of the status code, "1xx" through "5xx",
+// or "other" if the status code is missing or not a valid HTTP status code
+func (l *Line) StatusClass() string {
+	if l.StatusCode < 100 || l.StatusCode > 599 {
+		return "other"
+	}
+	return fmt.Sprintf("%dxx", l.StatusCode/100)
+}
+
+// Count returns the number of Lines for each key, sorted in descending order by the
+// number of Lines. Keys with the same count keep the order they were first seen in.
+func (ll *Lines) Count(key KeyFunc) []counter.Dict {
+	m := counter.New()
+	for _, line := range *ll {
+		if k, ok := key(line); ok {
+			m.Increment(k)
+		}
+	}
+	m.SortByFunc = counter.SortDesc
+	sort.Stable(m)
+	return m.Dict
+}
+
+// Top returns at most n of the keys with the most Lines
+func (ll *Lines) Top(n int, key KeyFunc) []counter.Dict {
+	top := ll.Count(key)
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// HitsPerSecond returns the number of Lines for every second between start and end, based on
+// the date of the Line. Lines outside of the range are not counted.
+func (ll *Lines) HitsPerSecond(start, end time.Time) []int {
+	start = start.Truncate(time.Second)
+	if !end.After(start) {
+		return nil
+	}
+	hits := make([]int, int((end.Sub(start)+time.Second-1)/time.Second))
+	for _, line := range *ll {
+		if line.Date.Before(start) || !line.Date.Before(end) {
+			continue
+		}
+		hits[int(line.Date.Sub(start)/time.Second)]++
+	}
+	return hits
+}
+
 // Clear ...
 func (ll *Lines) Clear() {
 	empty := Lines{}
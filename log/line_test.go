@@ -1,7 +1,13 @@
 package log
 
-import "testing"
-import "math/rand"
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
 
 func init() {
 	// Typically a non-fixed seed should be used, such as time.Now().UnixNano().
@@ -26,6 +32,22 @@ func TestNewLogLine(t *testing.T) {
 	}
 }
 
+func TestLineRequestSectionAt(t *testing.T) {
+	lr := LineRequest{URL: "http://my.site.com/pages/create/new"}
+	for depth, expected := range []string{"", "http://my.site.com/pages", "http://my.site.com/pages/create", "http://my.site.com/pages/create/new", "http://my.site.com/pages/create/new"} {
+		section, err := lr.SectionAt(depth)
+		if depth == 0 {
+			if err == nil {
+				t.Error("expected an error for a depth of 0")
+			}
+			continue
+		}
+		if err != nil || section != expected {
+			t.Errorf("expected the section at depth %d to be %s, got: %s %v", depth, expected, section, err)
+		}
+	}
+}
+
 func TestLinesClearBefore(t *testing.T) {
 	lines := Lines{}
 	for i := 0; i < 6; i++ {
@@ -52,3 +74,98 @@ func TestLinesMostActiveUser(t *testing.T) {
 		t.Errorf("incorrect MostActiveUser: %s %d", user, hits)
 	}
 }
+
+func TestLinesHitsPerSecond(t *testing.T) {
+	start := time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC)
+	lines := Lines{}
+	for _, offset := range []time.Duration{0, 500 * time.Millisecond, 2 * time.Second, 5 * time.Second} {
+		line := RandomLine()
+		line.Date = start.Add(offset)
+		lines = append(lines, line)
+	}
+
+	hits := lines.HitsPerSecond(start, start.Add(3*time.Second))
+	if len(hits) != 3 || hits[0] != 2 || hits[1] != 0 || hits[2] != 1 {
+		t.Errorf("bad hits per second: %v", hits)
+	}
+}
+
+func TestLineStatusClass(t *testing.T) {
+	for code, expected := range map[int]string{200: "2xx", 404: "4xx", 599: "5xx", 0: "other", 999: "other"} {
+		line := Line{StatusCode: code}
+		if class := line.StatusClass(); class != expected {
+			t.Errorf("bad status class for %d: %s", code, class)
+		}
+	}
+}
+
+func TestChannelTail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	lc := make(Channel)
+	go lc.Tail(ctx, bufio.NewReader(r), nil)
+
+	go io.WriteString(w, `127.0.0.1 - james [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 1234`+"\n")
+	line := <-lc
+	if line.UserID != "james" {
+		t.Errorf("bad line: %s", line.String())
+	}
+
+	cancel()
+	w.Close()
+	if _, ok := <-lc; ok {
+		t.Error("expected the channel to be closed after the context is cancelled")
+	}
+}
+
+func TestChannelFanout(t *testing.T) {
+	lc := make(Channel)
+	outs := lc.Fanout(context.Background(), 3)
+	go func() {
+		for i := 0; i < 10; i++ {
+			lc <- RandomLine()
+		}
+		close(lc)
+	}()
+
+	counts := make(chan int)
+	for _, out := range outs {
+		go func(out Channel) {
+			n := 0
+			for range out {
+				n++
+			}
+			counts <- n
+		}(out)
+	}
+	for range outs {
+		if n := <-counts; n != 10 {
+			t.Errorf("expected every channel to receive 10 lines, got: %d", n)
+		}
+	}
+}
+
+func TestChannelFanoutCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := make(Channel)
+	outs := lc.Fanout(ctx, 2)
+	merged := Merge(ctx, outs...)
+	cancel()
+
+	// Nothing receives from the merged Channel, but sending doesn't block once the context is cancelled
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			lc <- Line{}
+		}
+		close(lc)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out sending lines after the context was cancelled")
+	}
+	for range merged {
+	}
+}
@@ -0,0 +1,149 @@
+package log
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholders that Router.Route collapses the segments of a path into
+const (
+	PlaceholderID   = ":id"
+	PlaceholderUUID = ":uuid"
+	PlaceholderHash = ":hash"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// Hashes are at least 8 hex digits with at least one digit, so words such as "deadbeef" are kept
+	hashSegment = regexp.MustCompile(`^[0-9a-fA-F]*[0-9][0-9a-fA-F]*$`)
+)
+
+// Router turns the URL of a request into a route, the endpoint the request was for, so requests
+// such as "/users/123" and "/users/456" can be counted together as "/users/:id".
+// A Router is safe to use from multiple goroutines.
+type Router struct {
+	routes   []route
+	collapse bool
+}
+
+// route is a pattern split into its segments
+type route struct {
+	pattern  string
+	segments []string
+}
+
+// DefaultRouter is the Router without patterns that collapses IDs
+var DefaultRouter = &Router{collapse: true}
+
+// NewRouter returns a Router with the patterns, which are tried in order. A pattern is a path
+// such as "/api/v1/orders/:id", where a segment starting with ':' matches any one segment
+// and a last segment of "*" matches the rest of the path, including nothing.
+// If collapse is true, the paths that don't match a pattern have their numeric, UUID and
+// hex hash segments replaced by ":id", ":uuid" and ":hash".
+func NewRouter(patterns []string, collapse bool) (*Router, error) {
+	r := &Router{collapse: collapse}
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("route %q should start with /", pattern)
+		}
+		segments := splitPath(pattern)
+		for i, segment := range segments {
+			if segment == "*" && i != len(segments)-1 {
+				return nil, fmt.Errorf("route %q can only have * as its last segment", pattern)
+			}
+			if segment == ":" {
+				return nil, fmt.Errorf("route %q has a placeholder without a name", pattern)
+			}
+		}
+		r.routes = append(r.routes, route{pattern: pattern, segments: segments})
+	}
+	return r, nil
+}
+
+// Route returns the first pattern that matches the path of the request, or the path if none
+// of them do, with its IDs collapsed if the Router collapses them. The host and query of
+// the URL are dropped, so "http://my.site.com/users/123?page=2" is routed to "/users/:id".
+func (r *Router) Route(lr *LineRequest) (string, error) {
+	u, err := url.Parse(lr.URL)
+	if err != nil {
+		return "", err
+	}
+	segments := splitPath(u.EscapedPath())
+	if pattern, ok := r.match(segments); ok {
+		return pattern, nil
+	}
+	if r.collapse {
+		for i, segment := range segments {
+			segments[i] = collapseSegment(segment)
+		}
+	}
+	return "/" + strings.Join(segments, "/"), nil
+}
+
+// Match returns the first pattern that matches the path of the request, or false if none do
+func (r *Router) Match(lr *LineRequest) (string, bool) {
+	u, err := url.Parse(lr.URL)
+	if err != nil {
+		return "", false
+	}
+	return r.match(splitPath(u.EscapedPath()))
+}
+
+// Key counts Lines by their route, it can be used as a KeyFunc
+func (r *Router) Key(l Line) (string, bool) {
+	route, err := r.Route(&l.Request)
+	return route, err == nil
+}
+
+func (r *Router) match(segments []string) (string, bool) {
+	for _, route := range r.routes {
+		if route.matches(segments) {
+			return route.pattern, true
+		}
+	}
+	return "", false
+}
+
+func (rt route) matches(segments []string) bool {
+	for i, pattern := range rt.segments {
+		if pattern == "*" {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(pattern, ":") && pattern != segments[i] {
+			return false
+		}
+	}
+	return len(segments) == len(rt.segments)
+}
+
+// splitPath returns the segments of the path, ignoring the slashes at its start and end
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// collapseSegment returns the placeholder for a segment of a path that's an ID,
+// or the segment if it isn't one
+func collapseSegment(segment string) string {
+	switch {
+	case numericSegment.MatchString(segment):
+		return PlaceholderID
+	case uuidSegment.MatchString(segment):
+		return PlaceholderUUID
+	case len(segment) >= 8 && hashSegment.MatchString(segment):
+		return PlaceholderHash
+	}
+	return segment
+}
+
+// ByRoute counts Lines by the route of the request with the DefaultRouter
+var ByRoute = KeyFunc(DefaultRouter.Key)
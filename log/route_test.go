@@ -0,0 +1,66 @@
+package log
+
+import (
+	"testing"
+)
+
+func TestRouterRoute(t *testing.T) {
+	router, err := NewRouter([]string{"/api/v1/orders/:id", "/static/*"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for url, expected := range map[string]string{
+		"/api/v1/orders/123": "/api/v1/orders/:id",
+		"http://www.example.com/api/v1/orders/abc?expand=true": "/api/v1/orders/:id",
+		"/api/v1/orders/123/items":                             "/api/v1/orders/:id/items",
+		"/static/css/site.css":                                 "/static/*",
+		"/static":                                              "/static/*",
+		"/users/123/":                                          "/users/:id",
+		"/users/6ba7b810-9dad-11d1-80b4-00c04fd430c8/avatar": "/users/:uuid/avatar",
+		"/blobs/9e107d9d372bb6826bd81d3542a419d6":            "/blobs/:hash",
+		"/blobs/deadbeef": "/blobs/deadbeef",
+		"/v2/search":      "/v2/search",
+		"/":               "/",
+	} {
+		route, err := router.Route(&LineRequest{URL: url})
+		if err != nil {
+			t.Errorf("%s: %v", url, err)
+		}
+		if route != expected {
+			t.Errorf("expected %s to be routed to %s, got: %s", url, expected, route)
+		}
+	}
+
+	// Without collapsing, paths that don't match a pattern are kept as they are
+	router, err = NewRouter(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route, _ := router.Route(&LineRequest{URL: "/users/123"}); route != "/users/123" {
+		t.Errorf("expected the path to be kept, got: %s", route)
+	}
+	if _, err := router.Route(&LineRequest{URL: "%zz"}); err == nil {
+		t.Error("expected an error routing an invalid URL")
+	}
+}
+
+func TestRouterMatch(t *testing.T) {
+	router, err := NewRouter([]string{"/users/:id"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pattern, ok := router.Match(&LineRequest{URL: "/users/frank"}); !ok || pattern != "/users/:id" {
+		t.Errorf("expected a match, got: %s %v", pattern, ok)
+	}
+	if _, ok := router.Match(&LineRequest{URL: "/users/frank/posts"}); ok {
+		t.Error("expected no match for a longer path")
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	for _, pattern := range []string{"api/:id", "/api/*/orders", "/api/:"} {
+		if _, err := NewRouter([]string{pattern}, true); err == nil {
+			t.Errorf("expected an error for the route %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusFilter matches Lines by their status code and request method, such as only the
+// 5xx responses to POST requests. A StatusFilter is safe to use from multiple goroutines.
+type StatusFilter struct {
+	classes map[int]bool
+	codes   map[int]bool
+	methods map[string]bool
+}
+
+// NewStatusFilter returns a StatusFilter that matches Lines with one of the statuses and one
+// of the methods. A status is a class such as "5xx" or a code such as "404". Empty statuses
+// or methods match every Line, and methods are matched regardless of case.
+func NewStatusFilter(statuses, methods []string) (*StatusFilter, error) {
+	f := &StatusFilter{}
+	for _, s := range statuses {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+			if f.classes == nil {
+				f.classes = make(map[int]bool)
+			}
+			f.classes[int(s[0]-'0')] = true
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("status %q should be a class such as \"5xx\" or a code such as \"404\"", s)
+		}
+		if f.codes == nil {
+			f.codes = make(map[int]bool)
+		}
+		f.codes[code] = true
+	}
+	for _, m := range methods {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m == "" {
+			return nil, fmt.Errorf("method should not be empty")
+		}
+		if f.methods == nil {
+			f.methods = make(map[string]bool)
+		}
+		f.methods[m] = true
+	}
+	return f, nil
+}
+
+// Match returns whether the Line has one of the statuses and one of the methods
+func (f *StatusFilter) Match(l Line) bool {
+	if f.methods != nil && !f.methods[strings.ToUpper(l.Request.Method)] {
+		return false
+	}
+	if f.classes == nil && f.codes == nil {
+		return true
+	}
+	if l.StatusCode < 100 || l.StatusCode > 599 {
+		return false
+	}
+	return f.codes[l.StatusCode] || f.classes[l.StatusCode/100]
+}
+
+// IsClientError returns whether the status code is a 4xx client error
+func (l *Line) IsClientError() bool {
+	return l.StatusCode >= 400 && l.StatusCode < 500
+}
+
+// IsServerError returns whether the status code is a 5xx server error. Codes over 599
+// aren't valid HTTP status codes, so they aren't counted as server errors.
+func (l *Line) IsServerError() bool {
+	return l.StatusCode >= 500 && l.StatusCode < 600
+}
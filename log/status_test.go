@@ -0,0 +1,46 @@
+package log
+
+import "testing"
+
+func TestStatusFilter(t *testing.T) {
+	f, err := NewStatusFilter([]string{"5xx", "404"}, []string{"post"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method   string
+		code     int
+		expected bool
+	}{
+		{"POST", 503, true},
+		{"POST", 404, true},
+		{"POST", 403, false},
+		{"GET", 500, false},
+		{"POST", 999, false},
+	} {
+		line := Line{Request: LineRequest{Method: c.method, URL: "/api/user"}, StatusCode: c.code}
+		if f.Match(line) != c.expected {
+			t.Errorf("expected %v for %s %d", c.expected, c.method, c.code)
+		}
+	}
+
+	all, _ := NewStatusFilter(nil, nil)
+	if !all.Match(Line{StatusCode: 999}) {
+		t.Error("expected an empty filter to match every line")
+	}
+	for _, status := range []string{"6xx", "abc", "99"} {
+		if _, err := NewStatusFilter([]string{status}, nil); err == nil {
+			t.Errorf("expected an error for status %q", status)
+		}
+	}
+}
+
+func TestLinesErrorCodes(t *testing.T) {
+	lines := Lines{{StatusCode: 404}, {StatusCode: 500}, {StatusCode: 599}, {StatusCode: 600}, {StatusCode: 999}}
+	if n := lines.ErrorCode4XX(); n != 1 {
+		t.Errorf("expected 1 4xx, got: %d", n)
+	}
+	if n := lines.ErrorCode5XX(); n != 2 {
+		t.Errorf("expected codes over 599 not to be counted as 5xx, got: %d", n)
+	}
+}
@@ -1,85 +1,316 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/caitlin615/logmonitor/config"
+	"github.com/caitlin615/logmonitor/dashboard"
+	"github.com/caitlin615/logmonitor/ingest"
 	"github.com/caitlin615/logmonitor/listeners"
 	"github.com/caitlin615/logmonitor/log"
+	"github.com/caitlin615/logmonitor/pipeline"
+	"github.com/caitlin615/logmonitor/server"
+	"github.com/caitlin615/logmonitor/syslog"
 )
 
-var logFilename = flag.String("filename", "/var/log/access.log", "Log filename to read from")
+var logFilename = flag.String("filename", "/var/log/access.log", "Log filenames to read from, separated by commas, or - for stdin. Globs such as /var/log/nginx/*.access.log also read the files created later")
+var syslogUDP = flag.String("syslog-udp", "", "Address to receive syslog messages on over UDP, such as :514 (disabled by default)")
+var syslogTCP = flag.String("syslog-tcp", "", "Address to receive syslog messages on over TCP, such as :514 (disabled by default)")
+var ingestLines = flag.Bool("ingest", false, "Accept lines POSTed to /api/ingest on the -http address, with the bearer token in INGEST_TOKEN if it is set")
+var replay = flag.Bool("replay", false, "Read the files from the beginning and stop at their end, rather than following them")
+var outputFormat = flag.String("output", "text", "Output format for reports: text or json (one JSON object per line). It can't be used with -config, set the format of the sinks instead")
+var showDashboard = flag.Bool("dashboard", false, "Show a full-screen dashboard instead of printing each report")
+var httpAddr = flag.String("http", "", "Address to serve the HTTP API and web dashboard on, such as :8080 (disabled by default)")
+var onError = flag.String("on-error", "warn", "What to do when a line can't be read or parsed: ignore, warn or fail")
+var configFilename = flag.String("config", "", "Configuration file declaring the input, listeners and sinks, instead of the defaults. It is reloaded on SIGHUP")
+var logFormat = flag.String("format", "common", "Format of the log lines: common, combined or timed")
+var sectionDepth = flag.Int("section-depth", 1, "Number of segments of the path in a section, such as 2 for /api/user. It can't be used with -config, set section_depth on the summary listeners instead")
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		if err := runAnalyze(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
 	flag.Parse()
 
-	alertReqPerSecondThreshold := mustParseInt(getEnvDefault("ALERT_REQ_PER_SECOND_THRESHOLD", "10"))
-
-	fmt.Println("Starting...")
+	alertReqPerSecondThreshold, err := parseIntEnv("ALERT_REQ_PER_SECOND_THRESHOLD", 10)
+	if err != nil {
+		fatal(err)
+	}
 
-	// Open the file and create a new buffer for reading the contents
-	logReader, roFile, err := NewLogReader(*logFilename)
+	cfg := defaultConfig(alertReqPerSecondThreshold, *sectionDepth, *outputFormat, !*showDashboard)
+	if *configFilename != "" {
+		// The configuration file declares the sinks and the listeners that these flags apply to
+		for _, name := range []string{"output", "section-depth"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-%s can't be used with -config, set it in the configuration file", name))
+			}
+		}
+		if cfg, err = config.Load(*configFilename); err != nil {
+			fatal(err)
+		}
+		applyInput(cfg.Input)
+	}
+	pipe, err := pipeline.New(cfg)
 	if err != nil {
-		panic(err)
+		fatal(err)
 	}
 
-	// Create a log listening channel and start listening to the log reader buffer
-	listenChan := make(log.Channel)
-	go listenChan.Tail(logReader)
+	errorPolicy, err := log.ParseErrorPolicy(*onError)
+	if err != nil {
+		fatal(err)
+	}
+	format, err := log.FormatByName(*logFormat)
+	if err != nil {
+		fatal(err)
+	}
+	// The files are only read with syslog if they are asked for
+	syslogEnabled := *syslogUDP != "" || *syslogTCP != ""
+	readingFiles := !(syslogEnabled || *ingestLines) || flagSet("filename")
+	if *ingestLines && *httpAddr == "" {
+		fatal(fmt.Errorf("-ingest needs -http for the address to accept lines on"))
+	}
+	filenames := strings.Split(*logFilename, ",")
+	for _, filename := range filenames {
+		if filename == log.Stdin && *showDashboard {
+			fatal(fmt.Errorf("the dashboard reads keys from stdin, so it can't be used with -filename %s", log.Stdin))
+		}
+	}
 
-	// TODO: Would be nice to have an error channel that these listeners can write to
-	// if they encounter an error and we can decide here to panic or continue
-	summary := listeners.NewSummaryListener()
-	summaryRecv := summary.Start(listenChan)
+	// Status messages go to stderr when the output is meant to be consumed by other tools
+	status := os.Stdout
+	if *outputFormat != "text" {
+		status = os.Stderr
+	}
+	fmt.Fprintln(status, "Starting...")
 
-	alert := listeners.NewAlertListener(int64(alertReqPerSecondThreshold))
-	alertRecv := alert.Start(listenChan)
+	// Cancelling the context stops the tail and the listeners. The listeners flush what they have
+	// and close their output channels, which ends the loop at the bottom of main.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// goroutine will listen for receives from both the alert and summary output channels
-	// and print whatever they get
+	// The reader, the parser and the listeners send their errors into errs, they are
+	// all counted and then ignored, printed or stop the program based on the policy
+	errs := make(log.ErrorChannel)
+	errorStats := log.NewErrorStats()
+	failed := make(chan error, 1)
 	go func() {
-		for {
-			select {
-			case in := <-summaryRecv:
-				fmt.Println(in)
-			case in := <-alertRecv:
-				fmt.Println(in)
+		for err := range errs {
+			errorStats.Add(err)
+			switch {
+			case errorPolicy == log.ErrorPolicyWarn && !*showDashboard:
+				fmt.Fprintln(os.Stderr, "warning:", err)
+			case errorPolicy == log.ErrorPolicyFail:
+				select {
+				case failed <- err:
+					cancel()
+				default:
+				}
 			}
 		}
 	}()
 
-	// Handle ctrl-c because the interrupt signal skips the deferred calls,
-	// make sure everything is closed down before exiting
+	// Start tailing the files from their end, or reading them from the beginning when replaying,
+	// and receiving syslog messages and lines pushed over HTTP. listenChan is closed when all of the inputs have ended.
+	var inputs []log.Channel
+	if readingFiles {
+		fileChan := make(log.Channel)
+		readFiles := fileChan.TailFiles
+		if *replay {
+			readFiles = fileChan.ReplayFiles
+		}
+		if err := readFiles(ctx, filenames, format, errs); err != nil {
+			fatal(err)
+		}
+		inputs = append(inputs, fileChan)
+	}
+	if syslogEnabled {
+		receiver, err := syslog.Listen(*syslogUDP, *syslogTCP, format)
+		if err != nil {
+			fatal(err)
+		}
+		syslogChan := make(log.Channel)
+		go receiver.Receive(ctx, syslogChan, errs)
+		inputs = append(inputs, syslogChan)
+		fmt.Fprintln(status, "Receiving syslog messages")
+	}
+	var ingestHandler *ingest.Handler
+	if *ingestLines {
+		ingestHandler = ingest.New(format, ingest.DefaultQueueSize, errs)
+		ingestHandler.Token = getEnvDefault("INGEST_TOKEN", "")
+		ingestChan := make(log.Channel)
+		go ingestHandler.Receive(ctx, ingestChan)
+		inputs = append(inputs, ingestChan)
+	}
+	listenChan := log.Merge(ctx, inputs...)
+
+	// The pipeline writes the reports of each listener to its sinks, events receives all
+	// of them and is closed once all of the listeners are stopped
+	events := pipe.Start(ctx, listenChan, errs)
+
+	if *httpAddr != "" {
+		api := server.New()
+		api.ErrorStats = errorStats
+		// The API is read-only, lines are pushed to a separate handler
+		mux := http.NewServeMux()
+		mux.Handle("/", api)
+		if ingestHandler != nil {
+			mux.Handle("/api/ingest", ingestHandler)
+		}
+		srv := &http.Server{Addr: *httpAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal(err)
+			}
+		}()
+		defer srv.Shutdown(context.Background())
+		fmt.Fprintf(status, "Serving the HTTP API on %s\n", *httpAddr)
+		events = tee(events, api.Add)
+	}
+
+	// Handle ctrl-c and SIGTERM by cancelling the context so everything is
+	// flushed and closed down before exiting
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
-	for range c {
-		fmt.Println("Interrupt received, shutting down cleanly")
-		roFile.Close()
-		os.Exit(0)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		fmt.Fprintln(status, "Interrupt received, shutting down cleanly")
+		cancel()
+	}()
+
+	// Reload the configuration file on SIGHUP, the listeners keep the lines they've collected
+	if *configFilename != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reloadConfig(pipe, *configFilename, cfg.Input); err != nil {
+					fmt.Fprintln(os.Stderr, "error: reloading the configuration:", err)
+					continue
+				}
+				if !*showDashboard {
+					fmt.Fprintln(status, "Configuration reloaded from", *configFilename)
+				}
+			}
+		}()
+	}
+
+	if *showDashboard {
+		// The interval can only be changed from the dashboard if there's a summary listener
+		interval, onInterval := 10*time.Second, func(time.Duration) {}
+		if summary, ok := pipe.Listener("summary").(*listeners.Summary); ok {
+			interval, onInterval = summary.Interval(), summary.SetInterval
+		}
+		dash := dashboard.New(os.Stdout, os.Stdin, interval)
+		dash.OnInterval = onInterval
+		if err := dash.Run(ctx, events); err != nil {
+			fatal(err)
+		}
+		cancel()
+	}
+	// The sinks have already been written to, wait until the listeners have flushed
+	// and stopped before exiting
+	for range events {
+	}
+	pipe.Close()
+
+	if total := errorStats.Total(); total > 0 {
+		fmt.Fprintf(status, "%d lines could not be read or parsed:\n", total)
+		for _, c := range errorStats.Counts() {
+			fmt.Fprintf(status, "* %s %s: %d\n", c.Stage, c.Reason, c.Count)
+		}
+	}
+	select {
+	case err := <-failed:
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	default:
 	}
 }
 
-// NewLogReader opens the file as read-only, seeks to the end, and returns a bufio reader
-func NewLogReader(filename string) (reader *bufio.Reader, file *os.File, err error) {
-	file, err = os.OpenFile(filename, os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return
+// applyInput sets the flags from the input of the configuration file, unless they were
+// set on the command line
+func applyInput(input config.InputConfig) {
+	for name, value := range map[string]string{
+		"filename":   strings.Join(input.Paths, ","),
+		"format":     input.Format,
+		"on-error":   input.OnError,
+		"syslog-udp": input.SyslogUDP,
+		"syslog-tcp": input.SyslogTCP,
+	} {
+		if value != "" && !flagSet(name) {
+			flag.Set(name, value)
+		}
 	}
-	// Seek to end of file
-	_, err = file.Seek(0, 2)
+}
+
+// flagSet returns true if the flag was set on the command line or by applyInput
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// reloadConfig applies the listeners and sinks from the configuration file to the pipeline.
+// The input can't be changed without a restart, so it has to be the same as when it started.
+func reloadConfig(pipe *pipeline.Pipeline, filename string, input config.InputConfig) error {
+	cfg, err := config.Load(filename)
 	if err != nil {
-		return
+		return err
+	}
+	if !reflect.DeepEqual(cfg.Input, input) {
+		return fmt.Errorf("the input can't be changed without a restart")
+	}
+	return pipe.Reload(cfg)
+}
+
+// defaultConfig is the configuration when -config isn't set: the summary and the high
+// traffic alert listeners, writing to stdout in the format from -output if toStdout is true
+func defaultConfig(threshold, sectionDepth int, format string, toStdout bool) *config.Config {
+	cfg := &config.Config{
+		Listeners: []config.ListenerConfig{
+			{Name: "summary", Type: "summary", Options: listeners.Options{"section_depth": int64(sectionDepth)}},
+			{Name: "alert", Type: "alert", Options: listeners.Options{"threshold": int64(threshold)}},
+		},
+	}
+	if toStdout {
+		cfg.Sinks = []config.SinkConfig{{Name: "stdout", Type: "stdout", Options: listeners.Options{"format": format}}}
+		for i := range cfg.Listeners {
+			cfg.Listeners[i].Sinks = []string{"stdout"}
+		}
 	}
-	reader = bufio.NewReader(file)
-	return
+	return cfg
+}
+
+// tee calls fn with every Event received from in before passing it on to the returned channel
+func tee(in <-chan listeners.Event, fn func(listeners.Event) error) chan listeners.Event {
+	out := make(chan listeners.Event)
+	go func() {
+		for e := range in {
+			fn(e)
+			out <- e
+		}
+		close(out)
+	}()
+	return out
 }
 
 // the following are helper functions for parsing environment variables
@@ -90,18 +321,18 @@ func getEnvDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func mustParseInt(value string) int {
+// parseIntEnv returns the integer in the environment variable, or defaultValue if it isn't set
+func parseIntEnv(key string, defaultValue int) (int, error) {
+	value := getEnvDefault(key, strconv.Itoa(defaultValue))
 	i, err := strconv.Atoi(value)
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("%s should be an integer, got %q", key, value)
 	}
-	return i
+	return i, nil
 }
 
-func mustParseBool(value string) bool {
-	b, err := strconv.ParseBool(value)
-	if err != nil {
-		panic(err)
-	}
-	return b
+// fatal prints the error and exits, for errors that the program can't start or keep running with
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
 }
@@ -0,0 +1,217 @@
+// Package output renders listener Events so they can be written to a terminal,
+// a file or any other sink.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+)
+
+// Renderer writes a single Event to w in a specific format
+type Renderer interface {
+	Render(w io.Writer, e listeners.Event) error
+}
+
+// Text is a Renderer that writes the human readable form of the Event,
+// the same message that would be written with fmt.Println.
+type Text struct{}
+
+// Render is part of the Renderer interface
+func (Text) Render(w io.Writer, e listeners.Event) error {
+	_, err := fmt.Fprintln(w, e)
+	return err
+}
+
+// JSON is a Renderer that writes each Event as a single line JSON object (NDJSON)
+// with a stable schema:
+//
+//	{"type": "summary", "timestamp": "...", "window": {"start": "...", "end": "..."}, "fields": {...}}
+//
+// The fields object is the JSON encoding of the Event itself. Alerts also have the "alert" name
+// and the "message" of their text output, and alerts sent with the name of their listener, as a
+// listeners.NamedAlert, have a "listener" with the name.
+type JSON struct{}
+
+// jsonEvent is the envelope that every Event is written in by the JSON Renderer
+type jsonEvent struct {
+	Type      string           `json:"type"`
+	Listener  string           `json:"listener,omitempty"`
+	Alert     string           `json:"alert,omitempty"`
+	Message   string           `json:"message,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+	Window    listeners.Window `json:"window"`
+	Fields    listeners.Event  `json:"fields"`
+}
+
+// Render is part of the Renderer interface
+func (JSON) Render(w io.Writer, e listeners.Event) error {
+	window := e.TimeWindow()
+	encoded := jsonEvent{
+		Type:      e.Type(),
+		Timestamp: e.Time().UTC(),
+		Window:    listeners.Window{Start: window.Start.UTC(), End: window.End.UTC()},
+		Fields:    e,
+	}
+	if alert, ok := e.(listeners.AlertEvent); ok {
+		encoded.Alert = alert.AlertName()
+		encoded.Message = fmt.Sprint(alert)
+	}
+	if n, ok := e.(listeners.NamedAlert); ok {
+		encoded.Listener = n.Listener
+	}
+	return json.NewEncoder(w).Encode(encoded)
+}
+
+// NewRenderer returns the Renderer for the named format, either "text" or "json"
+func NewRenderer(format string) (Renderer, error) {
+	switch format {
+	case "text":
+		return Text{}, nil
+	case "json", "ndjson":
+		return JSON{}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// Sink is somewhere that Events are written to
+type Sink interface {
+	Write(listeners.Event) error
+	Close() error
+}
+
+// This ensures adherence to the Sink interface
+var _ = Sink(&Writer{})
+
+// Writer is a sink that renders every Event it receives into an io.Writer.
+// It is safe to use from multiple goroutines.
+type Writer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	renderer Renderer
+	closer   io.Closer
+}
+
+// NewWriter returns a Writer that renders Events into w using renderer.
+// Closing the Writer doesn't close w.
+func NewWriter(w io.Writer, renderer Renderer) *Writer {
+	return &Writer{w: w, renderer: renderer}
+}
+
+// Write renders the Event into the underlying io.Writer
+func (wr *Writer) Write(e listeners.Event) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return wr.renderer.Render(wr.w, e)
+}
+
+// Close closes the underlying io.Writer if the Writer owns it, such as a file sink
+func (wr *Writer) Close() error {
+	if wr.closer == nil {
+		return nil
+	}
+	return wr.closer.Close()
+}
+
+// SinkFactory returns a new Sink configured with the options
+type SinkFactory func(listeners.Options) (Sink, error)
+
+var (
+	sinksMu sync.Mutex
+	sinks   = make(map[string]SinkFactory)
+)
+
+func init() {
+	RegisterSink("stdout", func(opts listeners.Options) (Sink, error) {
+		return newStreamSink(os.Stdout, opts)
+	})
+	RegisterSink("stderr", func(opts listeners.Options) (Sink, error) {
+		return newStreamSink(os.Stderr, opts)
+	})
+	RegisterSink("file", newFileSink)
+}
+
+// RegisterSink makes a Sink available by the name, so it can be used in the configuration file.
+// It panics if the name is already registered.
+func RegisterSink(name string, factory SinkFactory) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	if _, ok := sinks[name]; ok {
+		panic(fmt.Sprintf("output: sink %q is already registered", name))
+	}
+	sinks[name] = factory
+}
+
+// NewSink returns a new Sink of the registered type, configured with the options
+func NewSink(name string, opts listeners.Options) (Sink, error) {
+	sinksMu.Lock()
+	factory, ok := sinks[name]
+	var names []string
+	for n := range sinks {
+		names = append(names, n)
+	}
+	sinksMu.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown sink type %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return factory(opts)
+}
+
+// newRendererFromOptions returns the Renderer for the "format" option, defaulting to text
+func newRendererFromOptions(opts listeners.Options) (Renderer, error) {
+	format, err := opts.String("format", "text")
+	if err != nil {
+		return nil, err
+	}
+	return NewRenderer(format)
+}
+
+// newStreamSink is the factory for the "stdout" and "stderr" sinks. The options are:
+//
+//	format: "text" or "json", defaults to "text"
+func newStreamSink(w io.Writer, opts listeners.Options) (Sink, error) {
+	if err := opts.CheckKeys("format"); err != nil {
+		return nil, err
+	}
+	renderer, err := newRendererFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(w, renderer), nil
+}
+
+// newFileSink is the factory for the "file" sink, which appends to a file. The options are:
+//
+//	path:   the file to write to, required
+//	format: "text" or "json", defaults to "text"
+func newFileSink(opts listeners.Options) (Sink, error) {
+	if err := opts.CheckKeys("path", "format"); err != nil {
+		return nil, err
+	}
+	path, err := opts.String("path", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("path is required for a file sink")
+	}
+	renderer, err := newRendererFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wr := NewWriter(file, renderer)
+	wr.closer = file
+	return wr, nil
+}
@@ -0,0 +1,92 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+)
+
+func TestJSONRender(t *testing.T) {
+	end := time.Date(2018, 5, 9, 16, 0, 40, 0, time.UTC)
+	report := listeners.SummaryReport{
+		Timestamp: end,
+		Window:    listeners.Window{Start: end.Add(-10 * time.Second), End: end},
+		Hits:      4,
+		Section:   listeners.SummaryReportItem{Key: "/api", Value: 3},
+	}
+
+	var buf bytes.Buffer
+	if err := (JSON{}).Render(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("expected a single line, got: %q", buf.String())
+	}
+
+	var decoded struct {
+		Type      string
+		Timestamp time.Time
+		Window    struct{ Start, End time.Time }
+		Fields    map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != "summary" {
+		t.Errorf("bad type: %s", decoded.Type)
+	}
+	if !decoded.Timestamp.Equal(end) || !decoded.Window.End.Equal(end) || !decoded.Window.Start.Equal(end.Add(-10*time.Second)) {
+		t.Errorf("bad timestamp or window: %s", buf.String())
+	}
+	if decoded.Fields["hits"] != float64(4) {
+		t.Errorf("bad hits field: %v", decoded.Fields["hits"])
+	}
+	if _, ok := decoded.Fields["Timestamp"]; ok {
+		t.Errorf("timestamp should only be in the envelope: %s", buf.String())
+	}
+}
+
+func TestJSONRenderNamedAlert(t *testing.T) {
+	alert := listeners.NamedAlert{AlertEvent: listeners.AlertTriggered{AlertTraffic: listeners.AlertTraffic{Hits: 1500}}, Listener: "api_alert"}
+	var buf bytes.Buffer
+	if err := (JSON{}).Render(&buf, alert); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Type, Listener, Alert, Message string
+		Fields                         map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != "alert_triggered" || decoded.Listener != "api_alert" || decoded.Fields["hits"] != float64(1500) {
+		t.Errorf("bad named alert: %s", buf.String())
+	}
+	if decoded.Alert != "high_traffic" || !strings.HasPrefix(decoded.Message, "High traffic generated an alert - hits = 1500") {
+		t.Errorf("bad alert name or message: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := (Text{}).Render(&buf, alert); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "High traffic generated an alert - hits = 1500") {
+		t.Errorf("bad text: %s", buf.String())
+	}
+}
+
+func TestNewRenderer(t *testing.T) {
+	if _, err := NewRenderer("text"); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewRenderer("json"); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewRenderer("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,274 @@
+// Package pipeline builds the listeners and sinks declared in a config.Config and
+// connects them: every listener receives every log line from the sources it is configured
+// with, and every Event a listener sends is written to the sinks it is configured with.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/caitlin615/logmonitor/config"
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+	"github.com/caitlin615/logmonitor/output"
+)
+
+// Pipeline is the listeners and sinks from a config.Config
+type Pipeline struct {
+	listeners []*namedListener
+
+	mu          sync.RWMutex // guards the sinks and source of the listeners, sinks and sinkConfigs
+	sinks       map[string]output.Sink
+	sinkConfigs map[string]config.SinkConfig
+}
+
+type namedListener struct {
+	name     string
+	typ      string
+	options  listeners.Options
+	listener listeners.Listener
+	sinks    []string
+	source   string
+}
+
+// New returns a Pipeline with the listeners and sinks in the configuration.
+// If one of them can't be created, the sinks that were already created are closed.
+func New(cfg *config.Config) (*Pipeline, error) {
+	p := &Pipeline{sinkConfigs: make(map[string]config.SinkConfig)}
+	sinks, err := newSinks(cfg.Sinks, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.sinks = sinks
+	for _, sc := range cfg.Sinks {
+		p.sinkConfigs[sc.Name] = sc
+	}
+
+	for _, lc := range cfg.Listeners {
+		listener, err := listeners.New(lc.Type, lc.Options)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("listener %q: %v", lc.Name, err)
+		}
+		for _, s := range lc.Sinks {
+			if _, ok := p.sinks[s]; !ok {
+				p.Close()
+				return nil, fmt.Errorf("listener %q: sink %q isn't declared", lc.Name, s)
+			}
+		}
+		p.listeners = append(p.listeners, &namedListener{lc.Name, lc.Type, lc.Options, listener, lc.Sinks, lc.Source})
+	}
+	return p, nil
+}
+
+// Listener returns the listener with the name, or nil if there isn't one
+func (p *Pipeline) Listener(name string) listeners.Listener {
+	for _, nl := range p.listeners {
+		if nl.name == name {
+			return nl.listener
+		}
+	}
+	return nil
+}
+
+// Start starts all of the listeners on the lines and writes their Events to their sinks.
+// Every Event is also sent into the returned channel, which must be received from until
+// it is closed once all of the listeners have stopped. Errors writing to a sink are sent into errs.
+func (p *Pipeline) Start(ctx context.Context, lines log.Channel, errs log.ErrorChannel) <-chan listeners.Event {
+	out := make(chan listeners.Event)
+	lineChans := lines.Fanout(ctx, len(p.listeners))
+
+	var wg sync.WaitGroup
+	wg.Add(len(p.listeners))
+	for i, nl := range p.listeners {
+		go func(nl *namedListener, recv listeners.OutputChannel) {
+			defer wg.Done()
+			for e := range recv {
+				// Alerts carry the name of their listener, so that the alerts of several
+				// listeners of the same type are active on their own
+				if alert, ok := e.(listeners.AlertEvent); ok {
+					e = listeners.NamedAlert{AlertEvent: alert, Listener: nl.name}
+				}
+				p.write(ctx, nl, e, errs)
+				out <- e
+			}
+		}(nl, nl.listener.Start(ctx, p.filter(ctx, nl, lineChans[i]), errs))
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// filter returns a Channel with the lines from in that match the source of the listener.
+// Once the context is cancelled, the lines from in are discarded until it is closed.
+func (p *Pipeline) filter(ctx context.Context, nl *namedListener, in log.Channel) log.Channel {
+	out := make(log.Channel)
+	go func() {
+		defer close(out)
+		for line := range in {
+			p.mu.RLock()
+			source := nl.source
+			p.mu.RUnlock()
+			if source != "" {
+				// The pattern has been checked when the configuration was validated
+				if ok, _ := filepath.Match(source, line.Source); !ok {
+					continue
+				}
+			}
+			select {
+			case out <- line:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// write writes the Event to the sinks of the listener, errors are sent into errs
+func (p *Pipeline) write(ctx context.Context, nl *namedListener, e listeners.Event, errs log.ErrorChannel) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, name := range nl.sinks {
+		if err := p.sinks[name].Write(e); err != nil {
+			errs.Send(ctx, &log.Error{Stage: log.StageOutput, Reason: log.ReasonWrite, Err: fmt.Errorf("sink %q: %v", name, err)})
+		}
+	}
+}
+
+// newSinks creates the sinks in the configuration. Sinks in current with the same configuration
+// in currentConfigs are reused rather than opened again. If one of them can't be created,
+// the sinks that were created are closed.
+func newSinks(cfgs []config.SinkConfig, current map[string]output.Sink, currentConfigs map[string]config.SinkConfig) (map[string]output.Sink, error) {
+	sinks := make(map[string]output.Sink)
+	for _, sc := range cfgs {
+		if old, ok := currentConfigs[sc.Name]; ok && reflect.DeepEqual(old, sc) {
+			sinks[sc.Name] = current[sc.Name]
+			continue
+		}
+		sink, err := output.NewSink(sc.Type, sc.Options)
+		if err != nil {
+			for name, s := range sinks {
+				if s != current[name] {
+					s.Close()
+				}
+			}
+			return nil, fmt.Errorf("sink %q: %v", sc.Name, err)
+		}
+		sinks[sc.Name] = sink
+	}
+	return sinks, nil
+}
+
+// Reload applies a new configuration to a started Pipeline. The listeners keep what they have
+// collected so far and are reconfigured with their new options and sources, sinks that have changed are
+// opened again and sinks that are no longer declared are closed.
+//
+// Listeners can't be added, removed or change type without a restart. If the configuration
+// can't be applied, an error is returned and nothing is changed.
+func (p *Pipeline) Reload(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Listeners) != len(p.listeners) {
+		return fmt.Errorf("listeners can't be added or removed without a restart, expected %s", p.listenerNames())
+	}
+	for i, lc := range cfg.Listeners {
+		nl := p.listeners[i]
+		if lc.Name != nl.name || lc.Type != nl.typ {
+			return fmt.Errorf("listener %q (%s) can't be changed to %q (%s) without a restart, expected %s", nl.name, nl.typ, lc.Name, lc.Type, p.listenerNames())
+		}
+		if _, ok := nl.listener.(listeners.Reconfigurable); !ok && !reflect.DeepEqual(lc.Options, nl.options) {
+			return fmt.Errorf("listener %q: the %s listener's options can't be changed without a restart", lc.Name, lc.Type)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sinks, err := newSinks(cfg.Sinks, p.sinks, p.sinkConfigs)
+	if err != nil {
+		return err
+	}
+	// The options have been validated, but reconfiguring can still fail, such as when a state
+	// file has changed since, so the listeners are only changed if all of them can be
+	for i, lc := range cfg.Listeners {
+		r, ok := p.listeners[i].listener.(listeners.Reconfigurable)
+		if !ok {
+			continue
+		}
+		if err := r.Reconfigure(lc.Options); err != nil {
+			err = fmt.Errorf("listener %q: %v", lc.Name, err)
+			if rerr := p.restore(i); rerr != nil {
+				err = fmt.Errorf("%v, and restoring the listeners failed: %v", err, rerr)
+			}
+			closeSinks(sinks, p.sinks)
+			return err
+		}
+	}
+
+	for i, lc := range cfg.Listeners {
+		nl := p.listeners[i]
+		nl.options = lc.Options
+		nl.sinks = lc.Sinks
+		nl.source = lc.Source
+	}
+	closeSinks(p.sinks, sinks)
+	p.sinks = sinks
+	p.sinkConfigs = make(map[string]config.SinkConfig)
+	for _, sc := range cfg.Sinks {
+		p.sinkConfigs[sc.Name] = sc
+	}
+	return nil
+}
+
+// restore reconfigures the first n listeners with the options they had before a reload.
+// It is called with p.mu held.
+func (p *Pipeline) restore(n int) error {
+	var firstErr error
+	for _, nl := range p.listeners[:n] {
+		if r, ok := nl.listener.(listeners.Reconfigurable); ok {
+			if err := r.Reconfigure(nl.options); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("listener %q: %v", nl.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// closeSinks closes the sinks that aren't kept
+func closeSinks(sinks, kept map[string]output.Sink) {
+	for name, sink := range sinks {
+		if kept[name] != sink {
+			sink.Close()
+		}
+	}
+}
+
+func (p *Pipeline) listenerNames() string {
+	names := make([]string, len(p.listeners))
+	for i, nl := range p.listeners {
+		names[i] = fmt.Sprintf("%q", nl.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Close closes all of the sinks
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var firstErr error
+	for _, sink := range p.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
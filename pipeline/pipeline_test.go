@@ -0,0 +1,294 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/config"
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+	"github.com/caitlin615/logmonitor/output"
+)
+
+// memorySink keeps every Event written to it
+type memorySink struct {
+	mu     sync.Mutex
+	events []listeners.Event
+	closed bool
+}
+
+func (m *memorySink) Write(e listeners.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+var memorySinks = make(map[string]*memorySink)
+
+// failingListener can be created with any options, but can't be reconfigured with "fail"
+type failingListener struct{}
+
+func (failingListener) Start(ctx context.Context, lc log.Channel, errs log.ErrorChannel) listeners.OutputChannel {
+	out := make(listeners.OutputChannel)
+	go func() {
+		for range lc {
+		}
+		close(out)
+	}()
+	return out
+}
+
+func (failingListener) Reconfigure(opts listeners.Options) error {
+	if _, ok := opts["fail"]; ok {
+		return errors.New("failed to reconfigure")
+	}
+	return nil
+}
+
+func init() {
+	listeners.Register("failing", func(listeners.Options) (listeners.Listener, error) {
+		return failingListener{}, nil
+	})
+	output.RegisterSink("memory", func(opts listeners.Options) (output.Sink, error) {
+		id, _ := opts.String("id", "")
+		sink := &memorySink{}
+		memorySinks[id] = sink
+		return sink, nil
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	cfg := &config.Config{
+		Sinks: []config.SinkConfig{
+			{Name: "all", Type: "memory", Options: listeners.Options{"id": "all"}},
+			{Name: "none", Type: "memory", Options: listeners.Options{"id": "none"}},
+			{Name: "shop", Type: "memory", Options: listeners.Options{"id": "shop"}},
+		},
+		Listeners: []config.ListenerConfig{
+			{Name: "first", Type: "summary", Sinks: []string{"all"}, Options: listeners.Options{"interval": "1h"}},
+			{Name: "second", Type: "summary", Sinks: []string{"all"}, Options: listeners.Options{"interval": "1h"}},
+			{Name: "shop", Type: "summary", Sinks: []string{"shop"}, Source: "/var/log/shop.*", Options: listeners.Options{"interval": "1h"}},
+		},
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Listener("second").(*listeners.Summary); !ok {
+		t.Errorf("expected to find the second listener")
+	}
+
+	lines := make(log.Channel)
+	events := p.Start(context.Background(), lines, nil)
+	for i := 0; i < 5; i++ {
+		lines <- log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api/user"}, Source: "/var/log/blog.access.log"}
+	}
+	lines <- log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api/user"}, Source: "/var/log/shop.access.log"}
+	close(lines)
+
+	// The listeners without a source see every line, and they all flush a report when the lines end
+	count := 0
+	for e := range events {
+		count++
+		if sr := e.(listeners.SummaryReport); sr.Hits != 6 && sr.Hits != 1 {
+			t.Errorf("expected every listener to see the lines from its sources, got: %d", sr.Hits)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected a report from each listener, got: %d", count)
+	}
+	if n := len(memorySinks["shop"].events); n != 1 || memorySinks["shop"].events[0].(listeners.SummaryReport).Hits != 1 {
+		t.Errorf("expected the shop listener to only see the line from the shop, got: %v", memorySinks["shop"].events)
+	}
+	if n := len(memorySinks["all"].events); n != 2 {
+		t.Errorf("expected both reports to be written to the sink, got: %d", n)
+	}
+	if n := len(memorySinks["none"].events); n != 0 {
+		t.Errorf("expected nothing to be written to the unused sink, got: %d", n)
+	}
+
+	p.Close()
+	if !memorySinks["all"].closed || !memorySinks["none"].closed {
+		t.Error("expected the sinks to be closed")
+	}
+}
+
+func TestPipelineReplayOldLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A thousand requests in 100 seconds of 2018, well over the threshold if they were recent
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "127.0.0.1 - frank [09/May/2018:16:%02d:%02d +0000] \"GET /api/user HTTP/1.0\" 200 123\n", i/600, i/10%60)
+	}
+	path := filepath.Join(dir, "access.log")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(&config.Config{
+		Listeners: []config.ListenerConfig{
+			{Name: "summary", Type: "summary", Options: listeners.Options{"interval": "1h"}},
+			{Name: "alert", Type: "alert", Options: listeners.Options{"threshold": int64(1), "interval": "10ms"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replayed := make(log.Channel)
+	if err := replayed.ReplayFiles(ctx, []string{path}, log.CommonFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(log.Channel)
+	events := p.Start(ctx, lines, nil)
+	for line := range replayed {
+		lines <- line
+	}
+	// Give the alert a few checks before the lines end
+	time.Sleep(50 * time.Millisecond)
+	close(lines)
+
+	// The summary counts every line as it is read, but the alert and the requests per second
+	// go by the dates in the lines, which are long before the window
+	var summary *listeners.SummaryReport
+	for e := range events {
+		switch e := e.(type) {
+		case listeners.SummaryReport:
+			summary = &e
+		case listeners.AlertEvent:
+			t.Errorf("expected no alert for old lines, got: %v", e)
+		}
+	}
+	if summary == nil || summary.Hits != 1000 {
+		t.Fatalf("expected the summary to count every line, got: %+v", summary)
+	}
+	for _, hits := range summary.HitsPerSecond {
+		if hits != 0 {
+			t.Fatalf("expected no hits per second for old lines, got: %v", summary.HitsPerSecond)
+		}
+	}
+	p.Close()
+}
+
+func TestPipelineErrors(t *testing.T) {
+	_, err := New(&config.Config{Listeners: []config.ListenerConfig{{Name: "x", Type: "nope"}}})
+	if err == nil {
+		t.Error("expected an error for an unknown listener type")
+	}
+	_, err = New(&config.Config{Sinks: []config.SinkConfig{{Name: "x", Type: "file"}}})
+	if err == nil {
+		t.Error("expected an error for a file sink without a path")
+	}
+}
+
+func TestPipelineReload(t *testing.T) {
+	cfg := &config.Config{
+		Sinks:     []config.SinkConfig{{Name: "out", Type: "memory", Options: listeners.Options{"id": "before"}}},
+		Listeners: []config.ListenerConfig{{Name: "summary", Type: "summary", Sinks: []string{"out"}, Options: listeners.Options{"interval": "1h"}}},
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(log.Channel)
+	events := p.Start(context.Background(), lines, nil)
+	for i := 0; i < 2; i++ {
+		lines <- log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api/user"}}
+	}
+
+	// Listeners can't be added without a restart, and nothing is changed
+	added := &config.Config{Listeners: append(cfg.Listeners, config.ListenerConfig{Name: "alert", Type: "alert"}), Sinks: cfg.Sinks}
+	if err := p.Reload(added); err == nil {
+		t.Error("expected an error when adding a listener")
+	}
+	invalid := &config.Config{Listeners: []config.ListenerConfig{{Name: "summary", Type: "summary", Options: listeners.Options{"interval": "never"}}}}
+	if err := p.Reload(invalid); err == nil {
+		t.Error("expected an error for invalid options")
+	}
+	if memorySinks["before"].closed {
+		t.Error("expected the sink to be kept when the reload fails")
+	}
+
+	reloaded := &config.Config{
+		Sinks:     []config.SinkConfig{{Name: "out", Type: "memory", Options: listeners.Options{"id": "after"}}},
+		Listeners: []config.ListenerConfig{{Name: "summary", Type: "summary", Sinks: []string{"out"}, Options: listeners.Options{"interval": "2h"}}},
+	}
+	if err := p.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if !memorySinks["before"].closed {
+		t.Error("expected the changed sink to be closed")
+	}
+	if interval := p.Listener("summary").(*listeners.Summary).Interval(); interval != 2*time.Hour {
+		t.Errorf("expected the new interval, got: %s", interval)
+	}
+
+	lines <- log.Line{Date: time.Now(), Request: log.LineRequest{URL: "/api/user"}}
+	close(lines)
+	for e := range events {
+		if sr := e.(listeners.SummaryReport); sr.Hits != 3 {
+			t.Errorf("expected the lines from before the reload to be kept, got: %d", sr.Hits)
+		}
+	}
+	if n := len(memorySinks["after"].events); n != 1 {
+		t.Errorf("expected the report to be written to the new sink, got: %d", n)
+	}
+	if n := len(memorySinks["before"].events); n != 0 {
+		t.Errorf("expected nothing to be written to the old sink, got: %d", n)
+	}
+	p.Close()
+}
+
+func TestPipelineReloadRollsBack(t *testing.T) {
+	cfg := &config.Config{
+		Sinks: []config.SinkConfig{{Name: "out", Type: "memory", Options: listeners.Options{"id": "kept"}}},
+		Listeners: []config.ListenerConfig{
+			{Name: "summary", Type: "summary", Sinks: []string{"out"}, Options: listeners.Options{"interval": "1h"}},
+			{Name: "failing", Type: "failing"},
+		},
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The second listener fails after the first one has been reconfigured
+	failed := &config.Config{
+		Sinks: []config.SinkConfig{{Name: "out", Type: "memory", Options: listeners.Options{"id": "unused"}}},
+		Listeners: []config.ListenerConfig{
+			{Name: "summary", Type: "summary", Sinks: []string{"out"}, Options: listeners.Options{"interval": "2h"}},
+			{Name: "failing", Type: "failing", Options: listeners.Options{"fail": true}},
+		},
+	}
+	if err := p.Reload(failed); err == nil {
+		t.Fatal("expected an error reconfiguring the second listener")
+	}
+	if interval := p.Listener("summary").(*listeners.Summary).Interval(); interval != time.Hour {
+		t.Errorf("expected the first listener to keep its interval, got: %s", interval)
+	}
+	if !memorySinks["unused"].closed || memorySinks["kept"].closed {
+		t.Error("expected the new sink to be closed and the old one to be kept")
+	}
+}
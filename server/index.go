@@ -0,0 +1,116 @@
+package server
+
+// indexHTML is the self-contained web dashboard served at "/".
+// It listens to /api/events and draws the traffic and top sections as they are reported.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>logmonitor</title>
+<style>
+  body { font-family: monospace; margin: 2em; background: #fafafa; color: #222; }
+  h1 { font-size: 1.4em; }
+  h2 { font-size: 1.1em; margin-top: 1.5em; }
+  #status { padding: 0.2em 0.6em; border-radius: 3px; background: #2a2; color: #fff; }
+  #status.alert { background: #c22; }
+  canvas { background: #fff; border: 1px solid #ccc; }
+  table { border-collapse: collapse; }
+  td { padding: 0.1em 1em 0.1em 0; }
+  td.value { text-align: right; }
+</style>
+</head>
+<body>
+<h1>logmonitor <span id="status">OK</span></h1>
+
+<h2>Requests per second</h2>
+<canvas id="traffic" width="900" height="150"></canvas>
+
+<h2>Top sections</h2>
+<table id="sections"></table>
+
+<h2>Alerts</h2>
+<table id="alerts"></table>
+
+<script>
+var maxPoints = 300;
+var traffic = [];
+var trafficEnd = null;
+
+function addTraffic(ev) {
+  var start = Math.floor(Date.parse(ev.window.start) / 1000);
+  if (trafficEnd !== null && start > trafficEnd) {
+    for (var i = trafficEnd; i < start && i - trafficEnd < maxPoints; i++) traffic.push(0);
+  }
+  var hits = ev.fields.hits_per_second || [];
+  traffic = traffic.concat(hits).slice(-maxPoints);
+  trafficEnd = start + hits.length;
+  drawTraffic();
+}
+
+function drawTraffic() {
+  var canvas = document.getElementById("traffic");
+  var ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  var max = Math.max.apply(null, traffic.concat([1]));
+  var step = canvas.width / maxPoints;
+  ctx.beginPath();
+  ctx.strokeStyle = "#36c";
+  traffic.forEach(function(v, i) {
+    var x = (maxPoints - traffic.length + i) * step;
+    var y = canvas.height - (v / max) * (canvas.height - 10);
+    if (i === 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+  });
+  ctx.stroke();
+  ctx.fillStyle = "#666";
+  ctx.fillText("max " + max, 4, 12);
+}
+
+function setRows(id, rows) {
+  var table = document.getElementById(id);
+  table.innerHTML = "";
+  rows.forEach(function(cells) {
+    var tr = document.createElement("tr");
+    cells.forEach(function(cell, i) {
+      var td = document.createElement("td");
+      td.textContent = cell;
+      if (i > 0) td.className = "value";
+      tr.appendChild(td);
+    });
+    table.appendChild(tr);
+  });
+}
+
+function addSummary(ev) {
+  addTraffic(ev);
+  setRows("sections", (ev.fields.top_sections || []).map(function(item) {
+    return [item.key, item.value];
+  }));
+}
+
+var alerts = [];
+// active has the names of the triggered alerts, by the listener that sent them
+var active = {};
+function addAlert(ev) {
+  var key = ev.listener || ev.alert;
+  if (/_triggered$/.test(ev.type)) active[key] = ev.alert.toUpperCase().replace(/_/g, " "); else delete active[key];
+  var names = Object.keys(active).map(function(k) { return active[k]; }).sort().filter(function(name, i, names) {
+    return i === 0 || name !== names[i - 1];
+  });
+  var status = document.getElementById("status");
+  status.textContent = names.length ? names.join(", ") : "OK";
+  status.className = names.length ? "alert" : "";
+  alerts.unshift([ev.timestamp, ev.type, ev.message]);
+  setRows("alerts", alerts.slice(0, 20));
+}
+
+fetch("/api/alerts/history").then(function(resp) { return resp.json(); }).then(function(history) {
+  history.forEach(addAlert);
+});
+
+var source = new EventSource("/api/events");
+source.addEventListener("summary", function(msg) { addSummary(JSON.parse(msg.data)); });
+source.addEventListener("alert", function(msg) { addAlert(JSON.parse(msg.data)); });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,246 @@
+// Package server is a read-only HTTP server that exposes the Events sent by the listeners
+// as JSON endpoints, a Server-Sent Events stream and a small web dashboard.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/caitlin615/logmonitor/listeners"
+	"github.com/caitlin615/logmonitor/log"
+	"github.com/caitlin615/logmonitor/output"
+)
+
+// maxAlerts is the number of alert events that are kept in the history
+const maxAlerts = 100
+
+// Server keeps the latest state from the Events it receives and serves it over HTTP:
+//
+//	GET /                    web dashboard
+//	GET /api/summary         latest summary report
+//	GET /api/alerts          current alert state
+//	GET /api/alerts/history  alert events, oldest first
+//	GET /api/slo             latest error budget and burn rates of the SLO listener
+//	GET /api/events          Server-Sent Events stream of every Event
+//	GET /api/errors          counts of errors by reason and a sample of the lines that caused them
+//
+// Events are encoded with the same schema as the JSON output. In the stream, the name of an event
+// is its type, except for alerts which are all named "alert".
+type Server struct {
+	mux *http.ServeMux
+
+	// ErrorStats are the errors served by /api/errors, it can be nil if they aren't counted
+	ErrorStats *log.ErrorStats
+
+	mu          sync.Mutex
+	summary     json.RawMessage
+	slo         json.RawMessage
+	active      map[string]bool // names of the listeners with a triggered alert, see listeners.AlertKey
+	lastAlert   json.RawMessage
+	alerts      []json.RawMessage // oldest first
+	subscribers map[chan []byte]struct{}
+}
+
+// New returns a Server with no state, Add should be called with every Event
+func New() *Server {
+	s := &Server{
+		mux:         http.NewServeMux(),
+		active:      make(map[string]bool),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/api/summary", s.handleSummary)
+	s.mux.HandleFunc("/api/alerts", s.handleAlerts)
+	s.mux.HandleFunc("/api/alerts/history", s.handleAlertHistory)
+	s.mux.HandleFunc("/api/slo", s.handleSLO)
+	s.mux.HandleFunc("/api/events", s.handleEvents)
+	s.mux.HandleFunc("/api/errors", s.handleErrors)
+	return s
+}
+
+// Add updates the state of the server from the Event and sends it to the connected event streams
+func (s *Server) Add(e listeners.Event) error {
+	var buf bytes.Buffer
+	if err := (output.JSON{}).Render(&buf, e); err != nil {
+		return err
+	}
+	encoded := bytes.TrimSpace(buf.Bytes())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev := e.(type) {
+	case listeners.SummaryReport:
+		s.summary = encoded
+	case listeners.SLOReport:
+		s.slo = encoded
+	case listeners.AlertEvent:
+		if ev.Triggered() {
+			s.active[listeners.AlertKey(ev)] = true
+		} else {
+			delete(s.active, listeners.AlertKey(ev))
+		}
+		s.lastAlert = encoded
+		s.alerts = append(s.alerts, encoded)
+		if len(s.alerts) > maxAlerts {
+			s.alerts = s.alerts[len(s.alerts)-maxAlerts:]
+		}
+	}
+
+	// Every kind of alert is sent as an "alert" event, so clients don't need to know them all
+	name := e.Type()
+	if _, ok := e.(listeners.AlertEvent); ok {
+		name = "alert"
+	}
+	msg := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, encoded))
+	for sub := range s.subscribers {
+		select {
+		case sub <- msg:
+		default:
+			// The client isn't keeping up, drop the event rather than block the listeners
+		}
+	}
+	return nil
+}
+
+// ServeHTTP is part of the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, indexHTML)
+}
+
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	summary := s.summary
+	s.mu.Unlock()
+
+	if summary == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no summary has been reported yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *Server) handleSLO(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	slo := s.slo
+	s.mu.Unlock()
+
+	if slo == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no SLO has been reported yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, slo)
+}
+
+// alertState is the response of the /api/alerts endpoint. Active is whether any alert is
+// triggered, and ActiveAlerts are the names of the listeners that triggered them.
+type alertState struct {
+	Active       bool            `json:"active"`
+	ActiveAlerts []string        `json:"active_alerts"`
+	Last         json.RawMessage `json:"last"`
+}
+
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	state := alertState{Active: len(s.active) > 0, ActiveAlerts: []string{}, Last: s.lastAlert}
+	for name := range s.active {
+		state.ActiveAlerts = append(state.ActiveAlerts, name)
+	}
+	sort.Strings(state.ActiveAlerts)
+	s.mu.Unlock()
+
+	if state.Last == nil {
+		state.Last = json.RawMessage("null")
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *Server) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	alerts := append([]json.RawMessage{}, s.alerts...)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := make(chan []byte, 16)
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	summary := s.summary
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// Start the client off with the latest summary so it doesn't have to wait for the next one
+	if summary != nil {
+		fmt.Fprintf(w, "event: summary\ndata: %s\n\n", summary)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-sub:
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// errorState is the response of the /api/errors endpoint
+type errorState struct {
+	Total   int               `json:"total"`
+	Counts  []log.ErrorCount  `json:"counts"`
+	Samples []log.ErrorSample `json:"samples"`
+}
+
+func (s *Server) handleErrors(w http.ResponseWriter, r *http.Request) {
+	state := errorState{Counts: []log.ErrorCount{}, Samples: []log.ErrorSample{}}
+	if s.ErrorStats != nil {
+		state.Total = s.ErrorStats.Total()
+		state.Counts = s.ErrorStats.Counts()
+		state.Samples = s.ErrorStats.Samples()
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
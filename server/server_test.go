@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/listeners"
+)
+
+func TestServerSummary(t *testing.T) {
+	srv := New()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/summary", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected not found before the first summary, got: %d", rec.Code)
+	}
+
+	srv.Add(listeners.SummaryReport{Timestamp: time.Now(), Hits: 42})
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/summary", nil))
+	var summary struct {
+		Type   string
+		Fields struct{ Hits int }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Type != "summary" || summary.Fields.Hits != 42 {
+		t.Errorf("bad summary: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", "/api/summary", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the server to be read-only, got: %d", rec.Code)
+	}
+}
+
+func TestServerSLO(t *testing.T) {
+	srv := New()
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/slo", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 before the SLO is reported, got: %d", rec.Code)
+	}
+
+	srv.Add(listeners.SLOReport{Objective: 99.9, BudgetRemaining: 0.75})
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/slo", nil))
+	var slo struct {
+		Type   string
+		Fields struct {
+			BudgetRemaining float64 `json:"budget_remaining"`
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &slo); err != nil {
+		t.Fatal(err)
+	}
+	if slo.Type != "slo" || slo.Fields.BudgetRemaining != 0.75 {
+		t.Errorf("bad SLO: %s", rec.Body.String())
+	}
+}
+
+func TestServerAlerts(t *testing.T) {
+	srv := New()
+	srv.Add(listeners.AlertTriggered{AlertTraffic: listeners.AlertTraffic{Hits: 1500}})
+	srv.Add(listeners.AlertRecovered{AlertTraffic: listeners.AlertTraffic{Hits: 10}})
+	srv.Add(listeners.AlertTriggered{AlertTraffic: listeners.AlertTraffic{Hits: 2000}})
+	srv.Add(listeners.BandwidthTriggered{BandwidthTraffic: listeners.BandwidthTraffic{BytesPerSecond: 100}})
+	srv.Add(listeners.BandwidthRecovered{BandwidthTraffic: listeners.BandwidthTraffic{BytesPerSecond: 10}})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/alerts", nil))
+	var state struct {
+		Active       bool
+		ActiveAlerts []string `json:"active_alerts"`
+		Last         struct{ Type string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Active || !reflect.DeepEqual(state.ActiveAlerts, []string{"high_traffic"}) || state.Last.Type != "bandwidth_alert_recovered" {
+		t.Errorf("bad alert state: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/alerts/history", nil))
+	var history []struct{ Type string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 5 || history[1].Type != "alert_recovered" {
+		t.Errorf("bad alert history: %s", rec.Body.String())
+	}
+}
+
+func TestServerAlertsByListener(t *testing.T) {
+	srv := New()
+	// Two alert listeners on different routes, one of which recovers
+	srv.Add(listeners.NamedAlert{AlertEvent: listeners.AlertTriggered{}, Listener: "api_alert"})
+	srv.Add(listeners.NamedAlert{AlertEvent: listeners.AlertTriggered{}, Listener: "shop_alert"})
+	srv.Add(listeners.NamedAlert{AlertEvent: listeners.AlertRecovered{}, Listener: "shop_alert"})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/alerts", nil))
+	var state struct {
+		ActiveAlerts []string `json:"active_alerts"`
+		Last         struct{ Type, Listener string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.ActiveAlerts, []string{"api_alert"}) || state.Last.Listener != "shop_alert" {
+		t.Errorf("bad alert state: %s", rec.Body.String())
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	srv := New()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("bad content type: %s", ct)
+	}
+
+	// Keep sending until the subscription is registered, the stream has no initial summary
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				srv.Add(listeners.AlertTriggered{})
+			}
+		}
+	}()
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(line) != "event: alert" {
+		t.Errorf("bad event: %q", line)
+	}
+	line, _ = r.ReadString('\n')
+	if !strings.HasPrefix(line, `data: {"type":"alert_triggered"`) {
+		t.Errorf("bad event data: %q", line)
+	}
+}
@@ -0,0 +1,144 @@
+// Package syslog receives access logs sent over syslog, in the RFC 3164 (BSD) or RFC 5424 format
+// over UDP or TCP, and parses the lines in the bodies of the messages with a log.Format.
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTruncated is the error for a message that ends before all of its header fields
+var ErrTruncated = errors.New("the message ends before its header does")
+
+// Message is a syslog message
+type Message struct {
+	Facility int
+	Severity int
+	// Timestamp is zero if the message doesn't have one
+	Timestamp time.Time
+	// Hostname and AppName are empty if the message doesn't have them
+	Hostname string
+	AppName  string
+	Body     string
+}
+
+// rfc3164Time is the format of the timestamp of RFC 3164 messages, which doesn't have a year
+const rfc3164Time = "Jan _2 15:04:05"
+
+// Parse parses an RFC 5424 message, or an RFC 3164 message if it isn't one.
+// RFC 3164 only describes what is commonly sent, so anything after the priority that doesn't
+// look like its header is the body.
+func Parse(data string) (Message, error) {
+	m := Message{}
+	data = strings.TrimRight(data, "\r\n\x00")
+	end := strings.IndexByte(data, '>')
+	if !strings.HasPrefix(data, "<") || end < 2 || end > 4 {
+		return m, fmt.Errorf("the message doesn't start with a <priority>")
+	}
+	pri, err := strconv.Atoi(data[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return m, fmt.Errorf("invalid priority %q", data[1:end])
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+
+	rest := data[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(m, rest[2:])
+	}
+	return parseRFC3164(m, rest), nil
+}
+
+// parseRFC5424 parses the rest of an RFC 5424 message after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(m Message, rest string) (Message, error) {
+	var fields [5]string
+	for i := range fields {
+		sp := strings.IndexByte(rest, ' ')
+		if sp < 0 {
+			return m, ErrTruncated
+		}
+		fields[i], rest = rest[:sp], rest[sp+1:]
+	}
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp %q", fields[0])
+		}
+		m.Timestamp = t
+	}
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+
+	rest, err := skipStructuredData(rest)
+	if err != nil {
+		return m, err
+	}
+	// The body can start with a byte order mark to say that it's UTF-8
+	m.Body = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\xef\xbb\xbf")
+	return m, nil
+}
+
+// skipStructuredData returns what is after the structured data at the start of s, which is
+// either "-" or elements such as [id key="value"] where values can have escaped quotes and brackets
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return "", fmt.Errorf("invalid structured data %q", s)
+	}
+	inValue := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inValue && c == '\\':
+			i++
+		case c == '"':
+			inValue = !inValue
+		case !inValue && c == ']' && (i+1 == len(s) || s[i+1] != '['):
+			return s[i+1:], nil
+		}
+	}
+	return "", ErrTruncated
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parseRFC3164 parses the rest of an RFC 3164 message after the priority:
+// TIMESTAMP HOSTNAME TAG: MSG
+func parseRFC3164(m Message, rest string) Message {
+	if len(rest) <= len(rfc3164Time) || rest[len(rfc3164Time)] != ' ' {
+		m.Body = rest
+		return m
+	}
+	t, err := time.Parse(rfc3164Time, rest[:len(rfc3164Time)])
+	if err != nil {
+		m.Body = rest
+		return m
+	}
+	// The timestamp doesn't have a year, so it's assumed to be this year
+	now := time.Now()
+	m.Timestamp = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+	rest = rest[len(rfc3164Time)+1:]
+
+	// The hostname is left out by some senders, then the tag is first
+	if sp := strings.IndexByte(rest, ' '); sp > 0 && !strings.HasSuffix(rest[:sp], ":") {
+		m.Hostname, rest = rest[:sp], rest[sp+1:]
+	}
+	if sp := strings.IndexByte(rest, ' '); sp > 0 && strings.HasSuffix(rest[:sp], ":") {
+		tag := strings.TrimSuffix(rest[:sp], ":")
+		if i := strings.IndexByte(tag, '['); i >= 0 {
+			tag = tag[:i] // the process ID
+		}
+		m.AppName, rest = tag, rest[sp+1:]
+	}
+	m.Body = rest
+	return m
+}
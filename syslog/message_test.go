@@ -0,0 +1,49 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+)
+
+const accessLine = `127.0.0.1 - frank [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`
+
+func TestParse(t *testing.T) {
+	for raw, expected := range map[string]Message{
+		"<190>Oct  9 22:14:15 web1 nginx: " + accessLine + "\n": {Facility: 23, Severity: 6, Hostname: "web1", AppName: "nginx", Body: accessLine},
+		"<190>Oct 19 22:14:15 nginx[123]: " + accessLine:        {Facility: 23, Severity: 6, AppName: "nginx", Body: accessLine},
+		"<13>" + accessLine: {Facility: 1, Severity: 5, Body: accessLine},
+		"<165>1 2018-05-09T16:00:39.003Z web2 nginx - - - " + accessLine: {
+			Facility: 20, Severity: 5, Timestamp: time.Date(2018, 5, 9, 16, 0, 39, 3000000, time.UTC), Hostname: "web2", AppName: "nginx", Body: accessLine,
+		},
+		`<165>1 - - - - - [exampleSDID@32473 iut="3" eventSource="App\]lication"][other a="b"] ` + "\xef\xbb\xbf" + accessLine: {
+			Facility: 20, Severity: 5, Body: accessLine,
+		},
+	} {
+		m, err := Parse(raw)
+		if err != nil {
+			t.Errorf("%q: %v", raw, err)
+			continue
+		}
+		if !expected.Timestamp.IsZero() && !m.Timestamp.Equal(expected.Timestamp) {
+			t.Errorf("%q: expected the timestamp %s, got: %s", raw, expected.Timestamp, m.Timestamp)
+		}
+		m.Timestamp, expected.Timestamp = time.Time{}, time.Time{}
+		if m != expected {
+			t.Errorf("%q: expected %+v, got: %+v", raw, expected, m)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, raw := range []string{
+		accessLine,
+		"<1000>Oct 19 22:14:15 web1 nginx: hello",
+		"<165>1 2018-05-09T16:00:39Z web2",
+		"<165>1 yesterday web2 nginx - - - hello",
+		"<165>1 - web2 nginx - - [unterminated a=\"b\"",
+	} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
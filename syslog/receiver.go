@@ -0,0 +1,239 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+// maxMessageSize is the largest message that is received, UDP datagrams can't be larger
+const maxMessageSize = 64 * 1024
+
+// Receiver receives syslog messages over UDP and TCP
+type Receiver struct {
+	format *log.Format
+	udp    net.PacketConn
+	tcp    net.Listener
+
+	mu    sync.Mutex // guards conns
+	conns map[net.Conn]struct{}
+}
+
+// Listen returns a Receiver listening on the UDP and TCP addresses, such as ":514". Either address
+// can be empty to not listen on it. The bodies of the messages are parsed with the format.
+func Listen(udpAddr, tcpAddr string, format *log.Format) (*Receiver, error) {
+	if udpAddr == "" && tcpAddr == "" {
+		return nil, fmt.Errorf("syslog needs a UDP or TCP address to listen on")
+	}
+	r := &Receiver{format: format, conns: make(map[net.Conn]struct{})}
+	var err error
+	if udpAddr != "" {
+		if r.udp, err = net.ListenPacket("udp", udpAddr); err != nil {
+			return nil, err
+		}
+	}
+	if tcpAddr != "" {
+		if r.tcp, err = net.Listen("tcp", tcpAddr); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// UDPAddr returns the address the Receiver is listening on for UDP, or nil if it isn't
+func (r *Receiver) UDPAddr() net.Addr {
+	if r.udp == nil {
+		return nil
+	}
+	return r.udp.LocalAddr()
+}
+
+// TCPAddr returns the address the Receiver is listening on for TCP, or nil if it isn't
+func (r *Receiver) TCPAddr() net.Addr {
+	if r.tcp == nil {
+		return nil
+	}
+	return r.tcp.Addr()
+}
+
+// Receive sends the Lines in the messages into the Channel, with the Source set to the hostname
+// of the message, or the address of the sender if it doesn't have one. When the context is
+// cancelled the Receiver stops listening and closes the Channel.
+// This should be run within a goroutine
+//
+// Messages that can't be parsed are sent into errs with the log.ReasonSyslog reason, and lines
+// that can't be parsed are sent into errs like they are by log.Channel.Tail.
+//
+// TCP connections can use octet counting, where every message starts with its length and a
+// space, or end every message with a newline (RFC 6587).
+func (r *Receiver) Receive(ctx context.Context, lc log.Channel, errs log.ErrorChannel) {
+	var wg sync.WaitGroup
+	if r.udp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.receiveUDP(ctx, lc, errs)
+		}()
+	}
+	if r.tcp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.acceptTCP(ctx, lc, errs, &wg)
+		}()
+	}
+
+	<-ctx.Done()
+	r.close()
+	wg.Wait()
+	close(lc)
+}
+
+// close stops listening and closes the TCP connections, which stops the goroutines reading them
+func (r *Receiver) close() {
+	if r.udp != nil {
+		r.udp.Close()
+	}
+	if r.tcp != nil {
+		r.tcp.Close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func (r *Receiver) receiveUDP(ctx context.Context, lc log.Channel, errs log.ErrorChannel) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := r.udp.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				errs.Send(ctx, &log.Error{Stage: log.StageRead, Reason: log.ReasonRead, Err: err})
+			}
+			return
+		}
+		r.handle(ctx, string(buf[:n]), addr, lc, errs)
+	}
+}
+
+func (r *Receiver) acceptTCP(ctx context.Context, lc log.Channel, errs log.ErrorChannel, wg *sync.WaitGroup) {
+	for {
+		conn, err := r.tcp.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				errs.Send(ctx, &log.Error{Stage: log.StageRead, Reason: log.ReasonRead, Err: err})
+			}
+			return
+		}
+		r.mu.Lock()
+		if ctx.Err() != nil {
+			// The connections have already been closed
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		r.conns[conn] = struct{}{}
+		r.mu.Unlock()
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			r.receiveTCP(ctx, conn, lc, errs)
+			r.mu.Lock()
+			delete(r.conns, conn)
+			r.mu.Unlock()
+			conn.Close()
+		}(conn)
+	}
+}
+
+// receiveTCP reads messages from the connection until it is closed, or until a message is too
+// large or can't be framed, when the connection is closed
+func (r *Receiver) receiveTCP(ctx context.Context, conn net.Conn, lc log.Channel, errs log.ErrorChannel) {
+	// A message and its newline fit in the buffer, so reading one never takes more memory than that
+	reader := bufio.NewReaderSize(conn, maxMessageSize+1)
+	for {
+		msg, err := readFrame(reader)
+		if msg != "" {
+			r.handle(ctx, msg, conn.RemoteAddr(), lc, errs)
+		}
+		if err == io.EOF || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			errs.Send(ctx, &log.Error{Stage: log.StageRead, Reason: log.ReasonSyslog, Err: err})
+			return
+		}
+	}
+}
+
+// readFrame reads the next message from a TCP connection, which is octet counted if it
+// starts with a digit and ends with a newline otherwise. Messages are at most the size of
+// the reader's buffer, an error is returned for longer ones.
+func readFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		return readSlice(reader, '\n')
+	}
+
+	length, err := readSlice(reader, ' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(length[:len(length)-1])
+	if err != nil || n > maxMessageSize {
+		return "", fmt.Errorf("invalid message length %q", length)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+// readSlice reads until the delimiter like bufio.Reader.ReadString, without reading past the
+// reader's buffer
+func readSlice(reader *bufio.Reader, delim byte) (string, error) {
+	b, err := reader.ReadSlice(delim)
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("message is longer than %d bytes", maxMessageSize)
+	}
+	return string(b), err
+}
+
+// handle parses the message and the line in its body, and sends the line into the Channel
+func (r *Receiver) handle(ctx context.Context, data string, from net.Addr, lc log.Channel, errs log.ErrorChannel) {
+	m, err := Parse(data)
+	if err != nil {
+		errs.Send(ctx, &log.Error{Stage: log.StageParse, Reason: log.ReasonSyslog, Raw: data, Err: err})
+		return
+	}
+	line, err := r.format.Parse(m.Body)
+	if err != nil {
+		errs.Send(ctx, err)
+		return
+	}
+	line.Source = m.Hostname
+	if line.Source == "" {
+		line.Source = from.String()
+		if host, _, err := net.SplitHostPort(line.Source); err == nil {
+			line.Source = host
+		}
+	}
+	select {
+	case lc <- line:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,91 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caitlin615/logmonitor/log"
+)
+
+func receiveLine(t *testing.T, lc log.Channel) log.Line {
+	select {
+	case line := <-lc:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a line")
+	}
+	return log.Line{}
+}
+
+func TestReceiver(t *testing.T) {
+	r, err := Listen("127.0.0.1:0", "127.0.0.1:0", log.CommonFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := make(log.Channel)
+	errs := make(log.ErrorChannel, 1)
+	go r.Receive(ctx, lc, errs)
+
+	udp, err := net.Dial("udp", r.UDPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	fmt.Fprintf(udp, "<190>Oct 19 22:14:15 web1 nginx: %s", accessLine)
+	if line := receiveLine(t, lc); line.Source != "web1" || line.UserID != "frank" {
+		t.Errorf("bad line over UDP: %+v", line)
+	}
+
+	tcp, err := net.Dial("tcp", r.TCPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	// Octet counted and newline terminated messages can be mixed on a connection
+	msg := "<165>1 - - nginx - - - " + accessLine
+	fmt.Fprintf(tcp, "%d %s", len(msg), msg)
+	fmt.Fprintf(tcp, "<190>Oct 19 22:14:15 web2 nginx: %s\n", accessLine)
+	if line := receiveLine(t, lc); line.Source != "127.0.0.1" {
+		t.Errorf("expected the address of the sender without a hostname, got: %+v", line)
+	}
+	if line := receiveLine(t, lc); line.Source != "web2" {
+		t.Errorf("bad newline terminated line over TCP: %+v", line)
+	}
+
+	fmt.Fprintf(tcp, "not syslog\n")
+	select {
+	case err := <-errs:
+		if e, ok := err.(*log.Error); !ok || e.Reason != log.ReasonSyslog {
+			t.Errorf("expected a syslog error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+
+	cancel()
+	for range lc {
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	for _, data := range []string{
+		strings.Repeat("a", 100),       // a message without a newline
+		strings.Repeat("1", 100) + " ", // an endless length
+		"99999999 <190>",               // a length over the limit
+	} {
+		if _, err := readFrame(bufio.NewReaderSize(strings.NewReader(data), 16)); err == nil || err == io.EOF {
+			t.Errorf("expected an error for %q, got: %v", data, err)
+		}
+	}
+	msg, err := readFrame(bufio.NewReaderSize(strings.NewReader("5 hello"), 16))
+	if msg != "hello" || err != nil {
+		t.Errorf("bad frame: %q %v", msg, err)
+	}
+}